@@ -0,0 +1,28 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Store keeps objects by key, keys are slash separated paths e.g. indexed/<id>.jpg
+type Store interface {
+	Put(key string, r io.Reader) error
+	/// Get returns ErrNotFound if there is no object with the key
+	Get(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+	/// Delete succeeds if there is no object with the key
+	Delete(key string) error
+	/// List returns every object whose key starts with the prefix
+	List(prefix string) ([]ObjectInfo, error)
+}
@@ -0,0 +1,74 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorePath(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStore(dir)
+	tests := []struct {
+		key  string
+		want string /// empty if the key is rejected
+	}{
+		{"indexed/a.jpg", filepath.Join(dir, "indexed", "a.jpg")},
+		{"a.jpg", filepath.Join(dir, "a.jpg")},
+		{"indexed/../a.jpg", filepath.Join(dir, "a.jpg")},
+		{"/indexed/a.jpg", filepath.Join(dir, "indexed", "a.jpg")},
+		{"../a.jpg", ""},
+		{"indexed/../../a.jpg", ""},
+		{"..", ""},
+		{"", ""},
+		{".", ""},
+		{"../" + filepath.Base(dir) + "/a.jpg", filepath.Join(dir, "a.jpg")},
+		{"../" + filepath.Base(dir) + "-other/a.jpg", ""},
+	}
+	for _, test := range tests {
+		got, err := store.path(test.key)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: expected the key to be rejected, got %s", test.key, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %s %v, want %s", test.key, got, err, test.want)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	store := NewLocalStore(t.TempDir())
+	if err := store.Put("indexed/a.jpg", strings.NewReader("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("../a.jpg", strings.NewReader("jpeg")); err == nil {
+		t.Error("expected a key outside the dir to be rejected")
+	}
+	r, err := store.Get("indexed/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "jpeg" {
+		t.Errorf("got %q", data)
+	}
+	if _, err = store.Get("indexed/missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	objects, err := store.List("indexed/")
+	if err != nil || len(objects) != 1 || objects[0].Key != "indexed/a.jpg" || objects[0].Size != 4 {
+		t.Errorf("unexpected list %+v %v", objects, err)
+	}
+	if err = store.Delete("indexed/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists("indexed/a.jpg"); exists || err != nil {
+		t.Errorf("expected the object to be deleted, got %t %v", exists, err)
+	}
+}
@@ -0,0 +1,280 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testRegion          = "eu-west-1"
+)
+
+// fakeS3 is an in memory bucket that checks the signature of every request the way S3 does
+type fakeS3 struct {
+	t        *testing.T
+	bucket   string
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	lists   int /// ListObjectsV2 requests
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := verifySignature(r, body); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucketpath := "/" + f.bucket
+	if r.URL.Path == bucketpath && r.Method == http.MethodGet {
+		f.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, bucketpath+"/")
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[key] = body
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list answers ListObjectsV2 a page at a time, the continuation token is the last key of the previous page
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	f.lists++
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is supported", http.StatusBadRequest)
+		return
+	}
+	keys := make([]string, 0)
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > f.pageSize
+	if truncated {
+		keys = keys[:f.pageSize]
+	}
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult>`)
+	for _, key := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2026-10-19T12:00:00.000Z</LastModified></Contents>",
+			key, len(f.objects[key]))
+	}
+	fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated>", truncated)
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+// verifySignature recomputes the signature version 4 of the request from scratch and compares it
+func verifySignature(r *http.Request, body []byte) error {
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("not signed with AWS4-HMAC-SHA256: %q", r.Header.Get("Authorization"))
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		name, val, _ := strings.Cut(field, "=")
+		fields[name] = val
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != testAccessKeyID || credential[2] != testRegion ||
+		credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("unexpected credential %s", fields["Credential"])
+	}
+	payloadhash := sha256.Sum256(body)
+	if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(payloadhash[:]) {
+		return fmt.Errorf("payload hash doesn't match the body")
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	canonicalheaders := ""
+	for _, name := range signed {
+		val := r.Header.Get(name)
+		if name == "host" {
+			val = r.Host
+		}
+		canonicalheaders += name + ":" + strings.TrimSpace(val) + "\n"
+	}
+	params := make([]string, 0)
+	for name, vals := range r.URL.Query() {
+		for _, val := range vals {
+			params = append(params, awsEscape(name)+"="+awsEscape(val))
+		}
+	}
+	sort.Strings(params)
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		canonicalheaders,
+		fields["SignedHeaders"],
+		r.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	canonicalhash := sha256.Sum256([]byte(canonical))
+	scope := strings.Join(credential[1:], "/")
+	tosign := "AWS4-HMAC-SHA256\n" + r.Header.Get("x-amz-date") + "\n" + scope + "\n" + hex.EncodeToString(canonicalhash[:])
+
+	key := []byte("AWS4" + testSecretAccessKey)
+	for _, part := range credential[1:] {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(tosign))
+	if want := hex.EncodeToString(mac.Sum(nil)); fields["Signature"] != want {
+		return fmt.Errorf("signature %s, want %s for canonical request\n%s", fields["Signature"], want, canonical)
+	}
+	return nil
+}
+
+// awsEscape is url.QueryEscape with the differences signature version 4 has
+func awsEscape(s string) string {
+	return strings.NewReplacer("+", "%20", "%7E", "~").Replace(url.QueryEscape(s))
+}
+
+func newTestS3(t *testing.T, prefix string) (*S3Store, *fakeS3) {
+	fake := &fakeS3{t: t, bucket: "uploads", pageSize: 2, objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	store, err := NewS3Store(S3Config{
+		Endpoint:        server.URL + "/",
+		Region:          testRegion,
+		Bucket:          "uploads",
+		Prefix:          prefix,
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: testSecretAccessKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, fake
+}
+
+func TestS3Store(t *testing.T) {
+	keys := []string{"indexed/a.jpg", "indexed/b c+d.png", "indexed/e~f.gif", "queries/g.jpg", "indexed/h.webp"}
+	for _, prefix := range []string{"", "prod/"} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			store, fake := newTestS3(t, prefix)
+			for _, key := range keys {
+				if err := store.Put(key, strings.NewReader("data of "+key)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, ok := fake.objects[prefix+"indexed/a.jpg"]; !ok {
+				t.Errorf("expected the keys to be prefixed, got %v", fake.objects)
+			}
+
+			r, err := store.Get("indexed/b c+d.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(r)
+			r.Close()
+			if string(data) != "data of indexed/b c+d.png" {
+				t.Errorf("got %q", data)
+			}
+
+			exists, err := store.Exists("indexed/a.jpg")
+			if err != nil || !exists {
+				t.Errorf("expected indexed/a.jpg to exist, got %t %v", exists, err)
+			}
+			exists, err = store.Exists("indexed/missing.jpg")
+			if err != nil || exists {
+				t.Errorf("expected indexed/missing.jpg not to exist, got %t %v", exists, err)
+			}
+
+			/// 4 indexed objects in pages of 2, with another prefix in between
+			fake.lists = 0
+			objects, err := store.List("indexed/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := make([]string, 0, len(objects))
+			for _, object := range objects {
+				listed = append(listed, object.Key)
+				if object.Size != int64(len("data of "+object.Key)) || object.Modified.IsZero() {
+					t.Errorf("unexpected object info %+v", object)
+				}
+			}
+			want := []string{"indexed/a.jpg", "indexed/b c+d.png", "indexed/e~f.gif", "indexed/h.webp"}
+			if strings.Join(listed, ",") != strings.Join(want, ",") {
+				t.Errorf("listed %v, want %v", listed, want)
+			}
+			if fake.lists != 2 {
+				t.Errorf("expected 2 pages, got %d", fake.lists)
+			}
+
+			if err = store.Delete("indexed/a.jpg"); err != nil {
+				t.Fatal(err)
+			}
+			if err = store.Delete("indexed/a.jpg"); err != nil {
+				t.Errorf("expected deleting a missing object to succeed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestS3StoreNotFound(t *testing.T) {
+	store, _ := newTestS3(t, "")
+	_, err := store.Get("indexed/missing.jpg")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestS3StoreErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer server.Close()
+	store, err := NewS3Store(S3Config{Endpoint: server.URL, Bucket: "uploads", AccessKeyID: "id", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get("indexed/a.jpg")
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "code 403") || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("expected the error response, got %v", err)
+	}
+	if _, err = store.List("indexed/"); err == nil {
+		t.Error("expected listing to fail")
+	}
+
+	if _, err = NewS3Store(S3Config{Endpoint: server.URL, Bucket: "uploads"}); err == nil {
+		t.Error("expected the credentials to be required")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ModelCfg describes one embedder and the index its vectors are stored in
+type ModelCfg struct {
+	Name        string
+	Backend     string /// hf (default), openai or hf-native
+	URL         string /// the endpoint, for hf-native the feature-extraction endpoint
+	ImageURL    string /// hf-native only, the image-feature-extraction endpoint
+	ClassifyURL string /// hf-native only, optional zero-shot-image-classification endpoint
+	APIKey      string
+	ModelID     string /// part of every cache key and, for openai, the model name sent with requests
+	Host        string /// Pinecone index host, models with different dimensions need different indexes
+	Namespace   string
+}
+
+// id identifies the model in cache keys and vector metadata
+func (m ModelCfg) id() string {
+	if m.ModelID != "" {
+		return m.ModelID
+	}
+	return m.URL
+}
+
+// version is recorded in the metadata of the model's vectors
+func (m ModelCfg) version() service.ModelVersion {
+	return service.ModelVersion{
+		ModelID:    m.id(),
+		Preprocess: service.PreprocessVersion,
+	}
+}
+
+// envModelCfg reads the model selected by EMBEDDER_BACKEND from the environment
+//   - hf (default): the custom handler at HF_OBJ_DETECTION_URL, using HF_APITOKEN
+//   - openai: an OpenAI compatible embeddings API at OPENAI_EMBEDDINGS_URL, using OPENAI_API_KEY and OPENAI_MODEL
+//   - hf-native: stock Hugging Face feature-extraction at HF_FEATURE_EXTRACTION_URL, image-feature-extraction at
+//     HF_IMAGE_FEATURE_EXTRACTION_URL and, optionally, zero-shot-image-classification at HF_CLASSIFICATION_URL, using HF_APITOKEN.
+func envModelCfg() ModelCfg {
+	model := ModelCfg{
+		Name:      "default",
+		Backend:   os.Getenv("EMBEDDER_BACKEND"),
+		ModelID:   os.Getenv("HF_MODEL_ID"),
+		APIKey:    os.Getenv("HF_APITOKEN"),
+		Host:      os.Getenv("PC_HOST"),
+		Namespace: os.Getenv("PC_NAMESPACE"),
+	}
+	switch model.Backend {
+	case "", "hf":
+		model.URL = os.Getenv("HF_OBJ_DETECTION_URL")
+	case "openai":
+		model.URL = os.Getenv("OPENAI_EMBEDDINGS_URL")
+		model.APIKey = os.Getenv("OPENAI_API_KEY")
+		model.ModelID = os.Getenv("OPENAI_MODEL")
+	case "hf-native":
+		model.URL = os.Getenv("HF_FEATURE_EXTRACTION_URL")
+		model.ImageURL = os.Getenv("HF_IMAGE_FEATURE_EXTRACTION_URL")
+		model.ClassifyURL = os.Getenv("HF_CLASSIFICATION_URL")
+	}
+	return model
+}
+
+// newEmbeddingClient creates the model's embedder behind the embedding cache.
+// Cache misses go through a coalescer, so concurrent identical requests share one upstream call.
+// If EMBED_BATCH_SIZE is more than 1 the requests are then batched.
+// The cache is configured with EMBED_CACHE_SIZE (entries kept in memory, default 1000)
+// and EMBED_CACHE_DIR (optional on disk tier). HF_MODEL_LOAD_WAIT caps how long hf-native
+// waits for a model to load (default 5m).
+// The classifier is nil unless the backend can classify images itself.
+// The returned func summarises the cache and upstream usage.
+func newEmbeddingClient(model ModelCfg) (embedding.Client, embedding.Classifier, func() string) {
+	var upstream embedding.Client
+	var classifier embedding.Classifier
+	var openai *embedding.OpenAIEmbedder
+	modelID := model.id()
+	switch model.Backend {
+	case "", "hf":
+		if model.URL == "" || model.APIKey == "" {
+			log.Fatalf("Model %s: the url (HF_OBJ_DETECTION_URL) and api key (HF_APITOKEN) are required for the hf backend", model.Name)
+		}
+		upstream = embedding.NewEmbedder(model.URL, model.APIKey)
+	case "openai":
+		if model.URL == "" || model.ModelID == "" {
+			log.Fatalf("Model %s: the url (OPENAI_EMBEDDINGS_URL) and model (OPENAI_MODEL) are required for the openai backend", model.Name)
+		}
+		openai = embedding.NewOpenAIEmbedder(model.URL, model.APIKey, model.ModelID)
+		upstream = openai
+	case "hf-native":
+		if model.URL == "" || model.ImageURL == "" || model.APIKey == "" {
+			log.Fatalf("Model %s: the urls (HF_FEATURE_EXTRACTION_URL, HF_IMAGE_FEATURE_EXTRACTION_URL) and api key (HF_APITOKEN) are required for the hf-native backend", model.Name)
+		}
+		native := embedding.NewHFTaskEmbedder(model.URL, model.ImageURL, model.ClassifyURL, model.APIKey,
+			envDuration("HF_MODEL_LOAD_WAIT", 5*time.Minute))
+		/// Text and image vectors are compared, so refuse models that don't embed both into one space.
+		/// If the endpoints can't be reached yet, e.g. they are scaled to zero, start anyway.
+		if err := native.CheckJointSpace(); errors.Is(err, embedding.ErrNoJointSpace) {
+			log.Fatalf("Model %s: %v", model.Name, err)
+		} else if err != nil {
+			log.Printf("Model %s: couldn't check for a joint text and image space: %v", model.Name, err)
+		}
+		upstream = native
+		if native.CanClassify() {
+			classifier = native
+		}
+	default:
+		log.Fatalf("Model %s: unknown backend %s", model.Name, model.Backend)
+	}
+
+	if batchsize := envInt("EMBED_BATCH_SIZE", 1); batchsize > 1 {
+		upstream = embedding.NewBatcher(upstream, batchsize,
+			envInt("EMBED_BATCH_BYTES", 8<<20), envDuration("EMBED_BATCH_WAIT", 50*time.Millisecond))
+	}
+	size := envInt("EMBED_CACHE_SIZE", 1000)
+	coalescer := embedding.NewCoalescer(upstream, modelID)
+	cache := embedding.NewCache(coalescer, modelID, size, os.Getenv("EMBED_CACHE_DIR"))
+	stats := func() string {
+		summary := model.Name + " " + cache.Stats().String()
+		if openai != nil {
+			summary += "\n" + openai.Usage().String()
+		}
+		return summary
+	}
+	return cache, classifier, stats
+}
+
+// logStats logs the embedding stats every interval, it never returns so run it in a go routine
+func logStats(stats func() string, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		log.Println(stats())
+	}
+}
+
+// newVersionedHandler creates a service handler that records the model's version in every vector it upserts.
+// MODEL_VERSION_POLICY decides what searches do about vectors of other versions, filter (default), filter-legacy, refuse or off.
+func newVersionedHandler(model ModelCfg, embedder embedding.Client, pc *vectordb.PineconeDB) *service.Handler {
+	policy := os.Getenv("MODEL_VERSION_POLICY")
+	if policy == "" {
+		policy = string(service.VERSION_POLICY_FILTER)
+	}
+	svc := service.NewHandler(embedder, pc)
+	err := svc.SetVersion(model.version(), service.VersionPolicy(policy))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return svc
+}
+
+// newUploadStore creates the blob store for uploads selected by BLOB_BACKEND
+//   - local (default): files in UPLOAD_DIR
+//   - s3: an S3 compatible bucket, S3_BUCKET at S3_ENDPOINT in S3_REGION, using S3_ACCESS_KEY_ID and
+//     S3_SECRET_ACCESS_KEY, with keys optionally prefixed by S3_PREFIX
+//
+// Temporary copies of the uploads are kept in the system temp dir with either backend, so they are never
+// mistaken for uploads in UPLOAD_DIR.
+func newUploadStore() *uploads.Store {
+	var blobs blob.Store
+	switch os.Getenv("BLOB_BACKEND") {
+	case "", "local":
+		uploaddir := os.Getenv("UPLOAD_DIR")
+		if uploaddir == "" {
+			log.Fatal("UPLOAD_DIR is required with the local backend")
+		}
+		blobs = blob.NewLocalStore(uploaddir)
+	case "s3":
+		s3, err := blob.NewS3Store(blob.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Prefix:          os.Getenv("S3_PREFIX"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		blobs = s3
+	default:
+		log.Fatalf("Unknown BLOB_BACKEND %s, use local or s3", os.Getenv("BLOB_BACKEND"))
+	}
+	return uploads.NewStore(blobs, os.TempDir())
+}
+
+func envInt(name string, defaultval int) int {
+	valstr := os.Getenv(name)
+	if valstr == "" {
+		return defaultval
+	}
+	val, err := strconv.Atoi(valstr)
+	if err != nil {
+		log.Fatal("Invalid ", name, " ", valstr)
+	}
+	return val
+}
+
+func envDuration(name string, defaultval time.Duration) time.Duration {
+	valstr := os.Getenv(name)
+	if valstr == "" {
+		return defaultval
+	}
+	val, err := time.ParseDuration(valstr)
+	if err != nil {
+		log.Fatal("Invalid ", name, " ", valstr)
+	}
+	return val
+}
@@ -0,0 +1,149 @@
+package dataset
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Annotation is a single labelled bounding box within a source image
+type Annotation struct {
+	ImagePath    string
+	Label        string
+	Box          image.Rectangle
+	AnnotationID string
+	Crowd        bool
+}
+
+type AnnotationFilter struct {
+	MinBoxSize   int  /// boxes narrower or shorter than this many pixels are skipped
+	IncludeCrowd bool /// COCO iscrowd boxes cover a group of objects and are skipped by default
+}
+
+// Filter returns the annotations that pass the small box and crowd filters
+func (f AnnotationFilter) Filter(annotations []Annotation) (kept []Annotation, skipped int) {
+	for _, annotation := range annotations {
+		if annotation.Box.Dx() < f.MinBoxSize || annotation.Box.Dy() < f.MinBoxSize ||
+			annotation.Box.Empty() {
+			skipped++
+			continue
+		}
+		if annotation.Crowd && !f.IncludeCrowd {
+			skipped++
+			continue
+		}
+		kept = append(kept, annotation)
+	}
+	return kept, skipped
+}
+
+// Crop is an annotation cropped out of its source image and written to disk
+type Crop struct {
+	Annotation
+	Path string
+	ID   string
+}
+
+// WriteCrops crops every annotation out of its source image and saves it as a PNG in outdir.
+// Each source image is decoded once, however many annotations it has.
+// Crop IDs are derived from the source image content and the annotation ID, so they are stable across runs.
+// Boxes entirely outside their image are skipped with a warning and counted as failed.
+func WriteCrops(annotations []Annotation, outdir string) (crops []Crop, failed int, err error) {
+	byimage := make(map[string][]Annotation)
+	order := make([]string, 0)
+	for _, annotation := range annotations {
+		if _, exists := byimage[annotation.ImagePath]; !exists {
+			order = append(order, annotation.ImagePath)
+		}
+		byimage[annotation.ImagePath] = append(byimage[annotation.ImagePath], annotation)
+	}
+
+	crops = make([]Crop, 0, len(annotations))
+	for _, imagepath := range order {
+		src, err := decodeImage(imagepath)
+		if err != nil {
+			return crops, failed, err
+		}
+		sourceID, err := ContentID(imagepath)
+		if err != nil {
+			return crops, failed, err
+		}
+		for _, annotation := range byimage[imagepath] {
+			cropped := cropImage(src, annotation.Box)
+			if cropped.Bounds().Empty() {
+				log.Printf("Skipping annotation %s, the box %v is outside the image %s %v\n",
+					annotation.AnnotationID, annotation.Box, imagepath, src.Bounds())
+				failed++
+				continue
+			}
+			id := sourceID[:16] + "-" + sanitize(annotation.AnnotationID)
+			path := filepath.Join(outdir, id+".png")
+			err = writePNG(path, cropped)
+			if err != nil {
+				return crops, failed, err
+			}
+			crops = append(crops, Crop{Annotation: annotation, Path: path, ID: id})
+		}
+	}
+	return crops, failed, nil
+}
+
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image: %w", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image %s: %w", path, err)
+	}
+	return img, nil
+}
+
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// cropImage returns the part of img inside box, clipped to the image bounds
+func cropImage(img image.Image, box image.Rectangle) image.Image {
+	box = box.Add(img.Bounds().Min).Intersect(img.Bounds())
+	if sub, ok := img.(subImager); ok {
+		return sub.SubImage(box)
+	}
+	cropped := image.NewRGBA(image.Rect(0, 0, box.Dx(), box.Dy()))
+	for y := 0; y < box.Dy(); y++ {
+		for x := 0; x < box.Dx(); x++ {
+			cropped.Set(x, y, img.At(box.Min.X+x, box.Min.Y+y))
+		}
+	}
+	return cropped
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating crop file: %w", err)
+	}
+	defer f.Close()
+	if err = png.Encode(f, img); err != nil {
+		return fmt.Errorf("error encoding crop %s: %w", path, err)
+	}
+	return nil
+}
+
+// sanitize maps anything that isn't safe in an ID or file name to '-'
+func sanitize(s string) string {
+	out := []rune(s)
+	for i, r := range out {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_') {
+			out[i] = '-'
+		}
+	}
+	return string(out)
+}
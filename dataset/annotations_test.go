@@ -0,0 +1,209 @@
+package dataset
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadCOCO(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    []Annotation
+		wantErr string
+	}{
+		{
+			name: "boxes are rounded outwards",
+			json: `{"images": [{"id": 1, "file_name": "a.jpg"}],
+				"annotations": [{"id": 10, "image_id": 1, "category_id": 3, "bbox": [1.5, 2.2, 10, 20.1], "iscrowd": 0},
+					{"id": 11, "image_id": 1, "category_id": 3, "bbox": [0, 0, 5, 5], "iscrowd": 1}],
+				"categories": [{"id": 3, "name": "car"}]}`,
+			want: []Annotation{
+				{ImagePath: filepath.Join("imgs", "a.jpg"), Label: "car", Box: image.Rect(1, 2, 12, 23), AnnotationID: "10"},
+				{ImagePath: filepath.Join("imgs", "a.jpg"), Label: "car", Box: image.Rect(0, 0, 5, 5), AnnotationID: "11", Crowd: true},
+			},
+		},
+		{
+			name:    "unknown image",
+			json:    `{"images": [], "annotations": [{"id": 10, "image_id": 1, "category_id": 3, "bbox": [0, 0, 1, 1]}], "categories": [{"id": 3, "name": "car"}]}`,
+			wantErr: "unknown image 1",
+		},
+		{
+			name:    "unknown category",
+			json:    `{"images": [{"id": 1, "file_name": "a.jpg"}], "annotations": [{"id": 10, "image_id": 1, "category_id": 4, "bbox": [0, 0, 1, 1]}], "categories": []}`,
+			wantErr: "unknown category 4",
+		},
+		{
+			name:    "short bbox",
+			json:    `{"images": [{"id": 1, "file_name": "a.jpg"}], "annotations": [{"id": 10, "image_id": 1, "category_id": 3, "bbox": [0, 0, 1]}], "categories": [{"id": 3, "name": "car"}]}`,
+			wantErr: "invalid bbox",
+		},
+		{
+			name:    "not json",
+			json:    `<annotation/>`,
+			wantErr: "error parsing COCO file",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "coco.json")
+			writeFile(t, file, test.json)
+			got, err := LoadCOCO(file, "imgs")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadVOC(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    []Annotation
+		wantErr string
+	}{
+		{
+			name: "objects are numbered per file",
+			files: map[string]string{
+				"b.xml": `<annotation><filename>b.jpg</filename>
+					<object><name> dog </name><bndbox><xmin>1</xmin><ymin>2</ymin><xmax>3.5</xmax><ymax>4</ymax></bndbox></object>
+				</annotation>`,
+				"a.xml": `<annotation><filename>a.jpg</filename>
+					<object><name>car</name><bndbox><xmin>10</xmin><ymin>20</ymin><xmax>110</xmax><ymax>90</ymax></bndbox></object>
+					<object><name>cat</name><bndbox><xmin>0</xmin><ymin>0</ymin><xmax>5</xmax><ymax>5</ymax></bndbox></object>
+				</annotation>`,
+				"notes.txt": "not an annotation",
+			},
+			want: []Annotation{
+				{ImagePath: filepath.Join("imgs", "a.jpg"), Label: "car", Box: image.Rect(10, 20, 110, 90), AnnotationID: "a-0"},
+				{ImagePath: filepath.Join("imgs", "a.jpg"), Label: "cat", Box: image.Rect(0, 0, 5, 5), AnnotationID: "a-1"},
+				{ImagePath: filepath.Join("imgs", "b.jpg"), Label: "dog", Box: image.Rect(1, 2, 4, 4), AnnotationID: "b-0"},
+			},
+		},
+		{
+			name:  "no files",
+			files: map[string]string{},
+			want:  []Annotation{},
+		},
+		{
+			name:    "broken xml",
+			files:   map[string]string{"a.xml": `<annotation><filename>a.jpg</filename>`},
+			wantErr: "error parsing VOC file",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range test.files {
+				writeFile(t, filepath.Join(dir, name), content)
+			}
+			got, err := LoadVOC(dir, "imgs")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnnotationFilter(t *testing.T) {
+	annotations := []Annotation{
+		{AnnotationID: "big", Box: image.Rect(0, 0, 10, 10)},
+		{AnnotationID: "narrow", Box: image.Rect(0, 0, 2, 10)},
+		{AnnotationID: "empty", Box: image.Rect(5, 5, 5, 5)},
+		{AnnotationID: "crowd", Box: image.Rect(0, 0, 10, 10), Crowd: true},
+	}
+	tests := []struct {
+		filter      AnnotationFilter
+		wantIDs     []string
+		wantSkipped int
+	}{
+		{AnnotationFilter{}, []string{"big", "narrow"}, 2},
+		{AnnotationFilter{MinBoxSize: 4}, []string{"big"}, 3},
+		{AnnotationFilter{MinBoxSize: 4, IncludeCrowd: true}, []string{"big", "crowd"}, 2},
+	}
+	for _, test := range tests {
+		kept, skipped := test.filter.Filter(annotations)
+		ids := make([]string, 0, len(kept))
+		for _, annotation := range kept {
+			ids = append(ids, annotation.AnnotationID)
+		}
+		if !reflect.DeepEqual(ids, test.wantIDs) || skipped != test.wantSkipped {
+			t.Errorf("%+v: kept %v skipped %d, want %v skipped %d", test.filter, ids, skipped, test.wantIDs, test.wantSkipped)
+		}
+	}
+}
+
+func TestWriteCropsSkipsBoxesOutsideTheImage(t *testing.T) {
+	dir := t.TempDir()
+	src := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for x := 0; x < 20; x++ {
+		src.Set(x, 5, color.White)
+	}
+	imagepath := filepath.Join(dir, "src.png")
+	f, err := os.Create(imagepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	annotations := []Annotation{
+		{ImagePath: imagepath, Label: "inside", Box: image.Rect(2, 2, 8, 6), AnnotationID: "1"},
+		{ImagePath: imagepath, Label: "outside", Box: image.Rect(30, 0, 40, 10), AnnotationID: "2"},
+		{ImagePath: imagepath, Label: "clipped", Box: image.Rect(15, -5, 25, 5), AnnotationID: "3"},
+	}
+	crops, failed, err := WriteCrops(annotations, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed != 1 {
+		t.Errorf("expected 1 failed crop, got %d", failed)
+	}
+	sizes := map[string]image.Point{"inside": {6, 4}, "clipped": {5, 5}}
+	if len(crops) != len(sizes) {
+		t.Fatalf("expected %d crops, got %+v", len(sizes), crops)
+	}
+	for _, crop := range crops {
+		img, err := decodeImage(crop.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Size() != sizes[crop.Label] {
+			t.Errorf("crop %s is %v, want %v", crop.Label, img.Bounds().Size(), sizes[crop.Label])
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package dataset
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/**
+Only the parts of the COCO format needed for the crops are read:
+{
+	"images": [{"id": 1, "file_name": "000001.jpg"}],
+	"annotations": [{"id": 10, "image_id": 1, "category_id": 3, "bbox": [x, y, width, height], "iscrowd": 0}],
+	"categories": [{"id": 3, "name": "car"}]
+}
+*/
+
+type cocoFile struct {
+	Images []struct {
+		ID       int64  `json:"id"`
+		FileName string `json:"file_name"`
+	} `json:"images"`
+	Annotations []struct {
+		ID         int64     `json:"id"`
+		ImageID    int64     `json:"image_id"`
+		CategoryID int64     `json:"category_id"`
+		BBox       []float64 `json:"bbox"`
+		IsCrowd    int       `json:"iscrowd"`
+	} `json:"annotations"`
+	Categories []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"categories"`
+}
+
+// LoadCOCO reads a COCO annotations file. Image file names are resolved relative to imagedir.
+func LoadCOCO(annotationfile string, imagedir string) ([]Annotation, error) {
+	rawdata, err := os.ReadFile(annotationfile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading COCO file: %w", err)
+	}
+	coco := cocoFile{}
+	if err = json.Unmarshal(rawdata, &coco); err != nil {
+		return nil, fmt.Errorf("error parsing COCO file: %w", err)
+	}
+	images := make(map[int64]string)
+	for _, img := range coco.Images {
+		images[img.ID] = filepath.Join(imagedir, img.FileName)
+	}
+	categories := make(map[int64]string)
+	for _, category := range coco.Categories {
+		categories[category.ID] = category.Name
+	}
+
+	annotations := make([]Annotation, 0, len(coco.Annotations))
+	for _, ann := range coco.Annotations {
+		imagepath, ok := images[ann.ImageID]
+		if !ok {
+			return nil, fmt.Errorf("annotation %d refers to unknown image %d", ann.ID, ann.ImageID)
+		}
+		label, ok := categories[ann.CategoryID]
+		if !ok {
+			return nil, fmt.Errorf("annotation %d refers to unknown category %d", ann.ID, ann.CategoryID)
+		}
+		if len(ann.BBox) != 4 {
+			return nil, fmt.Errorf("annotation %d has an invalid bbox %v", ann.ID, ann.BBox)
+		}
+		x, y, w, h := ann.BBox[0], ann.BBox[1], ann.BBox[2], ann.BBox[3]
+		annotations = append(annotations, Annotation{
+			ImagePath:    imagepath,
+			Label:        label,
+			Box:          boxFromCorners(x, y, x+w, y+h),
+			AnnotationID: strconv.FormatInt(ann.ID, 10),
+			Crowd:        ann.IsCrowd != 0,
+		})
+	}
+	return annotations, nil
+}
+
+// boxFromCorners rounds a floating point box outwards to whole pixels
+func boxFromCorners(xmin, ymin, xmax, ymax float64) image.Rectangle {
+	return image.Rect(int(math.Floor(xmin)), int(math.Floor(ymin)), int(math.Ceil(xmax)), int(math.Ceil(ymax)))
+}
@@ -0,0 +1,198 @@
+package dataset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultExtensions are the image file extensions picked up when walking a dataset
+var DefaultExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// Sample is a single labelled image from a dataset
+type Sample struct {
+	Path  string
+	Label string
+	ID    string /// derived from the image content, so it is stable across runs and renames
+}
+
+type WalkOptions struct {
+	Extensions []string /// defaults to DefaultExtensions
+	Include    []string /// glob patterns, if set a file must match at least one
+	Exclude    []string /// glob patterns, a file matching any of these is skipped
+}
+
+/**
+Folder-per-label layout, the name of each top level folder is the label:
+
+	root/
+		cat/
+			img1.jpg
+			img2.png
+		dog/
+			img3.jpg
+*/
+
+// LoadFolders walks a folder-per-label dataset and returns a sample for every image found.
+// Images nested deeper than the label folder take the label of their top level folder.
+// Glob patterns are matched against both the path relative to root and the file name.
+func LoadFolders(root string, opts WalkOptions) ([]Sample, error) {
+	exts := DefaultExtensions
+	if len(opts.Extensions) > 0 {
+		exts = opts.Extensions
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dataset dir: %w", err)
+	}
+	samples := make([]Sample, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		label := entry.Name()
+		err = filepath.WalkDir(filepath.Join(root, label), func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !hasExtension(path, exts) {
+				return nil
+			}
+			relpath, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if len(opts.Include) > 0 && !matchesAny(relpath, opts.Include) {
+				return nil
+			}
+			if matchesAny(relpath, opts.Exclude) {
+				return nil
+			}
+			id, err := ContentID(path)
+			if err != nil {
+				return err
+			}
+			samples = append(samples, Sample{Path: path, Label: label, ID: id})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error walking label dir %s: %w", label, err)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Path < samples[j].Path
+	})
+	return samples, nil
+}
+
+// IsImage is true if the path has one of the DefaultExtensions
+func IsImage(path string) bool {
+	return hasExtension(path, DefaultExtensions)
+}
+
+func hasExtension(path string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range exts {
+		if ext == strings.ToLower(e) || ext == "."+strings.ToLower(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(relpath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, filepath.ToSlash(relpath)); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(relpath)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentID returns a stable ID derived from the SHA-256 of the file content
+func ContentID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("error hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:32], nil
+}
+
+// StringID returns a stable ID derived from the SHA-256 of s, for images that aren't on disk yet
+func StringID(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])[:32]
+}
+
+// SplitList splits a comma separated flag value, ignoring empty entries
+func SplitList(csv string) []string {
+	list := make([]string, 0)
+	for _, entry := range strings.Split(csv, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// Split holds out a fraction of each label's samples for testing.
+// Every label with more than one sample keeps at least one sample on each side of the split.
+// The same seed always gives the same split.
+func Split(samples []Sample, holdout float64, seed int64) (train []Sample, test []Sample) {
+	bylabel := make(map[string][]Sample)
+	labels := make([]string, 0)
+	for _, sample := range samples {
+		if _, exists := bylabel[sample.Label]; !exists {
+			labels = append(labels, sample.Label)
+		}
+		bylabel[sample.Label] = append(bylabel[sample.Label], sample)
+	}
+	sort.Strings(labels)
+
+	rnd := rand.New(rand.NewSource(seed))
+	for _, label := range labels {
+		group := bylabel[label]
+		rnd.Shuffle(len(group), func(i, j int) {
+			group[i], group[j] = group[j], group[i]
+		})
+		ntest := int(float64(len(group))*holdout + 0.5)
+		if ntest == 0 && len(group) > 1 && holdout > 0 {
+			ntest = 1
+		}
+		if ntest >= len(group) && len(group) > 1 {
+			ntest = len(group) - 1
+		}
+		test = append(test, group[:ntest]...)
+		train = append(train, group[ntest:]...)
+	}
+	return train, test
+}
+
+// Labels returns the distinct labels in the samples, sorted
+func Labels(samples []Sample) []string {
+	seen := make(map[string]bool)
+	labels := make([]string, 0)
+	for _, sample := range samples {
+		if !seen[sample.Label] {
+			seen[sample.Label] = true
+			labels = append(labels, sample.Label)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
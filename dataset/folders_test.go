@@ -0,0 +1,230 @@
+package dataset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// labelled returns n samples for each label, in the order given
+func labelled(counts map[string]int) []Sample {
+	samples := make([]Sample, 0)
+	for label, n := range counts {
+		for i := 0; i < n; i++ {
+			id := fmt.Sprintf("%s-%d", label, i)
+			samples = append(samples, Sample{Path: id + ".jpg", Label: label, ID: id})
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].ID < samples[j].ID
+	})
+	return samples
+}
+
+func countLabels(samples []Sample) map[string]int {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		counts[sample.Label]++
+	}
+	return counts
+}
+
+func sampleIDs(samples []Sample) []string {
+	ids := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		ids = append(ids, sample.ID)
+	}
+	return ids
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		counts   map[string]int
+		holdout  float64
+		wantTest map[string]int /// test samples per label, the rest are train
+	}{
+		{"a fifth of each label", map[string]int{"cat": 10, "dog": 5}, 0.2, map[string]int{"cat": 2, "dog": 1}},
+		{"rounded to the nearest sample", map[string]int{"cat": 7, "dog": 3}, 0.3, map[string]int{"cat": 2, "dog": 1}},
+		{"at least one test sample", map[string]int{"cat": 10, "dog": 2}, 0.01, map[string]int{"cat": 1, "dog": 1}},
+		{"at least one train sample", map[string]int{"cat": 10, "dog": 2}, 0.99, map[string]int{"cat": 9, "dog": 1}},
+		{"everything held out", map[string]int{"cat": 4}, 1, map[string]int{"cat": 3}},
+		{"nothing held out", map[string]int{"cat": 4, "dog": 1}, 0, map[string]int{}},
+		{"a single sample under half", map[string]int{"cat": 4, "dog": 1}, 0.25, map[string]int{"cat": 1}},
+		{"a single sample from half", map[string]int{"cat": 4, "dog": 1}, 0.5, map[string]int{"cat": 2, "dog": 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples := labelled(test.counts)
+			before := sampleIDs(samples)
+			train, held := Split(samples, test.holdout, 42)
+
+			if got := countLabels(held); !reflect.DeepEqual(got, test.wantTest) {
+				t.Errorf("held out %v, want %v", got, test.wantTest)
+			}
+			for label, n := range test.counts {
+				if got := countLabels(train)[label]; got != n-test.wantTest[label] {
+					t.Errorf("%d %s train samples, want %d", got, label, n-test.wantTest[label])
+				}
+			}
+			all := append(sampleIDs(train), sampleIDs(held)...)
+			sort.Strings(all)
+			if !reflect.DeepEqual(all, before) {
+				t.Errorf("the split isn't a partition of the samples: %v", all)
+			}
+			if !reflect.DeepEqual(sampleIDs(samples), before) {
+				t.Error("Split reordered the samples it was given")
+			}
+		})
+	}
+}
+
+func TestSplitIsSeeded(t *testing.T) {
+	samples := labelled(map[string]int{"cat": 20, "dog": 20, "bird": 20})
+	train, held := Split(samples, 0.25, 7)
+	for i := 0; i < 3; i++ {
+		again, againHeld := Split(samples, 0.25, 7)
+		if !reflect.DeepEqual(sampleIDs(again), sampleIDs(train)) || !reflect.DeepEqual(sampleIDs(againHeld), sampleIDs(held)) {
+			t.Fatal("the same seed gave a different split")
+		}
+	}
+	/// A different seed picks other samples, with the same number per label
+	_, other := Split(samples, 0.25, 8)
+	if reflect.DeepEqual(sampleIDs(other), sampleIDs(held)) {
+		t.Error("a different seed gave the same split")
+	}
+	if !reflect.DeepEqual(countLabels(other), countLabels(held)) {
+		t.Errorf("a different seed held out %v, want %v", countLabels(other), countLabels(held))
+	}
+}
+
+// datasetTree writes a folder-per-label dataset, each file's content is its own path so every ID differs
+func datasetTree(t *testing.T, files []string) string {
+	root := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, path, file)
+	}
+	return root
+}
+
+func TestLoadFolders(t *testing.T) {
+	files := []string{
+		"cat/a.jpg",
+		"cat/b.PNG",
+		"cat/notes.txt",
+		"cat/raw/c.jpeg",
+		"cat/raw/d.webp",
+		"dog/e.gif",
+		"dog/f.bmp",
+		"dog/.thumbs/e.jpg",
+		"loose.jpg",
+	}
+	tests := []struct {
+		name string
+		opts WalkOptions
+		want []string /// labelled paths relative to the root
+	}{
+		{"default extensions", WalkOptions{},
+			[]string{"cat/a.jpg", "cat/b.PNG", "cat/raw/c.jpeg", "cat/raw/d.webp", "dog/.thumbs/e.jpg", "dog/e.gif"}},
+		{"extensions with and without a dot", WalkOptions{Extensions: []string{"jpg", ".BMP"}},
+			[]string{"cat/a.jpg", "dog/.thumbs/e.jpg", "dog/f.bmp"}},
+		{"include by file name", WalkOptions{Include: []string{"*.jpg"}},
+			[]string{"cat/a.jpg", "dog/.thumbs/e.jpg"}},
+		{"include by relative path", WalkOptions{Include: []string{"cat/raw/*"}},
+			[]string{"cat/raw/c.jpeg", "cat/raw/d.webp"}},
+		{"exclude by relative path", WalkOptions{Exclude: []string{"cat/raw/*", "dog/.thumbs/*"}},
+			[]string{"cat/a.jpg", "cat/b.PNG", "dog/e.gif"}},
+		{"exclude wins over include", WalkOptions{Include: []string{"*.jpg", "*.jpeg"}, Exclude: []string{"c.*"}},
+			[]string{"cat/a.jpg", "dog/.thumbs/e.jpg"}},
+		{"nothing matches", WalkOptions{Include: []string{"*.tiff"}}, []string{}},
+	}
+	root := datasetTree(t, files)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			samples, err := LoadFolders(root, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(samples))
+			for _, sample := range samples {
+				relpath, err := filepath.Rel(root, sample.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				relpath = filepath.ToSlash(relpath)
+				if label := relpath[:strings.Index(relpath, "/")]; sample.Label != label {
+					t.Errorf("%s is labelled %s, want %s", relpath, sample.Label, label)
+				}
+				if id, _ := ContentID(sample.Path); sample.ID != id {
+					t.Errorf("%s has ID %s, want its content ID %s", relpath, sample.ID, id)
+				}
+				got = append(got, relpath)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+	if _, err := LoadFolders(filepath.Join(root, "missing"), WalkOptions{}); err == nil {
+		t.Error("expected an error for a missing root")
+	}
+}
+
+func TestContentIDIsStableAcrossRenames(t *testing.T) {
+	root := datasetTree(t, []string{"cat/a.jpg", "cat/b.jpg"})
+	before, err := LoadFolders(root, WalkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	/// Moving an image to another name and label keeps its ID, the ID is the content's
+	if err = os.MkdirAll(filepath.Join(root, "kitten"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filepath.Join(root, "cat", "a.jpg"), filepath.Join(root, "kitten", "renamed.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := LoadFolders(root, WalkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 2 || len(after) != 2 {
+		t.Fatalf("expected 2 samples before and after, got %d and %d", len(before), len(after))
+	}
+	if after[1].Label != "kitten" || after[1].ID != before[0].ID || after[0].ID != before[1].ID {
+		t.Errorf("IDs changed with the rename: %+v, then %+v", before, after)
+	}
+	if before[0].ID == before[1].ID || len(before[0].ID) != 32 {
+		t.Errorf("unexpected IDs %s and %s", before[0].ID, before[1].ID)
+	}
+	/// The same content under another name gets the same ID
+	writeFile(t, filepath.Join(root, "cat", "copy.jpg"), "cat/b.jpg")
+	if id, err := ContentID(filepath.Join(root, "cat", "copy.jpg")); err != nil || id != before[1].ID {
+		t.Errorf("a copy has ID %s %v, want %s", id, err, before[1].ID)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"jpg", []string{"jpg"}},
+		{"jpg,png", []string{"jpg", "png"}},
+		{" *.jpg , raw/* ,", []string{"*.jpg", "raw/*"}},
+		{",,, ,", []string{}},
+	}
+	for _, test := range tests {
+		if got := SplitList(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
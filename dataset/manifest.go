@@ -0,0 +1,302 @@
+package dataset
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"object-detection-zero-shot/service"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/**
+A manifest has one row per image, as CSV with a header row:
+
+	image,label,id,colour,site
+	photos/forklift1.jpg,forklift,fl-1,red,warehouse-a
+	https://example.com/pallet.jpg,pallet,,,warehouse-b
+
+or as JSONL, one object per line:
+
+	{"image": "photos/forklift1.jpg", "label": "forklift", "id": "fl-1", "colour": "red"}
+
+image and label are required. id is optional, and generated from the image when empty.
+Every other column is stored as vector metadata. Relative image paths are relative to the manifest.
+*/
+
+const (
+	ColumnImage = "image"
+	ColumnLabel = "label"
+	ColumnID    = "id"
+)
+
+// ReservedMetadata are the metadata keys the service sets on every vector, a manifest can't override them
+var ReservedMetadata = []string{
+	service.METADATA_VALUE,
+	service.METADATA_KIND,
+	service.METADATA_MODEL_ID,
+	service.METADATA_DIMENSION,
+	service.METADATA_PREPROCESS,
+	service.METADATA_IMAGE_FILE,
+	service.METADATA_OBJECT_KEY,
+}
+
+// Image downloads, see FetchImage
+const (
+	MaxDownloadBytes = 50 << 20
+	DownloadTimeout  = time.Minute
+)
+
+var downloadClient = &http.Client{Timeout: DownloadTimeout}
+
+// ManifestRow is a single validated row of a manifest
+type ManifestRow struct {
+	Line     int
+	Image    string
+	Label    string
+	ID       string
+	Metadata map[string]interface{}
+}
+
+// IsURL is true when the image needs downloading before it can be embedded
+func (r ManifestRow) IsURL() bool {
+	return strings.HasPrefix(r.Image, "http://") || strings.HasPrefix(r.Image, "https://")
+}
+
+// RowError records why a row of the manifest can't be used
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+}
+
+// LoadManifest reads and validates every row of a CSV or JSONL manifest.
+// Bad rows are reported in the returned row errors rather than stopping the load,
+// the error is only set if the manifest itself can't be read.
+func LoadManifest(path string) ([]ManifestRow, []RowError, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening manifest: %w", err)
+	}
+	defer f.Close()
+
+	var rows []ManifestRow
+	var rowerrs []RowError
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, rowerrs, err = readCSV(f)
+	case ".jsonl", ".ndjson":
+		rows, rowerrs, err = readJSONL(f)
+	default:
+		return nil, nil, fmt.Errorf("unknown manifest type %s, expected .csv or .jsonl", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	basedir := filepath.Dir(path)
+	valid := make([]ManifestRow, 0, len(rows))
+	ids := make(map[string]int)
+	for _, row := range rows {
+		if !row.IsURL() && !filepath.IsAbs(row.Image) {
+			row.Image = filepath.Join(basedir, row.Image)
+		}
+		if err := row.validate(); err != nil {
+			rowerrs = append(rowerrs, RowError{Line: row.Line, Err: err})
+			continue
+		}
+		if row.ID != "" {
+			if line, exists := ids[row.ID]; exists {
+				rowerrs = append(rowerrs, RowError{Line: row.Line, Err: fmt.Errorf("duplicate id %s, first used on line %d", row.ID, line)})
+				continue
+			}
+			ids[row.ID] = row.Line
+		}
+		valid = append(valid, row)
+	}
+	return valid, rowerrs, nil
+}
+
+func (r ManifestRow) validate() error {
+	if r.Image == "" {
+		return fmt.Errorf("missing %s", ColumnImage)
+	}
+	if r.Label == "" {
+		return fmt.Errorf("missing %s", ColumnLabel)
+	}
+	if r.ID != "" && sanitize(r.ID) != r.ID {
+		return fmt.Errorf("id %s may only contain letters, digits, '-' and '_'", r.ID)
+	}
+	if r.IsURL() {
+		if _, err := url.ParseRequestURI(r.Image); err != nil {
+			return fmt.Errorf("invalid image url: %w", err)
+		}
+	} else if info, err := os.Stat(r.Image); err != nil {
+		return fmt.Errorf("image not found: %w", err)
+	} else if info.IsDir() {
+		return fmt.Errorf("image %s is a directory", r.Image)
+	}
+	for key, val := range r.Metadata {
+		if contains(ReservedMetadata, key) {
+			return fmt.Errorf("metadata column '%s' is reserved, the service sets it", key)
+		}
+		switch v := val.(type) {
+		case string, float64, bool:
+		case []interface{}:
+			/// lists are only allowed as lists of strings
+			for _, entry := range v {
+				if _, ok := entry.(string); !ok {
+					return fmt.Errorf("metadata %s must be a list of strings", key)
+				}
+			}
+		default:
+			return fmt.Errorf("metadata %s has unsupported type %T", key, val)
+		}
+	}
+	return nil
+}
+
+func readCSV(r io.Reader) ([]ManifestRow, []RowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1 /// short rows are reported as row errors
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading manifest header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	if !contains(header, ColumnImage) || !contains(header, ColumnLabel) {
+		return nil, nil, fmt.Errorf("manifest header must have %s and %s columns", ColumnImage, ColumnLabel)
+	}
+
+	rows := make([]ManifestRow, 0)
+	rowerrs := make([]RowError, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rowerrs = append(rowerrs, RowError{Line: line, Err: err})
+			continue
+		}
+		if len(record) != len(header) {
+			rowerrs = append(rowerrs, RowError{Line: line, Err: fmt.Errorf("expected %d columns, got %d", len(header), len(record))})
+			continue
+		}
+		row := ManifestRow{Line: line, Metadata: make(map[string]interface{})}
+		for i, column := range header {
+			val := strings.TrimSpace(record[i])
+			switch column {
+			case ColumnImage:
+				row.Image = val
+			case ColumnLabel:
+				row.Label = val
+			case ColumnID:
+				row.ID = val
+			default:
+				if val != "" {
+					row.Metadata[column] = val
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowerrs, nil
+}
+
+func readJSONL(r io.Reader) ([]ManifestRow, []RowError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10<<20)
+	rows := make([]ManifestRow, 0)
+	rowerrs := make([]RowError, 0)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(text, &fields); err != nil {
+			rowerrs = append(rowerrs, RowError{Line: line, Err: err})
+			continue
+		}
+		row := ManifestRow{Line: line, Metadata: make(map[string]interface{})}
+		ok := true
+		for key, val := range fields {
+			switch strings.ToLower(key) {
+			case ColumnImage:
+				row.Image, ok = val.(string)
+			case ColumnLabel:
+				row.Label, ok = val.(string)
+			case ColumnID:
+				row.ID, ok = val.(string)
+			default:
+				row.Metadata[key] = val
+				ok = true
+			}
+			if !ok {
+				break
+			}
+		}
+		if !ok {
+			rowerrs = append(rowerrs, RowError{Line: line, Err: fmt.Errorf("%s, %s and %s must be strings", ColumnImage, ColumnLabel, ColumnID)})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	return rows, rowerrs, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, entry := range list {
+		if entry == s {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchImage downloads an image URL into dir and returns the path of the downloaded file.
+// Downloads larger than MaxDownloadBytes or slower than DownloadTimeout fail.
+func FetchImage(imageurl string, dir string) (string, error) {
+	resp, err := downloadClient.Get(imageurl)
+	if err != nil {
+		return "", fmt.Errorf("error downloading %s: %w", imageurl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return "", fmt.Errorf("error downloading %s: status %d", imageurl, resp.StatusCode)
+	}
+	f, err := os.CreateTemp(dir, "download-*"+filepath.Ext(resp.Request.URL.Path))
+	if err != nil {
+		return "", fmt.Errorf("error creating download file: %w", err)
+	}
+	defer f.Close()
+	written, err := io.Copy(f, io.LimitReader(resp.Body, MaxDownloadBytes+1))
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error downloading %s: %w", imageurl, err)
+	}
+	if written > MaxDownloadBytes {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error downloading %s: larger than %d bytes", imageurl, MaxDownloadBytes)
+	}
+	return f.Name(), nil
+}
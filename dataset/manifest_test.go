@@ -0,0 +1,99 @@
+package dataset
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestRowValidate(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "a.jpg")
+	writeFile(t, image, "jpeg")
+	tests := []struct {
+		name    string
+		row     ManifestRow
+		wantErr string
+	}{
+		{"valid", ManifestRow{Image: image, Label: "cat", ID: "cat-1", Metadata: map[string]interface{}{"colour": "red", "tags": []interface{}{"a"}}}, ""},
+		{"url", ManifestRow{Image: "https://example.com/a.jpg", Label: "cat"}, ""},
+		{"missing image", ManifestRow{Label: "cat"}, "missing image"},
+		{"missing label", ManifestRow{Image: image}, "missing label"},
+		{"bad id", ManifestRow{Image: image, Label: "cat", ID: "../cat"}, "may only contain"},
+		{"no file", ManifestRow{Image: image + ".missing", Label: "cat"}, "image not found"},
+		{"directory", ManifestRow{Image: filepath.Dir(image), Label: "cat"}, "is a directory"},
+		{"number list", ManifestRow{Image: image, Label: "cat", Metadata: map[string]interface{}{"tags": []interface{}{1.0}}}, "list of strings"},
+		{"object", ManifestRow{Image: image, Label: "cat", Metadata: map[string]interface{}{"box": map[string]interface{}{}}}, "unsupported type"},
+	}
+	for _, key := range ReservedMetadata {
+		tests = append(tests, struct {
+			name    string
+			row     ManifestRow
+			wantErr string
+		}{"reserved " + key, ManifestRow{Image: image, Label: "cat", Metadata: map[string]interface{}{key: "x"}}, "reserved"})
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.row.validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.jpg":
+			w.Write([]byte("jpeg"))
+		case "/large.jpg":
+			w.Write(make([]byte, MaxDownloadBytes+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"/small.jpg", ""},
+		{"/large.jpg", "larger than"},
+		{"/missing.jpg", "status 404"},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			dir := t.TempDir()
+			path, err := FetchImage(server.URL+test.path, dir)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				if files, _ := os.ReadDir(dir); len(files) != 0 {
+					t.Errorf("expected the failed download to be removed, found %d files", len(files))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filepath.Ext(path) != ".jpg" {
+				t.Errorf("expected the extension of the url, got %s", path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil || string(data) != "jpeg" {
+				t.Errorf("got %q %v", data, err)
+			}
+		})
+	}
+}
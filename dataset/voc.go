@@ -0,0 +1,66 @@
+package dataset
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+Pascal VOC has one XML file per image:
+<annotation>
+	<filename>000001.jpg</filename>
+	<object>
+		<name>car</name>
+		<bndbox><xmin>10</xmin><ymin>20</ymin><xmax>110</xmax><ymax>90</ymax></bndbox>
+	</object>
+</annotation>
+*/
+
+type vocFile struct {
+	Filename string `xml:"filename"`
+	Objects  []struct {
+		Name   string `xml:"name"`
+		BndBox struct {
+			XMin float64 `xml:"xmin"`
+			YMin float64 `xml:"ymin"`
+			XMax float64 `xml:"xmax"`
+			YMax float64 `xml:"ymax"`
+		} `xml:"bndbox"`
+	} `xml:"object"`
+}
+
+// LoadVOC reads every VOC XML file in annotationdir. Image file names are resolved relative to imagedir.
+// VOC objects have no IDs of their own, so the annotation ID is the XML file name and the object's index.
+func LoadVOC(annotationdir string, imagedir string) ([]Annotation, error) {
+	files, err := filepath.Glob(filepath.Join(annotationdir, "*.xml"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing VOC files: %w", err)
+	}
+	sort.Strings(files)
+	annotations := make([]Annotation, 0)
+	for _, file := range files {
+		rawdata, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading VOC file: %w", err)
+		}
+		voc := vocFile{}
+		if err = xml.Unmarshal(rawdata, &voc); err != nil {
+			return nil, fmt.Errorf("error parsing VOC file %s: %w", file, err)
+		}
+		stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		for i, obj := range voc.Objects {
+			annotations = append(annotations, Annotation{
+				ImagePath:    filepath.Join(imagedir, voc.Filename),
+				Label:        strings.TrimSpace(obj.Name),
+				Box:          boxFromCorners(obj.BndBox.XMin, obj.BndBox.YMin, obj.BndBox.XMax, obj.BndBox.YMax),
+				AnnotationID: stem + "-" + strconv.Itoa(i),
+			})
+		}
+	}
+	return annotations, nil
+}
@@ -0,0 +1,170 @@
+package embedding
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	batchKindText  = "text"
+	batchKindImage = "image"
+
+	/// Mode of a get-embeddings request carrying several images in Payload.Images
+	MODE_IMAGE_BATCH = "image-batch"
+)
+
+type batchRequest struct {
+	payload *RequestPayload
+	count   int /// number of embeddings the request expects back
+	size    int
+	result  chan batchResult
+}
+
+type batchResult struct {
+	resp map[string]interface{}
+	err  error
+}
+
+type pendingBatch struct {
+	requests []*batchRequest
+	count    int
+	size     int
+	timer    *time.Timer
+}
+
+// Batcher groups concurrent text and image embedding requests into a single upstream call,
+// and hands each caller back its own rows of the embeddings.
+// A batch is sent once it reaches maxItems embeddings or maxBytes of image data,
+// or maxWait after its first request, whichever comes first.
+// Other requests, e.g. find-main-object, are passed straight through.
+type Batcher struct {
+	next     Client
+	maxItems int
+	maxBytes int
+	maxWait  time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch
+}
+
+func NewBatcher(next Client, maxItems int, maxBytes int, maxWait time.Duration) *Batcher {
+	return &Batcher{
+		next:     next,
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		maxWait:  maxWait,
+		pending:  make(map[string]*pendingBatch),
+	}
+}
+
+func (b *Batcher) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	if payload.Inputs.Type != "get-embeddings" {
+		return b.next.Do(payload)
+	}
+	req := &batchRequest{
+		payload: payload,
+		result:  make(chan batchResult, 1),
+	}
+	kind := ""
+	switch payload.Inputs.Mode {
+	case "text":
+		kind = batchKindText
+		req.count = len(payload.Inputs.Candidates)
+		for _, candidate := range payload.Inputs.Candidates {
+			req.size += len(candidate)
+		}
+	case "image":
+		kind = batchKindImage
+		req.count = 1
+		req.size = len(payload.Inputs.Image)
+	default:
+		return b.next.Do(payload)
+	}
+
+	b.add(kind, req)
+	result := <-req.result
+	return result.resp, result.err
+}
+
+func (b *Batcher) add(kind string, req *batchRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch := b.pending[kind]
+	/// Send what's already waiting if this request would take it over the byte limit
+	if batch != nil && batch.size+req.size > b.maxBytes {
+		b.take(kind)
+		batch = nil
+	}
+	if batch == nil {
+		batch = &pendingBatch{}
+		batch.timer = time.AfterFunc(b.maxWait, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.pending[kind] == batch {
+				b.take(kind)
+			}
+		})
+		b.pending[kind] = batch
+	}
+	batch.requests = append(batch.requests, req)
+	batch.count += req.count
+	batch.size += req.size
+	if batch.count >= b.maxItems || batch.size >= b.maxBytes {
+		b.take(kind)
+	}
+}
+
+// take removes the pending batch and sends it, b.mu must be held
+func (b *Batcher) take(kind string) {
+	batch := b.pending[kind]
+	delete(b.pending, kind)
+	batch.timer.Stop()
+	go b.send(kind, batch)
+}
+
+func (b *Batcher) send(kind string, batch *pendingBatch) {
+	payload := &RequestPayload{
+		Inputs: Payload{
+			Type: "get-embeddings",
+		},
+	}
+	switch kind {
+	case batchKindText:
+		payload.Inputs.Mode = "text"
+		for _, req := range batch.requests {
+			payload.Inputs.Candidates = append(payload.Inputs.Candidates, req.payload.Inputs.Candidates...)
+		}
+	case batchKindImage:
+		payload.Inputs.Mode = MODE_IMAGE_BATCH
+		for _, req := range batch.requests {
+			payload.Inputs.Images = append(payload.Inputs.Images, req.payload.Inputs.Image)
+		}
+	}
+
+	resp, err := b.next.Do(payload)
+	var rows []any
+	if err == nil {
+		var ok bool
+		rows, ok = resp["embeddings"].([]any)
+		if !ok || len(rows) != batch.count {
+			err = fmt.Errorf("batch of %d returned %d embeddings", batch.count, len(rows))
+		}
+	}
+	if err != nil {
+		for _, req := range batch.requests {
+			req.result <- batchResult{err: err}
+		}
+		return
+	}
+	offset := 0
+	for _, req := range batch.requests {
+		req.result <- batchResult{
+			resp: map[string]interface{}{
+				"embeddings": rows[offset : offset+req.count],
+			},
+		}
+		offset += req.count
+	}
+}
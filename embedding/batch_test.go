@@ -0,0 +1,143 @@
+package embedding
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoClient returns each candidate or image as its own embedding row, so callers can check they got their rows
+type echoClient struct {
+	mu       sync.Mutex
+	payloads []Payload
+	missing  int /// rows left off the end of every response
+}
+
+func (c *echoClient) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	c.mu.Lock()
+	c.payloads = append(c.payloads, payload.Inputs)
+	c.mu.Unlock()
+	rows := make([]any, 0)
+	for _, candidate := range payload.Inputs.Candidates {
+		rows = append(rows, []any{candidate})
+	}
+	for _, image := range payload.Inputs.Images {
+		rows = append(rows, []any{image})
+	}
+	if payload.Inputs.Mode == "image" || payload.Inputs.Type != "get-embeddings" {
+		rows = append(rows, []any{payload.Inputs.Image})
+	}
+	return map[string]interface{}{"embeddings": rows[:len(rows)-c.missing]}, nil
+}
+
+func imagePayload(image string) *RequestPayload {
+	return &RequestPayload{Inputs: Payload{Type: "get-embeddings", Mode: "image", Image: image}}
+}
+
+// expectedRows is what echoClient returns for the payload on its own
+func expectedRows(payload *RequestPayload) []any {
+	if payload.Inputs.Image != "" {
+		return []any{[]any{payload.Inputs.Image}}
+	}
+	rows := make([]any, 0)
+	for _, candidate := range payload.Inputs.Candidates {
+		rows = append(rows, []any{candidate})
+	}
+	return rows
+}
+
+func TestBatcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxItems  int
+		maxBytes  int
+		payloads  []*RequestPayload
+		missing   int
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "full batch",
+			maxItems:  4,
+			maxBytes:  1000,
+			payloads:  []*RequestPayload{textPayload("cat", "dog"), textPayload("car"), textPayload("bus")},
+			wantCalls: 1,
+		},
+		{
+			name:      "sent after max wait",
+			maxItems:  100,
+			maxBytes:  1000,
+			payloads:  []*RequestPayload{textPayload("cat"), textPayload("dog")},
+			wantCalls: 1,
+		},
+		{
+			name:      "text and images are batched apart",
+			maxItems:  100,
+			maxBytes:  1000,
+			payloads:  []*RequestPayload{textPayload("cat"), imagePayload("aaaa"), imagePayload("bbbb")},
+			wantCalls: 2,
+		},
+		{
+			name:      "byte limit",
+			maxItems:  100,
+			maxBytes:  6,
+			payloads:  []*RequestPayload{imagePayload("aaaa"), imagePayload("bbbb"), imagePayload("cccc")},
+			wantCalls: 3,
+		},
+		{
+			name:      "other requests pass through",
+			maxItems:  100,
+			maxBytes:  1000,
+			payloads:  []*RequestPayload{{Inputs: Payload{Type: "find-main-object", Image: "aaaa"}}, {Inputs: Payload{Type: "find-main-object", Image: "bbbb"}}},
+			wantCalls: 2,
+		},
+		{
+			name:      "short response",
+			maxItems:  3,
+			maxBytes:  1000,
+			payloads:  []*RequestPayload{textPayload("cat"), textPayload("dog"), textPayload("car")},
+			missing:   1,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := &echoClient{missing: test.missing}
+			b := NewBatcher(upstream, test.maxItems, test.maxBytes, 100*time.Millisecond)
+			wg := sync.WaitGroup{}
+			errs := make(chan error, len(test.payloads))
+			for _, payload := range test.payloads {
+				wg.Add(1)
+				go func(payload *RequestPayload) {
+					defer wg.Done()
+					resp, err := b.Do(payload)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if !reflect.DeepEqual(resp["embeddings"], expectedRows(payload)) {
+						errs <- fmt.Errorf("%+v got rows %v", payload.Inputs, resp["embeddings"])
+					}
+				}(payload)
+			}
+			wg.Wait()
+			close(errs)
+			failed := 0
+			for err := range errs {
+				failed++
+				if !test.wantErr {
+					t.Error(err)
+				}
+			}
+			if test.wantErr && failed != len(test.payloads) {
+				t.Errorf("expected every request to fail, %d did", failed)
+			}
+			if len(upstream.payloads) != test.wantCalls {
+				t.Errorf("expected %d upstream calls, got %d: %+v", test.wantCalls, len(upstream.payloads), upstream.payloads)
+			}
+		})
+	}
+}
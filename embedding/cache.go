@@ -0,0 +1,224 @@
+package embedding
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Client is anything that can turn a request payload into embeddings, i.e. the Embedder or a wrapper around it
+type Client interface {
+	Do(payload *RequestPayload) (map[string]interface{}, error)
+}
+
+type CacheStats struct {
+	MemoryHits uint64 `json:"memory_hits"`
+	DiskHits   uint64 `json:"disk_hits"`
+	Misses     uint64 `json:"misses"`
+}
+
+func (s CacheStats) String() string {
+	total := s.MemoryHits + s.DiskHits + s.Misses
+	hitrate := 0.0
+	if total > 0 {
+		hitrate = float64(s.MemoryHits+s.DiskHits) / float64(total)
+	}
+	return fmt.Sprintf("Embedding cache: %d memory hits, %d disk hits, %d misses, hit rate %.1f%%",
+		s.MemoryHits, s.DiskHits, s.Misses, hitrate*100)
+}
+
+type cacheEntry struct {
+	key  string
+	resp map[string]interface{}
+}
+
+// Cache sits in front of another Client and remembers the embeddings for each distinct input,
+// in an in-memory LRU and, if a dir is given, on disk so that they survive restarts.
+// Cached responses are shared between callers and must not be modified.
+type Cache struct {
+	next    Client
+	modelID string
+	dir     string
+	size    int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	stats   CacheStats
+}
+
+// NewCache wraps next with a cache of up to size entries in memory.
+// modelID is part of every key, so changing the model never returns stale embeddings.
+// dir may be empty for a memory only cache.
+func NewCache(next Client, modelID string, size int, dir string) *Cache {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			log.Println("Unable to create embedding cache dir, disk cache disabled ", err)
+			dir = ""
+		}
+	}
+	return &Cache{
+		next:    next,
+		modelID: modelID,
+		dir:     dir,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// CacheKey identifies the input of a payload: the SHA-256 of the image bytes for image requests,
+// or of the normalised label text for text requests, along with the model and request type.
+func CacheKey(payload *RequestPayload, modelID string) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(modelID + "\x00" + payload.Inputs.Type + "\x00" + payload.Inputs.Mode + "\x00"))
+	if payload.Inputs.Image != "" {
+		imagedata, err := base64.StdEncoding.DecodeString(payload.Inputs.Image)
+		if err != nil {
+			return "", fmt.Errorf("invalid image data: %w", err)
+		}
+		hash.Write(imagedata)
+	}
+	for _, image := range payload.Inputs.Images {
+		imagedata, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return "", fmt.Errorf("invalid image data: %w", err)
+		}
+		hash.Write(imagedata)
+	}
+	for _, candidate := range payload.Inputs.Candidates {
+		/// CLIP's tokenizer lower cases and splits on whitespace, so these give the same embedding
+		hash.Write([]byte(strings.Join(strings.Fields(strings.ToLower(candidate)), " ") + "\x00"))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (c *Cache) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	key, err := CacheKey(payload, c.modelID)
+	if err != nil {
+		return nil, err
+	}
+	if resp, ok := c.getMemory(key); ok {
+		return resp, nil
+	}
+	if resp, ok := c.getDisk(key); ok {
+		c.putMemory(key, resp)
+		return resp, nil
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	resp, err := c.next.Do(payload)
+	if err != nil {
+		return nil, err
+	}
+	/// Only cache something that looks like a good response
+	if _, ok := resp["embeddings"]; !ok {
+		return resp, nil
+	}
+	c.putMemory(key, resp)
+	c.putDisk(key, resp)
+	return resp, nil
+}
+
+// Stats returns the hit/miss counts since the cache was created
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *Cache) getMemory(key string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.MemoryHits++
+	return elem.Value.(*cacheEntry).resp, true
+}
+
+func (c *Cache) putMemory(key string, resp map[string]interface{}) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, resp: resp})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *Cache) diskPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+func (c *Cache) getDisk(key string) (map[string]interface{}, bool) {
+	if c.dir == "" {
+		return nil, false
+	}
+	rawdata, err := os.ReadFile(c.diskPath(key))
+	if err != nil {
+		return nil, false
+	}
+	resp := make(map[string]interface{})
+	if err = json.Unmarshal(rawdata, &resp); err != nil {
+		log.Println("Ignoring corrupt embedding cache entry ", key, err)
+		return nil, false
+	}
+	c.mu.Lock()
+	c.stats.DiskHits++
+	c.mu.Unlock()
+	return resp, true
+}
+
+func (c *Cache) putDisk(key string, resp map[string]interface{}) {
+	if c.dir == "" {
+		return
+	}
+	path := c.diskPath(key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		log.Println("Unable to create embedding cache dir ", err)
+		return
+	}
+	rawdata, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("Unable to encode embedding cache entry ", err)
+		return
+	}
+	/// Write to a temp file and rename, so a reader never sees half an entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+"-*.tmp")
+	if err != nil {
+		log.Println("Unable to write embedding cache entry ", err)
+		return
+	}
+	_, err = tmp.Write(rawdata)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		log.Println("Unable to write embedding cache entry ", err)
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,185 @@
+package embedding
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"a.jpg": "image one", "copy.jpg": "image one", "b.jpg": "image two"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fromFile := func(name string) *RequestPayload {
+		payload, err := CreateDetectionPayload(filepath.Join(dir, name), "", OPMODE_IMAGE_EMBED)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+	batch := &RequestPayload{Inputs: Payload{Type: "get-embeddings", Mode: MODE_IMAGE_BATCH, Images: []string{fromFile("a.jpg").Inputs.Image}}}
+	tests := []struct {
+		name     string
+		a, b     *RequestPayload
+		modelA   string
+		modelB   string
+		wantSame bool
+	}{
+		{"case", textPayload("A Red Forklift"), textPayload("a red forklift"), "clip", "clip", true},
+		{"whitespace", textPayload("  a red\tforklift \n"), textPayload("a red forklift"), "clip", "clip", true},
+		{"different text", textPayload("a red forklift"), textPayload("a blue forklift"), "clip", "clip", false},
+		{"candidate boundaries", textPayload("a", "bc"), textPayload("ab", "c"), "clip", "clip", false},
+		{"model", textPayload("cat"), textPayload("cat"), "clip", "siglip", false},
+		{"mode", textPayload("cat"), &RequestPayload{Inputs: Payload{Type: "find-main-object", Mode: "text", Candidates: []string{"cat"}}}, "clip", "clip", false},
+		{"same bytes under another name", fromFile("a.jpg"), fromFile("copy.jpg"), "clip", "clip", true},
+		{"different bytes", fromFile("a.jpg"), fromFile("b.jpg"), "clip", "clip", false},
+		{"batch of the same image", fromFile("a.jpg"), batch, "clip", "clip", false},
+	}
+	for _, test := range tests {
+		a, err := CacheKey(test.a, test.modelA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := CacheKey(test.b, test.modelB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (a == b) != test.wantSame {
+			t.Errorf("%s: same key is %v, want %v", test.name, a == b, test.wantSame)
+		}
+	}
+	if _, err := CacheKey(imagePayload("not base64!"), "clip"); err == nil {
+		t.Error("expected an error for invalid image data")
+	}
+}
+
+func TestCacheLRU(t *testing.T) {
+	upstream := &echoClient{}
+	cache := NewCache(upstream, "clip", 2, "")
+	steps := []struct {
+		text    string
+		wantHit bool
+	}{
+		{"a", false},
+		{"b", false},
+		{"a", true},
+		{"c", false}, /// evicts b, a was used more recently
+		{"a", true},
+		{"b", false}, /// evicts c
+		{"c", false},
+		{"b", true},
+	}
+	for i, step := range steps {
+		calls := len(upstream.payloads)
+		resp, err := cache.Do(textPayload(step.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp["embeddings"], []any{[]any{step.text}}) {
+			t.Errorf("step %d: got %v for %s", i, resp, step.text)
+		}
+		if hit := len(upstream.payloads) == calls; hit != step.wantHit {
+			t.Errorf("step %d: %s hit is %v, want %v", i, step.text, hit, step.wantHit)
+		}
+	}
+	want := CacheStats{MemoryHits: 3, Misses: 5}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+	if got := want.String(); got != "Embedding cache: 3 memory hits, 0 disk hits, 5 misses, hit rate 37.5%" {
+		t.Errorf("unexpected summary %s", got)
+	}
+}
+
+func TestCacheDisk(t *testing.T) {
+	dir := t.TempDir()
+	upstream := &echoClient{}
+	if _, err := NewCache(upstream, "clip", 10, dir).Do(textPayload("cat")); err != nil {
+		t.Fatal(err)
+	}
+
+	/// A new cache, e.g. after a restart, finds it on disk and then keeps it in memory
+	restarted := NewCache(upstream, "clip", 10, dir)
+	for i := 0; i < 2; i++ {
+		resp, err := restarted.Do(textPayload("Cat"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp["embeddings"], []any{[]any{"cat"}}) {
+			t.Errorf("got %v from the disk cache", resp)
+		}
+	}
+	if len(upstream.payloads) != 1 {
+		t.Errorf("expected 1 upstream call, got %d", len(upstream.payloads))
+	}
+	if got, want := restarted.Stats(), (CacheStats{MemoryHits: 1, DiskHits: 1}); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+
+	/// Another model doesn't share the entry
+	if _, err := NewCache(upstream, "siglip", 10, dir).Do(textPayload("cat")); err != nil || len(upstream.payloads) != 2 {
+		t.Errorf("expected another model to miss, got %d upstream calls %v", len(upstream.payloads), err)
+	}
+
+	/// A corrupt entry is a miss, and is replaced
+	key, _ := CacheKey(textPayload("dog"), "clip")
+	path := filepath.Join(dir, key[:2], key+".json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"embeddings": [[`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCache(upstream, "clip", 10, dir)
+	if _, err := cache.Do(textPayload("dog")); err != nil || cache.Stats().Misses != 1 {
+		t.Errorf("expected a corrupt entry to miss, got %+v %v", cache.Stats(), err)
+	}
+	if _, err := NewCache(upstream, "clip", 0, dir).Do(textPayload("dog")); err != nil || len(upstream.payloads) != 3 {
+		t.Errorf("expected the corrupt entry to be replaced, got %d upstream calls %v", len(upstream.payloads), err)
+	}
+}
+
+// failingClient returns a response without embeddings, or an error, and counts its calls
+type failingClient struct {
+	calls int
+	err   error
+}
+
+func (c *failingClient) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return map[string]interface{}{"error": "model overloaded"}, nil
+}
+
+func TestCacheSkipsBadResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream *failingClient
+	}{
+		{"no embeddings", &failingClient{}},
+		{"error", &failingClient{err: fmt.Errorf("connection refused")}},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		cache := NewCache(test.upstream, "clip", 10, dir)
+		for i := 0; i < 2; i++ {
+			resp, err := cache.Do(textPayload("cat"))
+			if (err != nil) != (test.upstream.err != nil) || (err == nil && resp["error"] == nil) {
+				t.Errorf("%s: expected the upstream response, got %v %v", test.name, resp, err)
+			}
+		}
+		if test.upstream.calls != 2 || cache.Stats().Misses != 2 {
+			t.Errorf("%s: expected nothing to be cached, got %d calls and %+v", test.name, test.upstream.calls, cache.Stats())
+		}
+		if entries, _ := filepath.Glob(filepath.Join(dir, "*", "*")); len(entries) > 0 {
+			t.Errorf("%s: expected nothing on disk, got %v", test.name, entries)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package embedding
+
+import (
+	"fmt"
+	"sync"
+)
+
+type inflightCall struct {
+	wg   sync.WaitGroup
+	resp map[string]interface{}
+	err  error
+}
+
+// Coalescer shares a single upstream call between concurrent identical requests, in the style of singleflight.
+// Requests are identical if they have the same CacheKey. As with the Cache, the shared response must not be modified.
+type Coalescer struct {
+	next    Client
+	modelID string
+
+	mu       sync.Mutex
+	inflight map[string]*inflightCall
+}
+
+func NewCoalescer(next Client, modelID string) *Coalescer {
+	return &Coalescer{
+		next:     next,
+		modelID:  modelID,
+		inflight: make(map[string]*inflightCall),
+	}
+}
+
+func (c *Coalescer) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	key, err := CacheKey(payload, c.modelID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.resp, call.err
+	}
+	call := &inflightCall{}
+	call.wg.Add(1)
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	/// If the upstream call panics the waiters get an error, then the panic carries on in this goroutine
+	defer func() {
+		r := recover()
+		if r != nil {
+			call.resp, call.err = nil, fmt.Errorf("upstream panicked: %v", r)
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		c.mu.Unlock()
+		call.wg.Done()
+		if r != nil {
+			panic(r)
+		}
+	}()
+	call.resp, call.err = c.next.Do(payload)
+	return call.resp, call.err
+}
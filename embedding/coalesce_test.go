@@ -0,0 +1,138 @@
+package embedding
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingClient counts calls and holds each one until release is closed
+type blockingClient struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+	panics  bool
+}
+
+func newBlockingClient(panics bool) *blockingClient {
+	return &blockingClient{started: make(chan struct{}, 100), release: make(chan struct{}), panics: panics}
+}
+
+func (c *blockingClient) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	c.calls.Add(1)
+	c.started <- struct{}{}
+	<-c.release
+	if c.panics {
+		panic("model crashed")
+	}
+	return map[string]interface{}{"candidates": payload.Inputs.Candidates}, nil
+}
+
+func textPayload(candidates ...string) *RequestPayload {
+	return &RequestPayload{Inputs: Payload{Type: "get-embeddings", Mode: "text", Candidates: candidates}}
+}
+
+// joinTime is long enough for goroutines that are about to call Do to join the call in flight
+const joinTime = 50 * time.Millisecond
+
+func TestCoalescer(t *testing.T) {
+	tests := []struct {
+		name      string
+		payloads  []*RequestPayload
+		wantCalls int32
+	}{
+		{"identical", []*RequestPayload{textPayload("cat"), textPayload("cat"), textPayload("cat")}, 1},
+		{"same after normalising", []*RequestPayload{textPayload("a Cat"), textPayload("A  cat")}, 1},
+		{"different", []*RequestPayload{textPayload("cat"), textPayload("dog")}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upstream := newBlockingClient(false)
+			c := NewCoalescer(upstream, "model")
+			wg := sync.WaitGroup{}
+			errs := make(chan error, len(test.payloads))
+			for _, payload := range test.payloads {
+				wg.Add(1)
+				go func(payload *RequestPayload) {
+					defer wg.Done()
+					resp, err := c.Do(payload)
+					if err == nil && resp == nil {
+						err = fmt.Errorf("no response")
+					}
+					errs <- err
+				}(payload)
+			}
+			<-upstream.started
+			time.Sleep(joinTime)
+			close(upstream.release)
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+			if upstream.calls.Load() != test.wantCalls {
+				t.Errorf("expected %d upstream calls, got %d", test.wantCalls, upstream.calls.Load())
+			}
+			if len(c.inflight) != 0 {
+				t.Errorf("expected no calls left in flight, got %d", len(c.inflight))
+			}
+		})
+	}
+}
+
+func TestCoalescerPanic(t *testing.T) {
+	upstream := newBlockingClient(true)
+	c := NewCoalescer(upstream, "model")
+	payload := textPayload("cat")
+
+	leader := make(chan interface{}, 1)
+	go func() {
+		defer func() { leader <- recover() }()
+		c.Do(payload)
+	}()
+	<-upstream.started
+
+	const waiters = 3
+	wg := sync.WaitGroup{}
+	errs := make(chan error, waiters)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs <- fmt.Errorf("waiter called upstream itself and panicked: %v", r)
+				}
+			}()
+			resp, err := c.Do(payload)
+			if resp != nil {
+				err = fmt.Errorf("unexpected response %v", resp)
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(joinTime)
+	close(upstream.release)
+	wg.Wait()
+	close(errs)
+
+	if r := <-leader; r != "model crashed" {
+		t.Errorf("expected the panic to carry on in the caller, got %v", r)
+	}
+	for err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "upstream panicked: model crashed") {
+			t.Errorf("expected the waiters to get the panic as an error, got %v", err)
+		}
+	}
+	if upstream.calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", upstream.calls.Load())
+	}
+	if len(c.inflight) != 0 {
+		t.Errorf("expected no calls left in flight, got %d", len(c.inflight))
+	}
+}
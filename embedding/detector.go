@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"github.com/paul-at-nangalan/errorhandler/handlers"
 	"io"
 	"log"
 	"net/http"
@@ -20,6 +19,7 @@ const (
 	OPMODE_TEXT_EMBED  OperationMode = "text-embed"
 	OPMODE_IMAGE_EMBED OperationMode = "image-embed"
 	OPMODE_MAINOBJECT  OperationMode = "main-object-class"
+	OPMODE_CLASSIFY    OperationMode = "classify"
 )
 
 type Embedder struct {
@@ -35,6 +35,7 @@ func NewEmbedder(url, apiKey string) *Embedder {
 
 type Payload struct {
 	Image      string   `json:"image"`
+	Images     []string `json:"images,omitempty"` /// only used by MODE_IMAGE_BATCH
 	Candidates []string `json:"candidates"`
 	Type       string   `json:"type"`
 	Mode       string   `json:"mode"`
@@ -45,29 +46,44 @@ type RequestPayload struct {
 	Inputs Payload `json:"inputs"`
 }
 
+// CreateTextPayload returns the payload to embed the text as a single candidate. Unlike the OPMODE_TEXT_EMBED
+// payload of CreateDetectionPayload it isn't split on commas, for free text such as a search query.
+func CreateTextPayload(text string) (*RequestPayload, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("Text is empty for text embed")
+	}
+	return &RequestPayload{
+		Inputs: Payload{
+			Candidates: []string{text},
+			Type:       "get-embeddings",
+			Mode:       "text",
+		},
+	}, nil
+}
+
+// CreateImagePayload returns the payload to embed encoded image data, e.g. a crop that was never written to a file
+func CreateImagePayload(imageData []byte) *RequestPayload {
+	return &RequestPayload{
+		Inputs: Payload{
+			Image: base64.StdEncoding.EncodeToString(imageData),
+			Type:  "get-embeddings",
+			Mode:  "image",
+		},
+	}
+}
+
 func CreateDetectionPayload(imageFilename string, labelsCSV string, mode OperationMode) (*RequestPayload, error) {
 
 	switch mode {
 	case OPMODE_IMAGE_EMBED:
-		fmt.Println("Creating image request")
 		// Read the image file
 		imageData, err := os.ReadFile(imageFilename)
 		if err != nil {
 			return nil, fmt.Errorf("error reading image file: %w", err)
 		}
-		// Convert image data to base64
-		base64Data := base64.StdEncoding.EncodeToString(imageData)
-		// Create payload
-		payload := &RequestPayload{
-			Inputs: Payload{
-				Image: base64Data,
-				Type:  "get-embeddings",
-				Mode:  "image",
-			},
-		}
-		return payload, nil
+		return CreateImagePayload(imageData), nil
 	case OPMODE_TEXT_EMBED:
-		fmt.Println("Creating text request")
 		// Split labels string into array
 		var labels []string
 		if labelsCSV == "" {
@@ -103,6 +119,26 @@ func CreateDetectionPayload(imageFilename string, labelsCSV string, mode Operati
 			},
 		}
 		return payload, nil
+	case OPMODE_CLASSIFY:
+		if labelsCSV == "" {
+			return nil, fmt.Errorf("Labels are empty for classify")
+		}
+		imageData, err := os.ReadFile(imageFilename)
+		if err != nil {
+			return nil, fmt.Errorf("error reading image file: %w", err)
+		}
+		labels := strings.Split(labelsCSV, ",")
+		for i := range labels {
+			labels[i] = strings.TrimSpace(labels[i])
+		}
+		payload := &RequestPayload{
+			Inputs: Payload{
+				Image:      base64.StdEncoding.EncodeToString(imageData),
+				Candidates: labels,
+				Type:       "zero-shot-classification",
+			},
+		}
+		return payload, nil
 	}
 	return nil, fmt.Errorf("Invalid mode %s", mode)
 }
@@ -118,7 +154,9 @@ func (e *Embedder) Do(payload *RequestPayload) (m map[string]interface{}, err er
 
 		// Create the request
 		req, err := http.NewRequest("POST", e.url, buf)
-		handlers.PanicOnError(err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
 
 		// Set headers
 		req.Header.Set("Accept", "application/json")
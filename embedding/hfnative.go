@@ -0,0 +1,265 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"time"
+)
+
+/**
+Stock Hugging Face inference tasks, for endpoints that don't run the custom handler.py
+
+feature-extraction, one input per label:
+	{"inputs": ["a red forklift", "a pallet"]}  =>  [[0.1, ...], [0.2, ...]]
+
+image-feature-extraction, the raw image bytes:
+	<image/jpeg body>  =>  [[0.1, ...]]
+
+Both must return the pooled, projected embedding of a model with a joint text and image space, e.g. a
+sentence-transformers CLIP model. A vector per token or patch is hidden state, not in the joint space, so it is an error.
+
+zero-shot-image-classification:
+	{"inputs": "/9j/4AAQ...", "parameters": {"candidate_labels": ["forklift", "pallet"]}}
+	=>  [{"label": "forklift", "score": 0.93}, {"label": "pallet", "score": 0.07}]
+
+While a model is loading the API returns 503 with {"error": "...", "estimated_time": 20.0}
+*/
+
+// Classification is the score of one candidate label for an image
+type Classification struct {
+	Label string  `json:"label"`
+	Score float32 `json:"score"`
+}
+
+// Classifier is implemented by backends that can do zero-shot classification themselves.
+// The payload is created with OPMODE_CLASSIFY.
+type Classifier interface {
+	Classify(payload *RequestPayload) ([]Classification, error)
+}
+
+type hfTaskRequest struct {
+	Inputs     interface{}            `json:"inputs"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ErrNoJointSpace is returned when the model doesn't embed texts and images as comparable, pooled vectors
+var ErrNoJointSpace = errors.New("the model has no joint text and image space")
+
+// While a model is loading the wait is at least hfMinLoadWait, whatever it estimates
+const hfMinLoadWait = 5 * time.Second
+
+type hfLoadingResponse struct {
+	Error         string  `json:"error"`
+	EstimatedTime float64 `json:"estimated_time"`
+}
+
+// HFTaskEmbedder talks to the stock feature-extraction, image-feature-extraction and zero-shot-image-classification tasks.
+// It takes the same payloads as the Embedder and returns the embeddings in the same shape.
+type HFTaskEmbedder struct {
+	featureURL, imageURL, classifyURL, apiKey string
+	maxWait                                   time.Duration
+	client                                    *http.Client
+	sleep                                     func(time.Duration) /// time.Sleep, replaced in tests
+}
+
+// NewHFTaskEmbedder creates an embedder for a feature-extraction endpoint for text, an image-feature-extraction
+// endpoint for images and, optionally, a zero-shot-image-classification endpoint (classifyURL may be empty).
+// maxWait caps the total time spent waiting for models to load.
+func NewHFTaskEmbedder(featureURL, imageURL, classifyURL, apiKey string, maxWait time.Duration) *HFTaskEmbedder {
+	return &HFTaskEmbedder{
+		featureURL:  featureURL,
+		imageURL:    imageURL,
+		classifyURL: classifyURL,
+		apiKey:      apiKey,
+		maxWait:     maxWait,
+		client:      &http.Client{Timeout: 2 * time.Minute},
+		sleep:       time.Sleep,
+	}
+}
+
+// CanClassify is false if no zero-shot-image-classification endpoint was given
+func (h *HFTaskEmbedder) CanClassify() bool {
+	return h.classifyURL != ""
+}
+
+func (h *HFTaskEmbedder) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	rows := make([]any, 0)
+	switch {
+	case payload.Inputs.Type == "get-embeddings" && payload.Inputs.Mode == "text":
+		var raw json.RawMessage
+		if err := h.postJSON(h.featureURL, hfTaskRequest{Inputs: payload.Inputs.Candidates}, &raw); err != nil {
+			return nil, err
+		}
+		var vectors [][]float64
+		var tokens [][][]float64
+		vectorerr := json.Unmarshal(raw, &vectors)
+		switch {
+		case vectorerr == nil && len(vectors) == len(payload.Inputs.Candidates):
+			for _, vector := range vectors {
+				rows = append(rows, toRow(vector))
+			}
+		case vectorerr == nil, json.Unmarshal(raw, &tokens) == nil:
+			return nil, fmt.Errorf("feature-extraction returned a vector per token, the model must return one pooled embedding per text: %w",
+				ErrNoJointSpace)
+		default:
+			return nil, fmt.Errorf("unexpected feature-extraction response")
+		}
+	case payload.Inputs.Type == "get-embeddings" && payload.Inputs.Mode == MODE_IMAGE_BATCH:
+		for _, image := range payload.Inputs.Images {
+			row, err := h.imageFeatures(image)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	case payload.Inputs.Type == "get-embeddings" && payload.Inputs.Mode == "image",
+		payload.Inputs.Type == "find-main-object":
+		row, err := h.imageFeatures(payload.Inputs.Image)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	default:
+		return nil, fmt.Errorf("Unsupported request type %s mode %s", payload.Inputs.Type, payload.Inputs.Mode)
+	}
+	return map[string]interface{}{"embeddings": rows}, nil
+}
+
+func (h *HFTaskEmbedder) Classify(payload *RequestPayload) ([]Classification, error) {
+	if h.classifyURL == "" {
+		return nil, fmt.Errorf("no zero-shot-image-classification endpoint configured")
+	}
+	results := make([]Classification, 0)
+	err := h.postJSON(h.classifyURL, hfTaskRequest{
+		Inputs: payload.Inputs.Image,
+		Parameters: map[string]interface{}{
+			"candidate_labels": payload.Inputs.Candidates,
+		},
+	}, &results)
+	return results, err
+}
+
+// imageFeatures returns the embedding of an image from the image-feature-extraction task
+func (h *HFTaskEmbedder) imageFeatures(base64Data string) ([]any, error) {
+	imagedata, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image data: %w", err)
+	}
+	var raw json.RawMessage
+	if err := h.post(h.imageURL, http.DetectContentType(imagedata), imagedata, &raw); err != nil {
+		return nil, err
+	}
+	var vector []float64
+	if err := json.Unmarshal(raw, &vector); err == nil {
+		return toRow(vector), nil
+	}
+	/// [[...]] is a batch of one, [[[...], ...]] a vector per patch
+	var vectors [][]float64
+	if err := json.Unmarshal(raw, &vectors); err == nil && len(vectors) == 1 {
+		return toRow(vectors[0]), nil
+	}
+	var patches [][][]float64
+	if err := json.Unmarshal(raw, &patches); err == nil && len(patches) == 1 && len(patches[0]) == 1 {
+		return toRow(patches[0][0]), nil
+	}
+	if len(vectors) > 1 || len(patches) > 0 {
+		return nil, fmt.Errorf("image-feature-extraction returned a vector per patch, the model must return one pooled embedding per image: %w",
+			ErrNoJointSpace)
+	}
+	return nil, fmt.Errorf("unexpected image-feature-extraction response")
+}
+
+// CheckJointSpace embeds a text and an image and fails unless both give a single embedding of the same dimension.
+// Text and image vectors are compared with each other, so a model without a joint space can't be used.
+// The error wraps ErrNoJointSpace if the model was reached and has none, and not if the check couldn't be made.
+func (h *HFTaskEmbedder) CheckJointSpace() error {
+	text, err := h.Do(&RequestPayload{Inputs: Payload{Type: "get-embeddings", Mode: "text", Candidates: []string{"a photo"}}})
+	if err != nil {
+		return fmt.Errorf("failed to embed a text: %w", err)
+	}
+	probe := &bytes.Buffer{}
+	if err = png.Encode(probe, image.NewRGBA(image.Rect(0, 0, 32, 32))); err != nil {
+		return err
+	}
+	img, err := h.Do(&RequestPayload{Inputs: Payload{Type: "get-embeddings", Mode: "image",
+		Image: base64.StdEncoding.EncodeToString(probe.Bytes())}})
+	if err != nil {
+		return fmt.Errorf("failed to embed an image: %w", err)
+	}
+	textdim := len(text["embeddings"].([]any)[0].([]any))
+	imagedim := len(img["embeddings"].([]any)[0].([]any))
+	if textdim != imagedim {
+		return fmt.Errorf("text embeddings have %d dimensions and image embeddings %d, "+
+			"the endpoints must serve the same model: %w", textdim, imagedim, ErrNoJointSpace)
+	}
+	return nil
+}
+
+func toRow(vector []float64) []any {
+	row := make([]any, len(vector))
+	for i, val := range vector {
+		row[i] = val
+	}
+	return row
+}
+
+func (h *HFTaskEmbedder) postJSON(url string, request hfTaskRequest, out interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return h.post(url, "application/json", body, out)
+}
+
+// post sends the body and decodes the response into out, waiting for the model if it is still loading
+func (h *HFTaskEmbedder) post(url string, contentType string, body []byte, out interface{}) error {
+	waited := time.Duration(0)
+	for {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+		req.Header.Set("Content-Type", contentType)
+		resp, err := h.client.Do(req)
+		if err != nil {
+			return err
+		}
+		respbody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			loading := hfLoadingResponse{}
+			_ = json.Unmarshal(respbody, &loading)
+			wait := time.Duration(loading.EstimatedTime * float64(time.Second))
+			if wait < hfMinLoadWait {
+				wait = hfMinLoadWait
+			}
+			if waited+wait > h.maxWait {
+				return fmt.Errorf("model not ready after %s: %s", h.maxWait, loading.Error)
+			}
+			fmt.Printf("Model loading (%s) - sleeping for %s\n", loading.Error, wait.Round(time.Second))
+			h.sleep(wait)
+			waited += wait
+			continue
+		}
+		if resp.StatusCode > 299 {
+			return fmt.Errorf("Request failed with code %d and reason %s", resp.StatusCode, string(respbody))
+		}
+		if err = json.Unmarshal(respbody, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	}
+}
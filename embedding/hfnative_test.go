@@ -0,0 +1,230 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hfStub serves the feature-extraction task at /text and image-feature-extraction at /image
+type hfStub struct {
+	text, image string /// responses
+	gotImage    []byte
+	gotType     string
+}
+
+func (s *hfStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/text":
+		w.Write([]byte(s.text))
+	case "/image":
+		s.gotType = r.Header.Get("Content-Type")
+		s.gotImage, _ = io.ReadAll(r.Body)
+		w.Write([]byte(s.image))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestHFTaskEmbedder(t *testing.T) {
+	image := pngHeader
+	tests := []struct {
+		name     string
+		payload  *RequestPayload
+		stub     hfStub
+		wantRows []any
+		wantErr  string
+	}{
+		{
+			name:     "pooled text",
+			payload:  textPayload("cat", "dog"),
+			stub:     hfStub{text: `[[1, 2], [3, 4]]`},
+			wantRows: []any{[]any{1.0, 2.0}, []any{3.0, 4.0}},
+		},
+		{
+			name:    "text per token",
+			payload: textPayload("cat", "dog"),
+			stub:    hfStub{text: `[[[1, 2], [3, 4]], [[5, 6], [7, 8]]]`},
+			wantErr: "vector per token",
+		},
+		{
+			name:    "single text per token",
+			payload: textPayload("cat"),
+			stub:    hfStub{text: `[[1, 2], [3, 4]]`},
+			wantErr: "vector per token",
+		},
+		{
+			name:     "pooled image",
+			payload:  imagePayload(image),
+			stub:     hfStub{image: `[[1, 2]]`},
+			wantRows: []any{[]any{1.0, 2.0}},
+		},
+		{
+			name:     "flat image",
+			payload:  &RequestPayload{Inputs: Payload{Type: "find-main-object", Image: image}},
+			stub:     hfStub{image: `[1, 2]`},
+			wantRows: []any{[]any{1.0, 2.0}},
+		},
+		{
+			name:    "image per patch",
+			payload: imagePayload(image),
+			stub:    hfStub{image: `[[[1, 2], [3, 4]]]`},
+			wantErr: "vector per patch",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := test.stub
+			server := httptest.NewServer(&stub)
+			defer server.Close()
+
+			embedder := NewHFTaskEmbedder(server.URL+"/text", server.URL+"/image", "", "key", time.Second)
+			resp, err := embedder.Do(test.payload)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp["embeddings"], test.wantRows) {
+				t.Errorf("got rows %v, want %v", resp["embeddings"], test.wantRows)
+			}
+			if test.payload.Inputs.Image != "" {
+				want, _ := base64.StdEncoding.DecodeString(image)
+				if !bytes.Equal(stub.gotImage, want) || stub.gotType != "image/png" {
+					t.Errorf("expected the raw image to be posted as image/png, got %s %q", stub.gotType, stub.gotImage)
+				}
+			}
+		})
+	}
+}
+
+func TestHFTaskEmbedderCheckJointSpace(t *testing.T) {
+	tests := []struct {
+		name      string
+		stub      hfStub
+		wantErr   string
+		wantJoint bool /// whether the error says the model has no joint space, rather than that the check failed
+	}{
+		{"same dimensions", hfStub{text: `[[1, 2]]`, image: `[[3, 4]]`}, "", false},
+		{"different dimensions", hfStub{text: `[[1, 2]]`, image: `[[3, 4, 5]]`}, "2 dimensions and image embeddings 3", true},
+		{"text per token", hfStub{text: `[[[1, 2], [3, 4]]]`, image: `[[3, 4]]`}, "vector per token", true},
+		{"image per patch", hfStub{text: `[[1, 2]]`, image: `[[1, 2], [3, 4]]`}, "vector per patch", true},
+		{"unexpected response", hfStub{text: `{"error": "bad gateway"}`, image: `[[3, 4]]`}, "unexpected feature-extraction response", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := test.stub
+			server := httptest.NewServer(&stub)
+			defer server.Close()
+
+			err := NewHFTaskEmbedder(server.URL+"/text", server.URL+"/image", "", "key", time.Second).CheckJointSpace()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+			if errors.Is(err, ErrNoJointSpace) != test.wantJoint {
+				t.Errorf("errors.Is(%v, ErrNoJointSpace) is %v", err, !test.wantJoint)
+			}
+		})
+	}
+}
+
+func TestHFTaskEmbedderCheckJointSpaceUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": "Model is currently loading", "estimated_time": 600}`))
+	}))
+	defer server.Close()
+	err := NewHFTaskEmbedder(server.URL+"/text", server.URL+"/image", "", "key", time.Minute).CheckJointSpace()
+	if err == nil || errors.Is(err, ErrNoJointSpace) {
+		t.Errorf("expected the check to fail without ErrNoJointSpace, got %v", err)
+	}
+}
+
+// loadingStub answers with each status in turn, 503 with the estimated time, then keeps giving the last one
+type loadingStub struct {
+	statuses  []int
+	estimated float64
+	requests  int
+}
+
+func (s *loadingStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := s.statuses[min(s.requests, len(s.statuses)-1)]
+	s.requests++
+	switch status {
+	case http.StatusOK:
+		w.Write([]byte(`[[1, 2]]`))
+	case http.StatusServiceUnavailable:
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error": "Model is currently loading", "estimated_time": %g}`, s.estimated)
+	default:
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error": "internal error"}`))
+	}
+}
+
+func TestHFTaskEmbedderWaitsForTheModel(t *testing.T) {
+	tests := []struct {
+		name         string
+		stub         loadingStub
+		maxWait      time.Duration
+		wantSleeps   []time.Duration
+		wantRequests int
+		wantErr      string
+	}{
+		{"ready", loadingStub{statuses: []int{200}}, time.Minute, nil, 1, ""},
+		{"loaded in the estimated time", loadingStub{statuses: []int{503, 200}, estimated: 20}, time.Minute,
+			[]time.Duration{20 * time.Second}, 2, ""},
+		{"short estimates wait the minimum", loadingStub{statuses: []int{503, 200}, estimated: 0.5}, time.Minute,
+			[]time.Duration{hfMinLoadWait}, 2, ""},
+		{"no estimate waits the minimum", loadingStub{statuses: []int{503, 503, 200}}, time.Minute,
+			[]time.Duration{hfMinLoadWait, hfMinLoadWait}, 3, ""},
+		{"estimate over the max wait", loadingStub{statuses: []int{503}, estimated: 20}, 10 * time.Second,
+			nil, 1, "model not ready after 10s"},
+		{"waits add up to the max wait", loadingStub{statuses: []int{503}, estimated: 4}, 12 * time.Second,
+			[]time.Duration{hfMinLoadWait, hfMinLoadWait}, 3, "model not ready after 12s"},
+		{"other errors aren't retried", loadingStub{statuses: []int{500, 200}}, time.Minute,
+			nil, 1, "Request failed with code 500"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := test.stub
+			server := httptest.NewServer(&stub)
+			defer server.Close()
+
+			embedder := NewHFTaskEmbedder(server.URL, server.URL, "", "key", test.maxWait)
+			var sleeps []time.Duration
+			embedder.sleep = func(d time.Duration) {
+				sleeps = append(sleeps, d)
+			}
+			_, err := embedder.Do(textPayload("cat"))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+			} else if err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(sleeps, test.wantSleeps) || stub.requests != test.wantRequests {
+				t.Errorf("slept %v over %d requests, want %v over %d", sleeps, stub.requests, test.wantSleeps, test.wantRequests)
+			}
+		})
+	}
+}
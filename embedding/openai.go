@@ -0,0 +1,209 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+OpenAI style /v1/embeddings request and response:
+{
+	"model": "clip-vit-b-32",
+	"input": ["a red forklift", "data:image/jpeg;base64,/9j/4AAQ..."],
+	"encoding_format": "float"
+}
+{
+	"object": "list",
+	"data": [{"object": "embedding", "index": 0, "embedding": [0.1, ...]}],
+	"model": "clip-vit-b-32",
+	"usage": {"prompt_tokens": 5, "total_tokens": 5}
+}
+Images are sent as base64 data URLs, which is what multimodal OpenAI compatible servers accept.
+*/
+
+type openAIRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type openAIResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage struct {
+		PromptTokens int64 `json:"prompt_tokens"`
+		TotalTokens  int64 `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+type Usage struct {
+	Requests     int64 `json:"requests"`
+	Inputs       int64 `json:"inputs"`
+	PromptTokens int64 `json:"prompt_tokens"`
+	TotalTokens  int64 `json:"total_tokens"`
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("Embedding usage: %d requests, %d inputs, %d prompt tokens, %d total tokens",
+		u.Requests, u.Inputs, u.PromptTokens, u.TotalTokens)
+}
+
+// OpenAIEmbedder is an alternative to the Embedder for services with an OpenAI compatible embeddings API.
+// It takes the same payloads as the Embedder and returns the embeddings in the same shape.
+type OpenAIEmbedder struct {
+	url, apiKey, model string
+	client             *http.Client
+
+	mu    sync.Mutex
+	usage Usage
+}
+
+// NewOpenAIEmbedder creates an embedder for the full URL of an embeddings endpoint, e.g. https://host/v1/embeddings
+func NewOpenAIEmbedder(url, apiKey, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		url:    url,
+		apiKey: apiKey,
+		model:  model,
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Usage returns the totals reported by the service since the embedder was created
+func (o *OpenAIEmbedder) Usage() Usage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.usage
+}
+
+func (o *OpenAIEmbedder) Do(payload *RequestPayload) (map[string]interface{}, error) {
+	inputs, err := openAIInputs(payload)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(openAIRequest{
+		Model:          o.model,
+		Input:          inputs,
+		EncodingFormat: "float",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequest("POST", o.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/json")
+		if o.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+o.apiKey)
+		}
+		resp, err = o.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		/// retry
+		if resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests {
+			fmt.Printf("Status code %d - sleeping for %d seconds with max 5 retries\n", resp.StatusCode, 5<<i)
+			resp.Body.Close()
+			resp = nil
+			time.Sleep(time.Duration(5<<i) * time.Second)
+			continue
+		}
+		break
+	}
+	if resp == nil {
+		return nil, fmt.Errorf("Service unavailable")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		errreason, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Request failed with code %d and reason %s", resp.StatusCode, string(errreason))
+	}
+
+	decoded := openAIResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings response: %w", err)
+	}
+	if len(decoded.Data) != len(inputs) {
+		return nil, fmt.Errorf("sent %d inputs but got %d embeddings", len(inputs), len(decoded.Data))
+	}
+	for _, data := range decoded.Data {
+		if len(data.Embedding) == 0 || len(data.Embedding) != len(decoded.Data[0].Embedding) {
+			return nil, fmt.Errorf("embedding %d has %d dimensions, expected %d",
+				data.Index, len(data.Embedding), len(decoded.Data[0].Embedding))
+		}
+	}
+	o.mu.Lock()
+	o.usage.Requests++
+	o.usage.Inputs += int64(len(inputs))
+	o.usage.PromptTokens += decoded.Usage.PromptTokens
+	o.usage.TotalTokens += decoded.Usage.TotalTokens
+	o.mu.Unlock()
+
+	/// Put the rows back in input order, in the same shape the custom handler returns them
+	sort.Slice(decoded.Data, func(i, j int) bool {
+		return decoded.Data[i].Index < decoded.Data[j].Index
+	})
+	rows := make([]any, 0, len(decoded.Data))
+	for _, data := range decoded.Data {
+		row := make([]any, len(data.Embedding))
+		for i, val := range data.Embedding {
+			row[i] = val
+		}
+		rows = append(rows, row)
+	}
+	return map[string]interface{}{"embeddings": rows}, nil
+}
+
+// openAIInputs converts a payload into the input array. The embedding of the whole image
+// is used for find-main-object, as it is by the custom handler.
+func openAIInputs(payload *RequestPayload) ([]string, error) {
+	switch {
+	case payload.Inputs.Type == "get-embeddings" && payload.Inputs.Mode == "text":
+		return payload.Inputs.Candidates, nil
+	case payload.Inputs.Type == "get-embeddings" && payload.Inputs.Mode == MODE_IMAGE_BATCH:
+		inputs := make([]string, 0, len(payload.Inputs.Images))
+		for _, image := range payload.Inputs.Images {
+			dataurl, err := imageDataURL(image)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, dataurl)
+		}
+		return inputs, nil
+	case payload.Inputs.Type == "get-embeddings" && payload.Inputs.Mode == "image",
+		payload.Inputs.Type == "find-main-object":
+		dataurl, err := imageDataURL(payload.Inputs.Image)
+		if err != nil {
+			return nil, err
+		}
+		return []string{dataurl}, nil
+	}
+	return nil, fmt.Errorf("Unsupported request type %s mode %s", payload.Inputs.Type, payload.Inputs.Mode)
+}
+
+func imageDataURL(base64Data string) (string, error) {
+	/// Only the first few bytes are needed to sniff the type
+	head := base64Data
+	if len(head) > 684 {
+		head = head[:684]
+	}
+	imagedata, err := base64.StdEncoding.DecodeString(head)
+	if err != nil {
+		return "", fmt.Errorf("invalid image data: %w", err)
+	}
+	return "data:" + http.DetectContentType(imagedata) + ";base64," + base64Data, nil
+}
@@ -0,0 +1,115 @@
+package embedding
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pngHeader is enough of a PNG for the content type to be sniffed
+var pngHeader = base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+
+func TestOpenAIEmbedder(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    *RequestPayload
+		status     int
+		response   string
+		wantInputs []string
+		wantRows   []any
+		wantErr    string
+	}{
+		{
+			name:       "rows are put back in input order",
+			payload:    textPayload("cat", "dog", "car"),
+			status:     http.StatusOK,
+			response:   `{"data": [{"index": 2, "embedding": [3, 3]}, {"index": 0, "embedding": [1, 1]}, {"index": 1, "embedding": [2, 2]}], "usage": {"prompt_tokens": 3, "total_tokens": 3}}`,
+			wantInputs: []string{"cat", "dog", "car"},
+			wantRows:   []any{[]any{1.0, 1.0}, []any{2.0, 2.0}, []any{3.0, 3.0}},
+		},
+		{
+			name:       "images are sent as data urls",
+			payload:    &RequestPayload{Inputs: Payload{Type: "get-embeddings", Mode: MODE_IMAGE_BATCH, Images: []string{pngHeader, pngHeader}}},
+			status:     http.StatusOK,
+			response:   `{"data": [{"index": 0, "embedding": [1]}, {"index": 1, "embedding": [2]}]}`,
+			wantInputs: []string{"data:image/png;base64," + pngHeader, "data:image/png;base64," + pngHeader},
+			wantRows:   []any{[]any{1.0}, []any{2.0}},
+		},
+		{
+			name:     "error body is returned",
+			payload:  textPayload("cat"),
+			status:   http.StatusBadRequest,
+			response: `{"error": "unknown model"}`,
+			wantErr:  `code 400 and reason {"error": "unknown model"}`,
+		},
+		{
+			name:     "missing embeddings",
+			payload:  textPayload("cat", "dog"),
+			status:   http.StatusOK,
+			response: `{"data": [{"index": 0, "embedding": [1, 1]}]}`,
+			wantErr:  "sent 2 inputs but got 1 embeddings",
+		},
+		{
+			name:     "mixed dimensions",
+			payload:  textPayload("cat", "dog"),
+			status:   http.StatusOK,
+			response: `{"data": [{"index": 0, "embedding": [1, 1]}, {"index": 1, "embedding": [1, 1, 1]}]}`,
+			wantErr:  "embedding 1 has 3 dimensions, expected 2",
+		},
+		{
+			name:    "unsupported request",
+			payload: &RequestPayload{Inputs: Payload{Type: "zero-shot-classification"}},
+			wantErr: "Unsupported request type",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gotInputs []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer key" {
+					t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+				}
+				req := openAIRequest{}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Error(err)
+				}
+				if req.Model != "clip" || req.EncodingFormat != "float" {
+					t.Errorf("unexpected request %+v", req)
+				}
+				gotInputs = req.Input
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.response))
+			}))
+			defer server.Close()
+
+			embedder := NewOpenAIEmbedder(server.URL, "key", "clip")
+			resp, err := embedder.Do(test.payload)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				if embedder.Usage().Requests != 0 {
+					t.Errorf("failed requests shouldn't count towards the usage")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotInputs, test.wantInputs) {
+				t.Errorf("sent %v, want %v", gotInputs, test.wantInputs)
+			}
+			if !reflect.DeepEqual(resp["embeddings"], test.wantRows) {
+				t.Errorf("got rows %v, want %v", resp["embeddings"], test.wantRows)
+			}
+			usage := embedder.Usage()
+			if usage.Requests != 1 || usage.Inputs != int64(len(test.wantInputs)) {
+				t.Errorf("unexpected usage %+v", usage)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"log"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/evaluation"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"time"
+)
+
+type EvalOptions struct {
+	Dataset        string
+	Holdout        float64
+	Seed           int64
+	Namespace      string
+	ReportFile     string
+	KeepNamespace  bool
+	SettleDuration time.Duration
+	Bulk           service.BulkOptions
+}
+
+// runEval embeds the training portion of a folder-per-label dataset into a scratch namespace,
+// runs detection on the held out portion and prints a report
+func runEval(embedder embedding.Client, model ModelCfg, pcapikey string, opts EvalOptions) {
+	samples, err := dataset.LoadFolders(opts.Dataset, dataset.WalkOptions{})
+	handlers.PanicOnError(err)
+	if len(samples) == 0 {
+		log.Panicln("No images found in dataset ", opts.Dataset)
+	}
+	train, test := dataset.Split(samples, opts.Holdout, opts.Seed)
+	fmt.Printf("Dataset has %d samples, training on %d, testing on %d\n", len(samples), len(train), len(test))
+
+	pc := vectordb.NewPineconeDB(model.Host, pcapikey, opts.Namespace)
+	if !opts.KeepNamespace {
+		defer func() {
+			fmt.Println("Removing scratch namespace ", opts.Namespace)
+			err := pc.DeleteNamespace()
+			if err != nil {
+				log.Println("Failed to remove scratch namespace ", err)
+			}
+		}()
+	}
+	svc := newVersionedHandler(model, embedder, pc)
+
+	embeddings := toEmbedCfg(train)
+	report := bulkEmbed(svc, embeddings.Items, opts.Bulk)
+	waitForVectors(pc, uint32(2*report.Succeeded), opts.SettleDuration)
+
+	results := evaluation.Run(svc, test)
+	results.WriteTable(os.Stdout)
+	if opts.ReportFile != "" {
+		f, err := os.Create(opts.ReportFile)
+		handlers.PanicOnError(err)
+		defer f.Close()
+		err = results.WriteJSON(f)
+		handlers.PanicOnError(err)
+	}
+}
+
+// waitForVectors gives the index time to make freshly upserted vectors searchable
+func waitForVectors(pc *vectordb.PineconeDB, expected uint32, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		count, err := pc.VectorCount()
+		if err != nil {
+			log.Println("Unable to get vector count ", err)
+		} else if count >= expected {
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+	fmt.Println("Timed out waiting for vectors to become available, results may be incomplete")
+}
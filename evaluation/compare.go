@@ -0,0 +1,158 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/service"
+	"sort"
+	"text/tabwriter"
+)
+
+// FusedModel is the name the ensemble's fused prediction is reported under
+const FusedModel = "fused"
+
+type ModelAccuracy struct {
+	Name     string  `json:"name"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+// Disagreement is a sample the models predicted different labels for
+type Disagreement struct {
+	Path        string            `json:"path"`
+	Label       string            `json:"label"`
+	Predictions map[string]string `json:"predictions"` /// model name -> top label
+}
+
+type Comparison struct {
+	Total         int                           `json:"total"`
+	Models        []ModelAccuracy               `json:"models"`
+	Agreement     map[string]map[string]float64 `json:"agreement"` /// fraction of samples two models gave the same top label
+	Disagreements []Disagreement                `json:"disagreements"`
+}
+
+// Compare runs every model of the ensemble on the samples, which must already be indexed in each model's namespace,
+// and reports how accurate each model and the fused ranking are and where the models disagree.
+// Each sample's own vectors are left out of its rankings, otherwise every sample would find itself.
+func Compare(ensemble *service.Ensemble, samples []dataset.Sample) (*Comparison, error) {
+	names := make([]string, 0)
+	for _, model := range ensemble.Models() {
+		names = append(names, model.Name)
+	}
+	sort.Strings(names)
+	names = append(names, FusedModel)
+
+	correct := make(map[string]int)
+	agree := make(map[string]map[string]int)
+	for _, name := range names {
+		agree[name] = make(map[string]int)
+	}
+	comparison := &Comparison{Total: len(samples)}
+	for i, sample := range samples {
+		fmt.Printf("Comparing %d/%d %s\n", i+1, len(samples), sample.Path)
+		rankings, err := ensemble.Rankings(sample.Path, service.VectorIDs(sample.ID)...)
+		if err != nil {
+			return nil, err
+		}
+		predictions := make(map[string]string)
+		for name, labels := range rankings {
+			predictions[name] = NoPrediction
+			if len(labels) > 0 {
+				predictions[name] = labels[0].Label
+			}
+		}
+		predictions[FusedModel] = NoPrediction
+		if fused := ensemble.Fuse(rankings); len(fused) > 0 {
+			predictions[FusedModel] = fused[0].Label
+		}
+
+		disagree := false
+		for _, a := range names {
+			if predictions[a] == sample.Label {
+				correct[a]++
+			}
+			for _, b := range names {
+				if predictions[a] == predictions[b] {
+					agree[a][b]++
+				} else if a != FusedModel && b != FusedModel {
+					disagree = true
+				}
+			}
+		}
+		if disagree {
+			comparison.Disagreements = append(comparison.Disagreements, Disagreement{
+				Path:        sample.Path,
+				Label:       sample.Label,
+				Predictions: predictions,
+			})
+		}
+	}
+
+	comparison.Agreement = make(map[string]map[string]float64)
+	for _, a := range names {
+		accuracy := ModelAccuracy{Name: a, Correct: correct[a]}
+		comparison.Agreement[a] = make(map[string]float64)
+		if len(samples) > 0 {
+			accuracy.Accuracy = float64(correct[a]) / float64(len(samples))
+			for _, b := range names {
+				comparison.Agreement[a][b] = float64(agree[a][b]) / float64(len(samples))
+			}
+		}
+		comparison.Models = append(comparison.Models, accuracy)
+	}
+	return comparison, nil
+}
+
+// WriteJSON writes the comparison as indented JSON
+func (c *Comparison) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// WriteTable writes the comparison as a set of human readable tables
+func (c *Comparison) WriteTable(w io.Writer) {
+	fmt.Fprintf(w, "Samples: %d\n\n", c.Total)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tCORRECT\tTOP-1 ACCURACY")
+	for _, model := range c.Models {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\n", model.Name, model.Correct, model.Accuracy)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Agreement (fraction of samples with the same top label)")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "\t")
+	for _, model := range c.Models {
+		fmt.Fprintf(tw, "%s\t", model.Name)
+	}
+	fmt.Fprintln(tw)
+	for _, a := range c.Models {
+		fmt.Fprintf(tw, "%s\t", a.Name)
+		for _, b := range c.Models {
+			fmt.Fprintf(tw, "%.3f\t", c.Agreement[a.Name][b.Name])
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "Disagreements: %d\n", len(c.Disagreements))
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "IMAGE\tLABEL\t")
+	for _, model := range c.Models {
+		fmt.Fprintf(tw, "%s\t", model.Name)
+	}
+	fmt.Fprintln(tw)
+	for _, disagreement := range c.Disagreements {
+		fmt.Fprintf(tw, "%s\t%s\t", disagreement.Path, disagreement.Label)
+		for _, model := range c.Models {
+			fmt.Fprintf(tw, "%s\t", disagreement.Predictions[model.Name])
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,161 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/vectordb"
+	"sort"
+	"text/tabwriter"
+)
+
+// NoPrediction is used as the predicted label when the search returned nothing
+const NoPrediction = "<none>"
+
+type LabelStats struct {
+	Label     string  `json:"label"`
+	Support   int     `json:"support"`
+	TP        int     `json:"tp"`
+	FP        int     `json:"fp"`
+	FN        int     `json:"fn"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+}
+
+type Report struct {
+	Total        int                       `json:"total"`
+	Top1         int                       `json:"top1"`
+	Top5         int                       `json:"top5"`
+	Top1Accuracy float64                   `json:"top1_accuracy"`
+	Top5Accuracy float64                   `json:"top5_accuracy"`
+	Labels       []LabelStats              `json:"labels"`
+	Confusion    map[string]map[string]int `json:"confusion"` /// actual -> predicted -> count
+}
+
+// RankedLabels returns the distinct labels of the search results, best match first
+func RankedLabels(results []vectordb.SearchResult) []string {
+	labels := make([]string, 0)
+	for _, label := range service.RankLabels(results) {
+		labels = append(labels, label.Label)
+	}
+	return labels
+}
+
+// Run performs detection on each test sample and scores the top ranked labels against the true label
+func Run(svc *service.Handler, test []dataset.Sample) *Report {
+	report := &Report{
+		Confusion: make(map[string]map[string]int),
+	}
+	for i, sample := range test {
+		fmt.Printf("Evaluating %d/%d %s\n", i+1, len(test), sample.Path)
+		ranked := RankedLabels(svc.ImageDetection(sample.Path))
+		predicted := NoPrediction
+		if len(ranked) > 0 {
+			predicted = ranked[0]
+		}
+		report.add(sample.Label, predicted, ranked)
+	}
+	report.finish(dataset.Labels(test))
+	return report
+}
+
+func (r *Report) add(actual, predicted string, ranked []string) {
+	r.Total++
+	if predicted == actual {
+		r.Top1++
+	}
+	for i := 0; i < len(ranked) && i < 5; i++ {
+		if ranked[i] == actual {
+			r.Top5++
+			break
+		}
+	}
+	if _, ok := r.Confusion[actual]; !ok {
+		r.Confusion[actual] = make(map[string]int)
+	}
+	r.Confusion[actual][predicted]++
+}
+
+func (r *Report) finish(labels []string) {
+	if r.Total > 0 {
+		r.Top1Accuracy = float64(r.Top1) / float64(r.Total)
+		r.Top5Accuracy = float64(r.Top5) / float64(r.Total)
+	}
+	r.Labels = make([]LabelStats, 0, len(labels))
+	for _, label := range labels {
+		stats := LabelStats{Label: label}
+		for actual, predictions := range r.Confusion {
+			for predicted, count := range predictions {
+				switch {
+				case actual == label && predicted == label:
+					stats.TP += count
+				case actual == label:
+					stats.FN += count
+				case predicted == label:
+					stats.FP += count
+				}
+			}
+		}
+		stats.Support = stats.TP + stats.FN
+		if stats.TP+stats.FP > 0 {
+			stats.Precision = float64(stats.TP) / float64(stats.TP+stats.FP)
+		}
+		if stats.Support > 0 {
+			stats.Recall = float64(stats.TP) / float64(stats.Support)
+		}
+		r.Labels = append(r.Labels, stats)
+	}
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the report as a set of human readable tables
+func (r *Report) WriteTable(w io.Writer) {
+	fmt.Fprintf(w, "Samples: %d\n", r.Total)
+	fmt.Fprintf(w, "Top-1 accuracy: %.3f (%d/%d)\n", r.Top1Accuracy, r.Top1, r.Total)
+	fmt.Fprintf(w, "Top-5 accuracy: %.3f (%d/%d)\n\n", r.Top5Accuracy, r.Top5, r.Total)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LABEL\tSUPPORT\tPRECISION\tRECALL")
+	for _, stats := range r.Labels {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.3f\n", stats.Label, stats.Support, stats.Precision, stats.Recall)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	/// Columns are every label that was predicted at least once, rows are the actual labels
+	predicted := make(map[string]bool)
+	for _, predictions := range r.Confusion {
+		for label := range predictions {
+			predicted[label] = true
+		}
+	}
+	columns := make([]string, 0, len(predicted))
+	for label := range predicted {
+		columns = append(columns, label)
+	}
+	sort.Strings(columns)
+
+	fmt.Fprintln(w, "Confusion matrix (rows: actual, columns: predicted)")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "\t")
+	for _, column := range columns {
+		fmt.Fprintf(tw, "%s\t", column)
+	}
+	fmt.Fprintln(tw)
+	for _, stats := range r.Labels {
+		fmt.Fprintf(tw, "%s\t", stats.Label)
+		for _, column := range columns {
+			fmt.Fprintf(tw, "%d\t", r.Confusion[stats.Label][column])
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
@@ -3,9 +3,11 @@ module object-detection-zero-shot
 go 1.23.0
 
 require (
+	github.com/HugoSmits86/nativewebp v1.2.1
 	github.com/paul-at-nangalan/errorhandler v0.0.0-20220524092750-75ec0f2eca41
 	github.com/paul-at-nangalan/json-config v0.0.0-20210525054146-58797ba49d12
 	github.com/pinecone-io/go-pinecone/v3 v3.1.0
+	golang.org/x/image v0.24.0
 	google.golang.org/protobuf v1.36.6
 )
 
@@ -97,8 +97,14 @@ class EndpointHandler():
                 results = self.get_image_embeddings(image)
                 return results
 
+            ### several images in one call, one row of embeddings per image, in the same order
+            elif inputs['mode'] == 'image-batch':
+                images = [Image.open(BytesIO(base64.b64decode(img))) for img in inputs['images']]
+                results = self.get_image_embeddings(images)
+                return results
+
             else:
-                raise ValueError("Invalid mode. Use 'text' or 'image'.")
+                raise ValueError("Invalid mode. Use 'text', 'image' or 'image-batch'.")
         ### find the main object in the image and return the vector of that object
         elif msgtype == 'find-main-object':
             image = Image.open(BytesIO(base64.b64decode(inputs['image'])))
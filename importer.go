@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"log"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/service"
+	"os"
+)
+
+type ImportOptions struct {
+	Dir        string
+	Extensions string
+	Include    string
+	Exclude    string
+	DryRun     bool
+
+	COCOFile     string
+	VOCDir       string
+	ImageDir     string
+	CropDir      string
+	MinBoxSize   int
+	IncludeCrowd bool
+
+	Manifest string
+
+	Bulk service.BulkOptions
+}
+
+// toEmbedCfg turns dataset samples into embedding items, dropping repeated images
+func toEmbedCfg(samples []dataset.Sample) *service.EmbedCfg {
+	embeddings := &service.EmbedCfg{}
+	seen := make(map[string]string)
+	for _, sample := range samples {
+		if label, exists := seen[sample.ID]; exists {
+			if label != sample.Label {
+				log.Printf("Skipping %s, the same image is already labelled %s\n", sample.Path, label)
+			}
+			continue
+		}
+		seen[sample.ID] = sample.Label
+		embeddings.Items = append(embeddings.Items, service.Item{
+			Imagefile: sample.Path,
+			Label:     sample.Label,
+			ID:        sample.ID,
+		})
+	}
+	return embeddings
+}
+
+// runImport embeds every image in a folder-per-label directory tree
+func runImport(svc *service.Handler, opts ImportOptions) {
+	samples, err := dataset.LoadFolders(opts.Dir, dataset.WalkOptions{
+		Extensions: dataset.SplitList(opts.Extensions),
+		Include:    dataset.SplitList(opts.Include),
+		Exclude:    dataset.SplitList(opts.Exclude),
+	})
+	handlers.PanicOnError(err)
+	embeddings := toEmbedCfg(samples)
+	fmt.Printf("Found %d images, %d unique, in %d labels\n", len(samples), len(embeddings.Items), len(dataset.Labels(samples)))
+	if opts.DryRun {
+		for _, item := range embeddings.Items {
+			fmt.Printf("%s\t%s\t%s\n", item.ID, item.Label, item.Imagefile)
+		}
+		return
+	}
+	bulkEmbed(svc, embeddings.Items, opts.Bulk)
+}
+
+// runAnnotationImport crops every annotated object out of a COCO or VOC dataset and embeds the crops
+func runAnnotationImport(svc *service.Handler, opts ImportOptions) {
+	var annotations []dataset.Annotation
+	var err error
+	if opts.COCOFile != "" {
+		annotations, err = dataset.LoadCOCO(opts.COCOFile, opts.ImageDir)
+	} else {
+		annotations, err = dataset.LoadVOC(opts.VOCDir, opts.ImageDir)
+	}
+	handlers.PanicOnError(err)
+	filter := dataset.AnnotationFilter{
+		MinBoxSize:   opts.MinBoxSize,
+		IncludeCrowd: opts.IncludeCrowd,
+	}
+	annotations, skipped := filter.Filter(annotations)
+	fmt.Printf("Found %d annotations, skipped %d small or crowd boxes\n", len(annotations)+skipped, skipped)
+	if opts.DryRun {
+		for _, annotation := range annotations {
+			fmt.Printf("%s\t%s\t%s\t%v\n", annotation.AnnotationID, annotation.Label, annotation.ImagePath, annotation.Box)
+		}
+		return
+	}
+
+	cropdir := opts.CropDir
+	if cropdir == "" {
+		cropdir, err = os.MkdirTemp("", "crops")
+		handlers.PanicOnError(err)
+		defer os.RemoveAll(cropdir)
+	}
+	crops, failed, err := dataset.WriteCrops(annotations, cropdir)
+	handlers.PanicOnError(err)
+	if failed > 0 {
+		fmt.Printf("Failed to crop %d annotations, their boxes are outside the image\n", failed)
+	}
+
+	embeddings := &service.EmbedCfg{}
+	for _, crop := range crops {
+		embeddings.Items = append(embeddings.Items, service.Item{
+			Imagefile: crop.Path,
+			Label:     crop.Label,
+			ID:        crop.ID,
+			Metadata: map[string]interface{}{
+				"source_image":  crop.ImagePath,
+				"annotation_id": crop.AnnotationID,
+				"bbox_x":        crop.Box.Min.X,
+				"bbox_y":        crop.Box.Min.Y,
+				"bbox_width":    crop.Box.Dx(),
+				"bbox_height":   crop.Box.Dy(),
+			},
+		})
+	}
+	bulkEmbed(svc, embeddings.Items, opts.Bulk)
+}
+
+// runManifestImport embeds every row of a CSV or JSONL manifest.
+// Rows that fail validation or embedding are reported with their line numbers and don't stop the import.
+func runManifestImport(svc *service.Handler, opts ImportOptions) {
+	rows, rowerrs, err := dataset.LoadManifest(opts.Manifest)
+	handlers.PanicOnError(err)
+	for _, rowerr := range rowerrs {
+		fmt.Println("Invalid row ", rowerr.Error())
+	}
+	fmt.Printf("Manifest has %d valid and %d invalid rows\n", len(rows), len(rowerrs))
+
+	items := make([]service.Item, 0, len(rows))
+	lines := make(map[string]int)
+	for _, row := range rows {
+		id := row.ID
+		if id == "" && row.IsURL() {
+			id = dataset.StringID(row.Image)
+		} else if id == "" {
+			id, err = dataset.ContentID(row.Image)
+			if err != nil {
+				rowerrs = append(rowerrs, dataset.RowError{Line: row.Line, Err: err})
+				continue
+			}
+		}
+		lines[id] = row.Line
+		items = append(items, service.Item{
+			Imagefile: row.Image,
+			Label:     row.Label,
+			ID:        id,
+			Metadata:  row.Metadata,
+		})
+	}
+	if opts.DryRun {
+		for _, item := range items {
+			fmt.Printf("%d\t%s\t%s\t%s\t%v\n", lines[item.ID], item.ID, item.Label, item.Imagefile, item.Metadata)
+		}
+		return
+	}
+
+	downloaddir, err := os.MkdirTemp("", "manifest")
+	handlers.PanicOnError(err)
+	defer os.RemoveAll(downloaddir)
+
+	bulkopts := opts.Bulk
+	bulkopts.Prepare = func(item service.Item) (service.Item, func(), error) {
+		row := dataset.ManifestRow{Image: item.Imagefile}
+		if !row.IsURL() {
+			return item, nil, nil
+		}
+		imagefile, err := dataset.FetchImage(item.Imagefile, downloaddir)
+		if err != nil {
+			return item, nil, err
+		}
+		item.Imagefile = imagefile
+		return item, func() { os.Remove(imagefile) }, nil
+	}
+	report := bulkEmbed(svc, items, bulkopts)
+	if len(rowerrs)+len(report.Failures) == 0 {
+		return
+	}
+	fmt.Println("Rows not embedded:")
+	for _, rowerr := range rowerrs {
+		fmt.Println("  ", rowerr.Error())
+	}
+	for _, failure := range report.Failures {
+		fmt.Println("  ", dataset.RowError{Line: lines[failure.Item.ID], Err: failure.Err}.Error())
+	}
+}
+
+// bulkEmbed embeds the items with the worker pool and prints the report
+func bulkEmbed(svc *service.Handler, items []service.Item, opts service.BulkOptions) *service.BulkReport {
+	if opts.Progress == nil {
+		opts.Progress = os.Stderr
+	}
+	report, err := svc.BulkEmbed(items, opts)
+	handlers.PanicOnError(err)
+	report.Print(os.Stdout)
+	return report
+}
@@ -7,11 +7,13 @@ import (
 	"github.com/paul-at-nangalan/json-config/cfg"
 	"log"
 	"net/http"
-	"object-detection-zero-shot/embedding"
 	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
 	"object-detection-zero-shot/vectordb"
 	"object-detection-zero-shot/webfront"
 	"os"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -20,16 +22,78 @@ func main() {
 	emb := false
 	embeddingcfg := ""
 	runservice := false
+	evaluate := false
+	modelname := ""
+	fusion := ""
+	compare := false
+	checkversion := false
+	reindex := false
+	rollback := false
+	catchup := false
+	reindexopts := ReindexOptions{}
+	verify := false
+	usage := false
+	sweep := false
+	thumbnails := false
+	rebuild := false
+	evalopts := EvalOptions{}
+	importopts := ImportOptions{}
+	similar := false
+	searchopts := SearchOptions{}
 
 	flag.StringVar(&imagepath, "image-file", "", "The filename with the image to try and detect")
 	flag.StringVar(&embeddingcfg, "cfg", "", "Path to cfg dir")
 	flag.BoolVar(&emb, "embed", false, "Generate embeddings for the images or text")
 	flag.BoolVar(&runservice, "service", false, "Run as a service")
+	flag.BoolVar(&evaluate, "eval", false, "Evaluate detection accuracy against a folder-per-label dataset")
+	flag.StringVar(&evalopts.Dataset, "dataset", "", "Path to a folder-per-label dataset")
+	flag.Float64Var(&evalopts.Holdout, "holdout", 0.2, "Fraction of each label held out for testing")
+	flag.Int64Var(&evalopts.Seed, "seed", 1, "Seed for the train/test split")
+	flag.StringVar(&evalopts.Namespace, "eval-namespace", "", "Scratch namespace for the evaluation (default $PC_NAMESPACE-eval-<timestamp>)")
+	flag.StringVar(&evalopts.ReportFile, "report", "", "Write the evaluation or comparison report as JSON to this file")
+	flag.BoolVar(&evalopts.KeepNamespace, "keep-namespace", false, "Don't delete the scratch namespace after the evaluation")
+	flag.StringVar(&importopts.Dir, "import", "", "Embed every image in a folder-per-label directory tree")
+	flag.StringVar(&importopts.Extensions, "ext", "", "Comma separated image extensions to import (default .jpg,.jpeg,.png,.gif,.webp)")
+	flag.StringVar(&importopts.Include, "include", "", "Comma separated glob patterns, only matching files are imported")
+	flag.StringVar(&importopts.Exclude, "exclude", "", "Comma separated glob patterns, matching files are skipped")
+	flag.BoolVar(&importopts.DryRun, "dry-run", false, "List what would be imported without embedding anything")
+	flag.StringVar(&importopts.COCOFile, "import-coco", "", "Embed the annotated objects in a COCO annotations file")
+	flag.StringVar(&importopts.VOCDir, "import-voc", "", "Embed the annotated objects in a dir of Pascal VOC XML files")
+	flag.StringVar(&importopts.ImageDir, "images", "", "Dir the COCO/VOC image file names are relative to")
+	flag.StringVar(&importopts.CropDir, "crop-dir", "", "Keep the cropped objects in this dir (default a temp dir that is removed)")
+	flag.IntVar(&importopts.MinBoxSize, "min-box", 0, "Skip boxes narrower or shorter than this many pixels")
+	flag.BoolVar(&importopts.IncludeCrowd, "include-crowd", false, "Include COCO crowd annotations")
+	flag.StringVar(&importopts.Manifest, "manifest", "", "Embed every row of a CSV or JSONL manifest")
+	flag.IntVar(&importopts.Bulk.Concurrency, "concurrency", 4, "Number of items embedded at once")
+	flag.StringVar(&importopts.Bulk.Checkpoint, "checkpoint", "", "File of completed IDs, items already in it are skipped on a rerun")
+	flag.StringVar(&importopts.Bulk.FailuresFile, "failures", "", "Write the items that failed to embed to this file, in the embeddings config format")
+	flag.DurationVar(&evalopts.SettleDuration, "settle", 60*time.Second, "Max time to wait for upserted vectors to become searchable")
+	flag.StringVar(&modelname, "model", "", "Use the named model from models.json in the cfg dir, instead of the environment")
+	flag.StringVar(&fusion, "fusion", "", "Detect with every model in models.json, fusing their rankings with rrf or mean")
+	flag.BoolVar(&compare, "compare", false, "Compare the models in models.json on an indexed folder-per-label dataset")
+	flag.BoolVar(&checkversion, "check-version", false, "Compare the model and dimension of the index with the active embedder")
+	flag.BoolVar(&reindex, "reindex", false, "Embed every stored item again into a shadow namespace, then swap the PC_ALIAS_FILE alias to it")
+	flag.StringVar(&reindexopts.Target, "target-namespace", "", "Shadow namespace for -reindex (default $PC_NAMESPACE-<timestamp>), or the namespace for -rebuild")
+	flag.IntVar(&reindexopts.Sample, "verify-sample", 50, "Number of items searched to verify the shadow namespace")
+	flag.Float64Var(&reindexopts.MinMatchRate, "min-match", 0.9, "Fraction of the sampled items that must find their own label before swapping")
+	flag.BoolVar(&reindexopts.Force, "force", false, "Swap even if the shadow namespace fails verification")
+	flag.DurationVar(&reindexopts.CatchUp, "catch-up-for", time.Minute, "After -reindex swaps the alias, or with -catch-up, keep catching up from the previous namespace this long")
+	flag.BoolVar(&catchup, "catch-up", false, "Embed the items of the previous namespace that the active PC_ALIAS_FILE namespace is missing")
+	flag.BoolVar(&rollback, "rollback", false, "Swap the PC_ALIAS_FILE alias back to the previous namespace")
+	flag.BoolVar(&verify, "verify", false, "List uploads without vectors and vectors without uploads, using the upload manifest")
+	flag.BoolVar(&rebuild, "rebuild", false, "Embed every upload in the upload manifest into the namespace (or -target-namespace)")
+	flag.BoolVar(&usage, "usage", false, "Report the storage used by indexed images, detection images and the manifest")
+	flag.BoolVar(&sweep, "sweep", false, "Delete the uploads that RETENTION_INDEXED and RETENTION_QUERIES don't keep, once")
+	flag.BoolVar(&similar, "similar", false, "List the stored images most like -image-file")
+	flag.StringVar(&searchopts.Text, "search", "", "List the stored images that best match this text")
+	flag.StringVar(&searchopts.Filter, "filter", "", "Comma separated key:value metadata conditions for -search or -query -images-only, e.g. label:forklift")
+	flag.StringVar(&searchopts.Query, "query", "", "Search with weighted images and texts, e.g. \"image:photo.jpg + 'at night' - 0.5*'people'\"")
+	flag.BoolVar(&searchopts.ImagesOnly, "images-only", false, "With -query, list the matching stored images rather than labels")
+	flag.IntVar(&searchopts.Page, "page", 1, "Page of -similar, -search or -query -images-only results to list")
+	flag.IntVar(&searchopts.PageSize, "page-size", 20, "Number of -similar, -search or -query -images-only results per page")
+	flag.BoolVar(&thumbnails, "thumbnails", false, "Make the missing thumbnails of the uploads in the upload manifest")
 	flag.Parse()
 
-	apikey := os.ExpandEnv("$HF_APITOKEN")
-	url := os.ExpandEnv("$HF_OBJ_DETECTION_URL")
-
 	pcapikey := os.ExpandEnv("$PC_APIKEY")
 	pchost := os.ExpandEnv("$PC_HOST")
 	pcnamespace := os.ExpandEnv("$PC_NAMESPACE")
@@ -37,33 +101,192 @@ func main() {
 	if runservice {
 
 		// Get required environment variables
-		apikey := os.Getenv("HF_APITOKEN")
-		url := os.Getenv("HF_OBJ_DETECTION_URL")
 		pcapikey := os.Getenv("PC_APIKEY")
 		pchost := os.Getenv("PC_HOST")
 		pcnamespace := os.Getenv("PC_NAMESPACE")
-		uploadDir := os.Getenv("UPLOAD_DIR")
 		certfile := os.Getenv("CERTFILE")
 		keyfile := os.Getenv("KEYFILE")
-		if apikey == "" || url == "" || pcapikey == "" || pchost == "" || pcnamespace == "" || uploadDir == "" || certfile == "" || keyfile == "" {
+		if pcapikey == "" || pchost == "" || pcnamespace == "" || certfile == "" || keyfile == "" {
 			log.Fatal("Missing required environment variables")
 		}
 		// Create the embedder
-		embedder := embedding.NewEmbedder(url, apikey)
-		// Create the Pinecone DB connection
-		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		model := envModelCfg()
+		embedder, classifier, stats := newEmbeddingClient(model)
+		go logStats(stats, time.Hour)
+		// Create the Pinecone DB connection, following the namespace alias if there is one
+		aliasfile := os.Getenv("PC_ALIAS_FILE")
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, activeNamespace(aliasfile, model, pcnamespace))
+		if aliasfile != "" {
+			go followAlias(pc, aliasfile, model, ALIAS_INTERVAL)
+		}
 		// Create the service handler
-		svc := service.NewHandler(embedder, pc)
+		svc := newVersionedHandler(model, embedder, pc)
+		/// The check needs the model and, to sample vectors, a serverless index, so a failure doesn't stop the service
+		check, err := svc.CheckVersion(20)
+		if err != nil {
+			log.Println("Unable to check the index version ", err)
+		} else {
+			fmt.Println(check.String())
+			if !check.OK() && os.Getenv("MODEL_VERSION_POLICY") == string(service.VERSION_POLICY_REFUSE) {
+				log.Fatal("The index doesn't match the active model")
+			}
+		}
+		if classifier != nil {
+			svc.SetClassifier(classifier)
+		}
 		// Create the web frontend handler
-		_ = webfront.NewHandler(svc, uploadDir)
+		store := newUploadStore()
+		indexed, queries := envRetention("RETENTION_INDEXED"), envRetention("RETENTION_QUERIES")
+		go sweepUploads(store, indexed, queries, envExpiredVectors(pc), envDuration("RETENTION_SWEEP_INTERVAL", time.Hour))
+		front := webfront.NewHandler(svc, store.Blobs(), os.TempDir())
+		front.SetRetention(indexed, queries)
+		limits := uploads.DefaultLimits()
+		limits.MaxBytes = int64(envInt("MAX_UPLOAD_BYTES", int(limits.MaxBytes)))
+		limits.MaxDimension = envInt("MAX_IMAGE_DIMENSION", limits.MaxDimension)
+		limits.MaxPixels = envInt("MAX_IMAGE_PIXELS", limits.MaxPixels)
+		front.SetLimits(limits)
+		front.SetThumbnails(envThumbnails())
+		tokens := strings.Split(os.Getenv("AUTH_TOKENS"), ",")
+		if strings.TrimSpace(os.Getenv("AUTH_TOKENS")) == "" {
+			fmt.Println("AUTH_TOKENS is not set, the images of indexed items can't be viewed and the admin UI can't be used")
+		}
+		front.SetAuthTokens(tokens)
 		// Start the HTTPS server
 		port := os.Getenv("PORT")
 		if port == "" {
 			port = "443"
 		}
 		fmt.Printf("Starting server on port %s...\n", port)
-		err := http.ListenAndServeTLS(":"+port, certfile, keyfile, nil)
+		err = http.ListenAndServeTLS(":"+port, certfile, keyfile, nil)
+		handlers.PanicOnError(err)
+		return
+	}
+	if fusion != "" || compare {
+		if fusion == "" {
+			fusion = string(service.FUSION_RRF)
+		}
+		ensemble, stats := newEnsemble(embeddingcfg, pcapikey, service.FusionStrategy(fusion))
+		defer func() {
+			fmt.Println(stats())
+		}()
+		if compare {
+			if evalopts.Dataset == "" {
+				log.Fatal("-dataset is required with -compare")
+			}
+			runCompare(ensemble, evalopts.Dataset, evalopts.ReportFile)
+		} else {
+			runFusedDetection(ensemble, imagepath)
+		}
+		return
+	}
+	if usage {
+		runUsage(newUploadStore())
+		return
+	}
+	if sweep {
+		sweepUploadsOnce(newUploadStore(), vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace))
+		return
+	}
+	if thumbnails {
+		runThumbnails(newUploadStore())
+		return
+	}
+	if rollback {
+		runRollback(os.Getenv("PC_ALIAS_FILE"))
+		return
+	}
+	model := envModelCfg()
+	if modelname != "" {
+		model = findModel(embeddingcfg, modelname)
+		pchost = model.Host
+		pcnamespace = model.Namespace
+	} else {
+		pcnamespace = activeNamespace(os.Getenv("PC_ALIAS_FILE"), model, pcnamespace)
+	}
+	embedder, _, stats := newEmbeddingClient(model)
+	defer func() {
+		fmt.Println(stats())
+	}()
+	if evaluate {
+		if evalopts.Dataset == "" {
+			log.Fatal("-dataset is required with -eval")
+		}
+		if evalopts.Namespace == "" {
+			evalopts.Namespace = fmt.Sprintf("%s-eval-%d", pcnamespace, time.Now().Unix())
+		}
+		evalopts.Bulk = importopts.Bulk
+		if evalopts.Bulk.Checkpoint != "" {
+			/// The scratch namespace starts empty, a checkpoint would skip items it doesn't have
+			fmt.Println("Ignoring -checkpoint, an evaluation always embeds every training item")
+			evalopts.Bulk.Checkpoint = ""
+		}
+		runEval(embedder, model, pcapikey, evalopts)
+		return
+	}
+	if reindex || catchup {
+		reindexopts.AliasFile = os.Getenv("PC_ALIAS_FILE")
+		reindexopts.ImageDir = os.Getenv("UPLOAD_DIR")
+		reindexopts.Uploads = newUploadStore()
+		reindexopts.SettleDuration = evalopts.SettleDuration
+		reindexopts.Bulk = importopts.Bulk
+		if catchup {
+			runCatchUp(embedder, model, pcapikey, reindexopts)
+		} else {
+			runReindex(embedder, model, pcapikey, reindexopts)
+		}
+		return
+	}
+	if verify {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runVerify(pc, newUploadStore())
+		return
+	}
+	if rebuild {
+		if reindexopts.Target != "" {
+			pcnamespace = reindexopts.Target
+		}
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runRebuild(newVersionedHandler(model, embedder, pc), newUploadStore(), importopts.Bulk)
+		return
+	}
+	if checkversion {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		check, err := newVersionedHandler(model, embedder, pc).CheckVersion(100)
 		handlers.PanicOnError(err)
+		fmt.Println(check.String())
+		if !check.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+	if importopts.Dir != "" {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runImport(newVersionedHandler(model, embedder, pc), importopts)
+		return
+	}
+	if importopts.COCOFile != "" || importopts.VOCDir != "" {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runAnnotationImport(newVersionedHandler(model, embedder, pc), importopts)
+		return
+	}
+	if importopts.Manifest != "" {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runManifestImport(newVersionedHandler(model, embedder, pc), importopts)
+		return
+	}
+	if similar {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runSimilar(newVersionedHandler(model, embedder, pc), imagepath, searchopts)
+		return
+	}
+	if searchopts.Text != "" {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runTextSearch(newVersionedHandler(model, embedder, pc), searchopts)
+		return
+	}
+	if searchopts.Query != "" {
+		pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
+		runQuery(newVersionedHandler(model, embedder, pc), searchopts)
 		return
 	}
 	cfg.Setup(embeddingcfg)
@@ -73,12 +296,11 @@ func main() {
 	err := cfg.Read("embeddings", &embeddings)
 	handlers.PanicOnError(err)
 
-	embedder := embedding.NewEmbedder(url, apikey)
 	pc := vectordb.NewPineconeDB(pchost, pcapikey, pcnamespace)
-	svc := service.NewHandler(embedder, pc)
+	svc := newVersionedHandler(model, embedder, pc)
 
 	if emb {
-		svc.EmbedData(&embeddings)
+		bulkEmbed(svc, embeddings.Items, importopts.Bulk)
 	} else {
 		results := svc.ImageDetection(imagepath)
 		for _, result := range results {
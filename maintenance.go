@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"log"
+	"object-detection-zero-shot/uploads"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"time"
+)
+
+// envRetention reads the retention of a category of uploads, see uploads.ParseRetention
+func envRetention(name string) uploads.Retention {
+	retention, err := uploads.ParseRetention(os.Getenv(name))
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+	return retention
+}
+
+// envExpiredVectors returns the index to delete the vectors of expired uploads from if RETENTION_DELETE_VECTORS
+// is true, or nil to keep them searchable
+func envExpiredVectors(pc *vectordb.PineconeDB) *vectordb.PineconeDB {
+	switch os.Getenv("RETENTION_DELETE_VECTORS") {
+	case "", "false":
+		return nil
+	case "true":
+		return pc
+	}
+	log.Fatal("RETENTION_DELETE_VECTORS must be true or false")
+	return nil
+}
+
+// sweepUploads deletes the uploads the retention settings don't keep, then again every interval
+func sweepUploads(store *uploads.Store, indexed, queries uploads.Retention, pc *vectordb.PineconeDB, interval time.Duration) {
+	for {
+		sweepOnce(store, indexed, queries, pc)
+		time.Sleep(interval)
+	}
+}
+
+// sweepUploadsOnce applies RETENTION_INDEXED, RETENTION_QUERIES and RETENTION_DELETE_VECTORS
+func sweepUploadsOnce(store *uploads.Store, pc *vectordb.PineconeDB) {
+	sweepOnce(store, envRetention("RETENTION_INDEXED"), envRetention("RETENTION_QUERIES"), envExpiredVectors(pc))
+}
+
+// sweepOnce deletes the expired uploads, and their vectors too if pc isn't nil
+func sweepOnce(store *uploads.Store, indexed, queries uploads.Retention, pc *vectordb.PineconeDB) {
+	for _, category := range []string{uploads.CATEGORY_INDEXED, uploads.CATEGORY_QUERIES} {
+		retention := indexed
+		if category == uploads.CATEGORY_QUERIES {
+			retention = queries
+		}
+		result, err := store.Sweep(category, retention, time.Now())
+		if err != nil {
+			log.Println("Failed to sweep uploads ", category, err)
+		}
+		if result != nil && result.Deleted > 0 {
+			log.Printf("Deleted %d %s uploads (%.1f MB) by retention %s\n", result.Deleted, category,
+				float64(result.Bytes)/(1<<20), retention.String())
+		}
+	}
+	if pc == nil {
+		return
+	}
+	deleted, err := store.DeleteExpiredVectors(pc)
+	if err != nil {
+		log.Println("Failed to delete the vectors of expired uploads ", err)
+	}
+	if deleted > 0 {
+		log.Printf("Deleted the vectors of %d expired uploads\n", deleted)
+	}
+}
+
+// runUsage prints the storage used by each category of uploads
+func runUsage(store *uploads.Store) {
+	usage, err := store.Usage()
+	handlers.PanicOnError(err)
+	uploads.WriteUsage(os.Stdout, usage)
+	fmt.Printf("Retention: indexed %s, queries %s\n", envRetention("RETENTION_INDEXED").String(), envRetention("RETENTION_QUERIES").String())
+}
+
+// envThumbnails reads THUMBNAIL_SIZES, THUMBNAIL_FORMAT and THUMBNAIL_QUALITY
+func envThumbnails() uploads.ThumbnailConfig {
+	cfg := uploads.DefaultThumbnailConfig()
+	var err error
+	if sizes := os.Getenv("THUMBNAIL_SIZES"); sizes != "" {
+		cfg.Sizes, err = uploads.ParseThumbnailSizes(sizes)
+		if err != nil {
+			log.Fatalf("THUMBNAIL_SIZES: %s", err)
+		}
+	}
+	if format := os.Getenv("THUMBNAIL_FORMAT"); format != "" {
+		cfg.Format, err = uploads.ParseThumbnailFormat(format)
+		if err != nil {
+			log.Fatalf("THUMBNAIL_FORMAT: %s", err)
+		}
+	}
+	cfg.Quality = envInt("THUMBNAIL_QUALITY", cfg.Quality)
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		log.Fatal("THUMBNAIL_QUALITY must be from 1 to 100")
+	}
+	return cfg
+}
+
+// runThumbnails makes the thumbnails of every upload in the manifest that doesn't have them, e.g. after changing
+// THUMBNAIL_SIZES or THUMBNAIL_FORMAT, or for uploads from before thumbnails were made
+func runThumbnails(store *uploads.Store) {
+	cfg := envThumbnails()
+	entries, err := uploads.NewManifest(store.Blobs()).Entries()
+	handlers.PanicOnError(err)
+	made, failed := 0, 0
+	for _, entry := range entries {
+		if entry.Expired {
+			continue
+		}
+		exists, err := store.HasThumbnails(entry.ID, cfg)
+		handlers.PanicOnError(err)
+		if exists {
+			continue
+		}
+		file, err := store.Fetch(entry.File)
+		if err == nil {
+			err = store.MakeThumbnails(entry.ID, file, cfg)
+			os.Remove(file)
+		}
+		if err != nil {
+			fmt.Println("Failed to make thumbnails ", entry.ID, err)
+			failed++
+			continue
+		}
+		made++
+	}
+	fmt.Printf("Made the thumbnails of %d uploads, %d failed, of %d in the manifest\n", made, failed, len(entries))
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// AUTH_COOKIE holds the token for pages whose images can't send an Authorization header
+const AUTH_COOKIE = "auth_token"
+
+type TokenAuth struct {
+	mu     sync.RWMutex
+	tokens []string
+}
+
+// NewTokenAuth creates a middleware that only lets requests with one of the tokens through.
+// With no tokens every request is refused.
+func NewTokenAuth(tokens []string) *TokenAuth {
+	t := &TokenAuth{}
+	t.SetTokens(tokens)
+	return t
+}
+
+func (t *TokenAuth) SetTokens(tokens []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens = make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			t.tokens = append(t.tokens, token)
+		}
+	}
+}
+
+// Wrap wraps an http.HandlerFunc, the token is sent as "Authorization: Bearer <token>" or in the AUTH_COOKIE cookie
+func (t *TokenAuth) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !t.Allowed(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Allowed returns whether the request has one of the tokens
+func (t *TokenAuth) Allowed(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		cookie, err := r.Cookie(AUTH_COOKIE)
+		if err != nil {
+			return false
+		}
+		token = cookie.Value
+	}
+	return t.Valid(token)
+}
+
+// Valid returns whether the token is one of the tokens
+func (t *TokenAuth) Valid(token string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	valid := false
+	for _, allowed := range t.tokens {
+		/// Compare with every token in constant time, so the timing doesn't reveal them
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			valid = true
+		}
+	}
+	return valid && token != ""
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenAuthValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens []string
+		token  string
+		want   bool
+	}{
+		{"one of the tokens", []string{"alpha", "beta"}, "beta", true},
+		{"tokens are trimmed", []string{" alpha ", "beta\n"}, "alpha", true},
+		{"unknown token", []string{"alpha"}, "gamma", false},
+		{"prefix of a token", []string{"alpha"}, "alp", false},
+		{"token with a suffix", []string{"alpha"}, "alphabet", false},
+		{"case sensitive", []string{"alpha"}, "ALPHA", false},
+		{"empty token", []string{"alpha"}, "", false},
+		{"no tokens", nil, "", false},
+		{"only blank tokens", []string{"", " "}, "", false},
+		{"unset AUTH_TOKENS", []string{""}, "", false},
+	}
+	for _, test := range tests {
+		if got := NewTokenAuth(test.tokens).Valid(test.token); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+
+	auth := NewTokenAuth([]string{"alpha"})
+	auth.SetTokens([]string{"beta"})
+	if auth.Valid("alpha") || !auth.Valid("beta") {
+		t.Error("expected SetTokens to replace the tokens")
+	}
+}
+
+func TestTokenAuthWrap(t *testing.T) {
+	auth := NewTokenAuth([]string{"alpha"})
+	handler := auth.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	})
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		wantStatus    int
+	}{
+		{"bearer token", "Bearer alpha", "", http.StatusOK},
+		{"cookie", "", "alpha", http.StatusOK},
+		{"wrong bearer token", "Bearer beta", "", http.StatusUnauthorized},
+		{"wrong cookie", "", "beta", http.StatusUnauthorized},
+		{"not a bearer token", "Basic alpha", "", http.StatusUnauthorized},
+		{"bare token", "alpha", "", http.StatusUnauthorized},
+		{"a wrong bearer token isn't saved by the cookie", "Bearer beta", "alpha", http.StatusUnauthorized},
+		{"nothing", "", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/images/abc/thumb", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		if test.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: AUTH_COOKIE, Value: test.cookie})
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.wantStatus)
+			continue
+		}
+		if test.wantStatus == http.StatusOK && w.Body.String() != "image" {
+			t.Errorf("%s: got %q", test.name, w.Body)
+		}
+		if test.wantStatus == http.StatusUnauthorized && (w.Header().Get("WWW-Authenticate") != "Bearer" || w.Body.String() == "image") {
+			t.Errorf("%s: unexpected refusal %v %q", test.name, w.Header(), w.Body)
+		}
+	}
+}
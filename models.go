@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"github.com/paul-at-nangalan/json-config/cfg"
+	"log"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/evaluation"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/vectordb"
+	"os"
+)
+
+/**
+models.json in the cfg dir, one entry per model. Host defaults to $PC_HOST.
+{
+	"Models": [
+		{"Name": "vit-b-32", "Backend": "hf", "URL": "$HF_URL_B32", "APIKey": "$HF_APITOKEN", "ModelID": "openai/clip-vit-base-patch32", "Namespace": "objects"},
+		{"Name": "vit-l-14", "Backend": "hf", "URL": "$HF_URL_L14", "APIKey": "$HF_APITOKEN", "ModelID": "openai/clip-vit-large-patch14", "Host": "$PC_HOST_L14", "Namespace": "objects"}
+	]
+}
+*/
+
+type ModelsCfg struct {
+	Models []ModelCfg
+}
+
+func (m *ModelsCfg) Expand() {
+	for i, model := range m.Models {
+		m.Models[i].URL = os.ExpandEnv(model.URL)
+		m.Models[i].ClassifyURL = os.ExpandEnv(model.ClassifyURL)
+		m.Models[i].APIKey = os.ExpandEnv(model.APIKey)
+		m.Models[i].ModelID = os.ExpandEnv(model.ModelID)
+		m.Models[i].Host = os.ExpandEnv(model.Host)
+		m.Models[i].Namespace = os.ExpandEnv(model.Namespace)
+		if m.Models[i].Host == "" {
+			m.Models[i].Host = os.Getenv("PC_HOST")
+		}
+	}
+}
+
+func readModels(cfgdir string) ModelsCfg {
+	cfg.Setup(cfgdir)
+	models := ModelsCfg{}
+	err := cfg.Read("models", &models)
+	handlers.PanicOnError(err)
+	if len(models.Models) == 0 {
+		log.Fatal("No models in models.json")
+	}
+	names := make(map[string]bool)
+	for _, model := range models.Models {
+		if model.Name == "" || model.Namespace == "" {
+			log.Fatal("Every model needs a Name and a Namespace")
+		}
+		if names[model.Name] {
+			log.Fatal("Duplicate model name ", model.Name)
+		}
+		names[model.Name] = true
+	}
+	return models
+}
+
+// findModel returns the named model from models.json
+func findModel(cfgdir string, name string) ModelCfg {
+	for _, model := range readModels(cfgdir).Models {
+		if model.Name == name {
+			return model
+		}
+	}
+	log.Fatal("No model named ", name, " in models.json")
+	return ModelCfg{}
+}
+
+// newEnsemble creates a handler for every model in models.json. The returned func summarises each model's embedding stats.
+func newEnsemble(cfgdir string, pcapikey string, strategy service.FusionStrategy) (*service.Ensemble, func() string) {
+	models := make([]service.NamedHandler, 0)
+	allstats := make([]func() string, 0)
+	for _, model := range readModels(cfgdir).Models {
+		embedder, _, stats := newEmbeddingClient(model)
+		pc := vectordb.NewPineconeDB(model.Host, pcapikey, model.Namespace)
+		models = append(models, service.NamedHandler{Name: model.Name, Handler: newVersionedHandler(model, embedder, pc)})
+		allstats = append(allstats, stats)
+	}
+	ensemble, err := service.NewEnsemble(models, strategy)
+	handlers.PanicOnError(err)
+	stats := func() string {
+		summary := ""
+		for _, modelstats := range allstats {
+			summary += modelstats() + "\n"
+		}
+		return summary
+	}
+	return ensemble, stats
+}
+
+// runFusedDetection prints the fused labels for an image, with each model's rank and score
+func runFusedDetection(ensemble *service.Ensemble, imagefile string) {
+	labels, err := ensemble.Detect(imagefile)
+	handlers.PanicOnError(err)
+	for _, label := range labels {
+		fmt.Printf("%.4f %s\n", label.Score, label.Label)
+		for _, model := range ensemble.Models() {
+			if rank, ok := label.Ranks[model.Name]; ok {
+				fmt.Printf("    %s: rank %d score %.4f\n", model.Name, rank, label.Scores[model.Name])
+			}
+		}
+	}
+}
+
+// runCompare shows how the models disagree on a folder-per-label dataset that is indexed in every model's namespace
+func runCompare(ensemble *service.Ensemble, datasetdir string, reportfile string) {
+	samples, err := dataset.LoadFolders(datasetdir, dataset.WalkOptions{})
+	handlers.PanicOnError(err)
+	comparison, err := evaluation.Compare(ensemble, samples)
+	handlers.PanicOnError(err)
+	comparison.WriteTable(os.Stdout)
+	if reportfile != "" {
+		f, err := os.Create(reportfile)
+		handlers.PanicOnError(err)
+		defer f.Close()
+		err = comparison.WriteJSON(f)
+		handlers.PanicOnError(err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"log"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"time"
+)
+
+type ReindexOptions struct {
+	AliasFile      string         /// names the active namespace, see vectordb.Alias
+	ImageDir       string         /// where uploads without a recorded image file or object key are looked for
+	Uploads        *uploads.Store /// where the uploads with an object key are fetched from
+	Target         string         /// the shadow namespace, defaults to $PC_NAMESPACE-<timestamp>
+	Sample         int
+	MinMatchRate   float64
+	Force          bool /// swap even if the verification fails
+	SettleDuration time.Duration
+	CatchUp        time.Duration /// how long to keep catching up from the previous namespace after the swap
+	Bulk           service.BulkOptions
+}
+
+// Services read the alias this often, see followAlias
+const ALIAS_INTERVAL = 10 * time.Second
+
+// activeNamespace returns the namespace the alias file points at for the model, or the fallback if there is no alias yet
+func activeNamespace(aliasfile string, model ModelCfg, fallback string) string {
+	if aliasfile == "" {
+		return fallback
+	}
+	alias, err := vectordb.ReadAlias(aliasfile)
+	handlers.PanicOnError(err)
+	if alias == nil {
+		return fallback
+	}
+	if namespace := alias.NamespaceFor(model.version().String()); namespace != "" {
+		return namespace
+	}
+	return alias.Active
+}
+
+// runReindex embeds every item of the active namespace again into a shadow namespace with the model,
+// while the active namespace keeps serving. Once the shadow namespace is verified the alias is swapped to it.
+func runReindex(embedder embedding.Client, model ModelCfg, pcapikey string, opts ReindexOptions) {
+	if opts.AliasFile == "" {
+		log.Fatal("PC_ALIAS_FILE is required to reindex")
+	}
+	alias, err := vectordb.ReadAlias(opts.AliasFile)
+	handlers.PanicOnError(err)
+	if alias == nil {
+		alias = &vectordb.Alias{Active: model.Namespace}
+	}
+	if opts.Target == "" {
+		opts.Target = fmt.Sprintf("%s-%d", model.Namespace, time.Now().Unix())
+	}
+	if opts.Target == alias.Active {
+		log.Fatal("The target namespace is the active namespace ", opts.Target)
+	}
+	source := service.NewHandler(embedder, vectordb.NewPineconeDB(model.Host, pcapikey, alias.Active))
+	targetpc := vectordb.NewPineconeDB(model.Host, pcapikey, opts.Target)
+	target := newVersionedHandler(model, embedder, targetpc)
+	fmt.Printf("Reindexing %s into %s with %s\n", alias.Active, opts.Target, model.version().String())
+
+	bulkopts := opts.Bulk
+	downloaddir, err := os.MkdirTemp("", "reindex")
+	handlers.PanicOnError(err)
+	defer os.RemoveAll(downloaddir)
+	bulkopts.Prepare = prepareStored(opts, downloaddir)
+
+	items, err := source.StoredItems(opts.ImageDir)
+	handlers.PanicOnError(err)
+	fmt.Printf("Found %d items in %s\n", len(items), alias.Active)
+	report := bulkEmbed(target, items, bulkopts)
+	failures := len(report.Failures)
+
+	/// Catch up with anything uploaded to the active namespace while reindexing
+	done := make(map[string]bool)
+	for _, item := range items {
+		done[item.ID] = true
+	}
+	added, failed := catchUp(source, target, done, opts.ImageDir, bulkopts)
+	failures += failed
+	latest := append(items, added...)
+
+	waitForVectors(targetpc, uint32(2*(len(latest)-failures)), opts.SettleDuration)
+	check, err := service.VerifyReindex(source, target, latest, opts.Sample, time.Now().UnixNano())
+	handlers.PanicOnError(err)
+	fmt.Println(check.String())
+	if failures > 0 {
+		fmt.Printf("%d items failed to embed\n", failures)
+	}
+	if (failures > 0 || !check.OK(opts.MinMatchRate)) && !opts.Force {
+		fmt.Printf("Not swapping, %s is kept for inspection. Rerun with -force to swap anyway.\n", opts.Target)
+		os.Exit(1)
+	}
+
+	alias.Swap(opts.Target, model.version().String())
+	err = vectordb.WriteAlias(opts.AliasFile, alias)
+	handlers.PanicOnError(err)
+	fmt.Printf("%s is now active, %s is kept for -rollback\n", alias.Active, alias.Previous)
+	/// The services keep uploading to the old namespace until they next read the alias
+	keepCatchingUp(source, target, done, opts.ImageDir, bulkopts, opts.CatchUp)
+}
+
+// runCatchUp embeds the items in the previous namespace that the active one is missing, e.g. the uploads to services
+// still running the old model after a swap, which keep using the previous namespace until they are restarted
+func runCatchUp(embedder embedding.Client, model ModelCfg, pcapikey string, opts ReindexOptions) {
+	if opts.AliasFile == "" {
+		log.Fatal("PC_ALIAS_FILE is required to catch up")
+	}
+	alias, err := vectordb.ReadAlias(opts.AliasFile)
+	handlers.PanicOnError(err)
+	if alias == nil || alias.Previous == "" {
+		log.Fatal("There is no previous namespace to catch up from")
+	}
+	if alias.ActiveVersion != "" && alias.ActiveVersion != model.version().String() {
+		log.Fatalf("%s was embedded with %s, not %s", alias.Active, alias.ActiveVersion, model.version().String())
+	}
+	source := service.NewHandler(embedder, vectordb.NewPineconeDB(model.Host, pcapikey, alias.Previous))
+	target := newVersionedHandler(model, embedder, vectordb.NewPineconeDB(model.Host, pcapikey, alias.Active))
+	fmt.Printf("Catching up %s with %s\n", alias.Active, alias.Previous)
+
+	bulkopts := opts.Bulk
+	downloaddir, err := os.MkdirTemp("", "reindex")
+	handlers.PanicOnError(err)
+	defer os.RemoveAll(downloaddir)
+	bulkopts.Prepare = prepareStored(opts, downloaddir)
+
+	done, err := target.StoredIDs()
+	handlers.PanicOnError(err)
+	catchUp(source, target, done, opts.ImageDir, bulkopts)
+	keepCatchingUp(source, target, done, opts.ImageDir, bulkopts, opts.CatchUp)
+}
+
+// prepareStored is a service.BulkOptions.Prepare that finds the image of a stored item, see service.StoredItems
+func prepareStored(opts ReindexOptions, downloaddir string) func(item service.Item) (service.Item, func(), error) {
+	return func(item service.Item) (service.Item, func(), error) {
+		if _, ok := item.Metadata[service.METADATA_OBJECT_KEY]; ok {
+			return opts.Uploads.Prepare(item)
+		}
+		if item.Imagefile == "" {
+			return item, nil, fmt.Errorf("no image found for %s", item.ID)
+		}
+		row := dataset.ManifestRow{Image: item.Imagefile}
+		if !row.IsURL() {
+			return item, nil, nil
+		}
+		imagefile, err := dataset.FetchImage(item.Imagefile, downloaddir)
+		if err != nil {
+			return item, nil, err
+		}
+		item.Imagefile = imagefile
+		return item, func() { os.Remove(imagefile) }, nil
+	}
+}
+
+// catchUp embeds the items of the source namespace that aren't done into the target, and marks them done.
+// It returns the items it embedded and how many of them failed.
+func catchUp(source, target *service.Handler, done map[string]bool, imageDir string, bulkopts service.BulkOptions) ([]service.Item, int) {
+	items, err := source.StoredItems(imageDir)
+	handlers.PanicOnError(err)
+	added := make([]service.Item, 0)
+	for _, item := range items {
+		if !done[item.ID] {
+			added = append(added, item)
+			done[item.ID] = true
+		}
+	}
+	if len(added) == 0 {
+		return added, 0
+	}
+	fmt.Printf("Catching up with %d items added to the old namespace\n", len(added))
+	report := bulkEmbed(target, added, bulkopts)
+	return added, len(report.Failures)
+}
+
+// keepCatchingUp catches up every ALIAS_INTERVAL for the duration, so that the uploads of services that haven't
+// followed the alias yet aren't lost
+func keepCatchingUp(source, target *service.Handler, done map[string]bool, imageDir string, bulkopts service.BulkOptions, duration time.Duration) {
+	if duration <= 0 {
+		return
+	}
+	fmt.Printf("Catching up for %s while the services follow the alias\n", duration)
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); {
+		time.Sleep(min(ALIAS_INTERVAL, time.Until(deadline)))
+		catchUp(source, target, done, imageDir, bulkopts)
+	}
+}
+
+// runRollback makes the previous namespace active again
+func runRollback(aliasfile string) {
+	if aliasfile == "" {
+		log.Fatal("PC_ALIAS_FILE is required to roll back")
+	}
+	alias, err := vectordb.ReadAlias(aliasfile)
+	handlers.PanicOnError(err)
+	if alias == nil {
+		log.Fatal("There is no alias to roll back ", aliasfile)
+	}
+	err = alias.Rollback()
+	handlers.PanicOnError(err)
+	err = vectordb.WriteAlias(aliasfile, alias)
+	handlers.PanicOnError(err)
+	fmt.Printf("%s is now active, %s is kept to roll forward again\n", alias.Active, alias.Previous)
+}
+
+// followAlias switches the namespace whenever the alias file changes, see vectordb.Alias.NamespaceFor
+func followAlias(pc *vectordb.PineconeDB, aliasfile string, model ModelCfg, interval time.Duration) {
+	version := model.version().String()
+	ignored := ""
+	for {
+		time.Sleep(interval)
+		alias, err := vectordb.ReadAlias(aliasfile)
+		if err != nil {
+			log.Println("Unable to read the namespace alias ", err)
+			continue
+		}
+		if alias == nil {
+			continue
+		}
+		namespace := alias.NamespaceFor(version)
+		if namespace == "" {
+			if ignored != alias.Active {
+				log.Printf("Not switching to namespace %s, it was embedded with %s not %s\n", alias.Active, alias.ActiveVersion, version)
+				ignored = alias.Active
+			}
+			continue
+		}
+		if namespace != pc.Namespace() {
+			log.Printf("Switching from namespace %s to %s\n", pc.Namespace(), namespace)
+			pc.SetNamespace(namespace)
+		}
+	}
+}
+
+// runVerify lists the orphans between the uploads recorded in the manifest and the namespace
+func runVerify(pc *vectordb.PineconeDB, store *uploads.Store) {
+	check, err := uploads.Verify(uploads.NewManifest(store.Blobs()), pc)
+	handlers.PanicOnError(err)
+	check.WriteText(os.Stdout)
+	if !check.OK() {
+		os.Exit(1)
+	}
+}
+
+// runRebuild embeds every upload recorded in the manifest into the handler's namespace
+func runRebuild(svc *service.Handler, store *uploads.Store, opts service.BulkOptions) {
+	entries, err := uploads.NewManifest(store.Blobs()).Entries()
+	handlers.PanicOnError(err)
+	/// The retention deleted the images of expired entries, so there is nothing to embed
+	stored := make([]uploads.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Expired {
+			stored = append(stored, entry)
+		}
+	}
+	if expired := len(entries) - len(stored); expired > 0 {
+		fmt.Printf("Skipping %d uploads whose images the retention deleted\n", expired)
+	}
+	fmt.Printf("Rebuilding %d uploads\n", len(stored))
+	opts.Prepare = store.Prepare
+	bulkEmbed(svc, uploads.Items(stored), opts)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"log"
+	"object-detection-zero-shot/service"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+type SearchOptions struct {
+	Query      string /// a composite query, see service.ParseQuery
+	ImagesOnly bool   /// list the stored images matching the query rather than its labels
+	Text       string
+	Filter     string /// comma separated key:value conditions, see service.ParseFilter
+	Page       int    /// 1 based
+	PageSize   int
+}
+
+func (o SearchOptions) offset() int {
+	if o.Page < 1 || o.PageSize < 1 {
+		log.Fatal("-page and -page-size must be at least 1")
+	}
+	return (o.Page - 1) * o.PageSize
+}
+
+// runSimilar lists the stored images nearest to the image
+func runSimilar(svc *service.Handler, imagefile string, opts SearchOptions) {
+	if imagefile == "" {
+		log.Fatal("-image-file is required with -similar")
+	}
+	page, err := svc.SimilarImages(imagefile, opts.offset(), opts.PageSize)
+	handlers.PanicOnError(err)
+	printSearchPage(page, opts)
+}
+
+// runTextSearch lists the stored images that best match the text
+func runTextSearch(svc *service.Handler, opts SearchOptions) {
+	filter, err := service.ParseFilter(filterConditions(opts.Filter))
+	if err != nil {
+		log.Fatal(err)
+	}
+	page, err := svc.SearchText(opts.Text, filter, opts.offset(), opts.PageSize)
+	handlers.PanicOnError(err)
+	printSearchPage(page, opts)
+}
+
+// runQuery lists the labels, or with -images-only the stored images, that best match the composite query
+func runQuery(svc *service.Handler, opts SearchOptions) {
+	terms, err := service.ParseQuery(opts.Query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.ImagesOnly {
+		filter, err := service.ParseFilter(filterConditions(opts.Filter))
+		if err != nil {
+			log.Fatal(err)
+		}
+		page, err := svc.QueryImages(terms, filter, opts.offset(), opts.PageSize)
+		handlers.PanicOnError(err)
+		printSearchPage(page, opts)
+		return
+	}
+	results, err := svc.Query(terms, service.DetectOptions{})
+	handlers.PanicOnError(err)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tSCORE\tLABEL\tVECTORS")
+	for i, label := range service.RankLabels(results) {
+		fmt.Fprintf(tw, "%d\t%.4f\t%s\t%s\n", i+1, label.Score, label.Label, strings.Join(label.IDs, " "))
+	}
+	tw.Flush()
+}
+
+// filterConditions splits the comma separated -filter
+func filterConditions(filter string) []string {
+	conditions := make([]string, 0)
+	for _, condition := range strings.Split(filter, ",") {
+		if strings.TrimSpace(condition) != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions
+}
+
+func printSearchPage(page *service.SearchPage, opts SearchOptions) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tSCORE\tID\tLABEL\tIMAGE")
+	for i, result := range page.Results {
+		label, _ := result.Metadata[service.METADATA_VALUE].(string)
+		image, _ := result.Metadata[service.METADATA_OBJECT_KEY].(string)
+		if image == "" {
+			image, _ = result.Metadata[service.METADATA_IMAGE_FILE].(string)
+		}
+		fmt.Fprintf(tw, "%d\t%.4f\t%s\t%s\t%s\n", page.Offset+i+1, result.Score, service.ItemID(result.ID), label, image)
+	}
+	tw.Flush()
+	if page.More {
+		fmt.Printf("More results with -page %d\n", opts.Page+1)
+	}
+}
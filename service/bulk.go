@@ -0,0 +1,234 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BulkOptions struct {
+	Concurrency  int       /// number of items embedded at once, defaults to 1
+	Checkpoint   string    /// file of completed IDs, items already in it are skipped
+	FailuresFile string    /// if set the failed items are written here as an EmbedCfg, ready to retry
+	Progress     io.Writer /// progress bar output, nil for none
+
+	/// Prepare is called before an item is embedded, e.g. to download it.
+	/// The returned cleanup func is called once the item is done.
+	Prepare func(item Item) (Item, func(), error)
+}
+
+type BulkFailure struct {
+	Item Item
+	Err  error
+}
+
+type BulkReport struct {
+	Total     int
+	Skipped   int /// already in the checkpoint
+	Succeeded int
+	Failures  []BulkFailure
+	Duration  time.Duration
+}
+
+// BulkEmbed embeds the items with a pool of workers. Failed items are recorded in the report
+// rather than stopping the run, and every completed ID is appended to the checkpoint file
+// so that a rerun only embeds what is left.
+func (h *Handler) BulkEmbed(items []Item, opts BulkOptions) (*BulkReport, error) {
+	start := time.Now()
+	report := &BulkReport{Total: len(items)}
+
+	done, err := readCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]Item, 0, len(items))
+	for _, item := range items {
+		if done[item.ID] {
+			report.Skipped++
+			continue
+		}
+		pending = append(pending, item)
+	}
+
+	var checkpoint *os.File
+	if opts.Checkpoint != "" {
+		checkpoint, err = os.OpenFile(opts.Checkpoint, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+		}
+		defer checkpoint.Close()
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	bar := newProgress(opts.Progress, len(pending))
+	mu := sync.Mutex{}
+	queue := make(chan Item)
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				err := h.embedPrepared(item, opts.Prepare)
+
+				mu.Lock()
+				if err != nil {
+					report.Failures = append(report.Failures, BulkFailure{Item: item, Err: err})
+				} else {
+					report.Succeeded++
+					if checkpoint != nil {
+						if _, werr := checkpoint.WriteString(item.ID + "\n"); werr != nil {
+							fmt.Fprintln(os.Stderr, "Failed to write checkpoint ", werr)
+						}
+					}
+				}
+				bar.update(report.Succeeded, len(report.Failures))
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, item := range pending {
+		queue <- item
+	}
+	close(queue)
+	wg.Wait()
+	bar.finish()
+
+	report.Duration = time.Since(start)
+	if opts.FailuresFile != "" && len(report.Failures) > 0 {
+		if err = writeFailures(opts.FailuresFile, report.Failures); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// embedPrepared embeds a single item. A panic, e.g. from a bad item, fails only that item.
+func (h *Handler) embedPrepared(item Item, prepare func(item Item) (Item, func(), error)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panicked: %v", r)
+		}
+	}()
+	if prepare != nil {
+		prepared, cleanup, err := prepare(item)
+		if err != nil {
+			return err
+		}
+		if cleanup != nil {
+			defer cleanup()
+		}
+		/// Record where the image came from, not the prepared copy which is cleaned up
+		if _, ok := prepared.Metadata[METADATA_IMAGE_FILE]; !ok && item.Imagefile != "" && prepared.Imagefile != item.Imagefile {
+			metadata := map[string]interface{}{METADATA_IMAGE_FILE: item.Imagefile}
+			for key, val := range prepared.Metadata {
+				metadata[key] = val
+			}
+			prepared.Metadata = metadata
+		}
+		item = prepared
+	}
+	return h.EmbedItem(item)
+}
+
+func readCheckpoint(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	if path == "" {
+		return done, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id != "" {
+			done[id] = true
+		}
+	}
+	return done, scanner.Err()
+}
+
+func writeFailures(path string, failures []BulkFailure) error {
+	retry := EmbedCfg{}
+	for _, failure := range failures {
+		retry.Items = append(retry.Items, failure.Item)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create failures file: %w", err)
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(retry)
+}
+
+// Print writes a summary of the run, listing every item that needs retrying
+func (r *BulkReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Embedded %d, skipped %d already done, failed %d, of %d items in %s\n",
+		r.Succeeded, r.Skipped, len(r.Failures), r.Total, r.Duration.Round(time.Second))
+	if len(r.Failures) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Items to retry:")
+	for _, failure := range r.Failures {
+		fmt.Fprintf(w, "  %s (%s): %s\n", failure.Item.ID, failure.Item.Imagefile, failure.Err.Error())
+	}
+}
+
+// progress draws a single line progress bar with an ETA
+type progress struct {
+	w         io.Writer
+	total     int
+	start     time.Time
+	lastdrawn time.Time
+}
+
+func newProgress(w io.Writer, total int) *progress {
+	return &progress{w: w, total: total, start: time.Now()}
+}
+
+func (p *progress) update(succeeded, failed int) {
+	if p.w == nil {
+		return
+	}
+	done := succeeded + failed
+	/// Don't redraw more than a few times a second, but always draw the last one
+	if time.Since(p.lastdrawn) < 200*time.Millisecond && done < p.total {
+		return
+	}
+	p.lastdrawn = time.Now()
+
+	const width = 30
+	filled := width
+	eta := time.Duration(0)
+	if p.total > 0 {
+		filled = width * done / p.total
+	}
+	if done > 0 {
+		eta = time.Since(p.start) / time.Duration(done) * time.Duration(p.total-done)
+	}
+	fmt.Fprintf(p.w, "\r[%s%s] %d/%d failed %d ETA %s   ",
+		strings.Repeat("=", filled), strings.Repeat(" ", width-filled), done, p.total, failed, eta.Round(time.Second))
+}
+
+func (p *progress) finish() {
+	if p.w == nil {
+		return
+	}
+	fmt.Fprintln(p.w)
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"object-detection-zero-shot/embedding"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// failingClient fails every embedding, by panicking or with an error
+type failingClient struct {
+	panics bool
+}
+
+func (c failingClient) Do(payload *embedding.RequestPayload) (map[string]interface{}, error) {
+	if c.panics {
+		panic("bad item")
+	}
+	return nil, fmt.Errorf("service unavailable")
+}
+
+func TestBulkEmbedRecordsFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  failingClient
+		wantErr string
+	}{
+		{"error", failingClient{}, "service unavailable"},
+		{"panic", failingClient{panics: true}, "panicked: bad item"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			checkpoint := filepath.Join(dir, "checkpoint")
+			if err := os.WriteFile(checkpoint, []byte("done\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			failures := filepath.Join(dir, "failures.json")
+			items := []Item{
+				{ID: "done", Label: "cat", Imagefile: "done.jpg"},
+				{ID: "a", Label: "cat", Imagefile: "a.jpg"},
+				{ID: "b", Label: "dog", Imagefile: "b.jpg"},
+			}
+			h := NewHandler(test.client, nil)
+			report, err := h.BulkEmbed(items, BulkOptions{Concurrency: 2, Checkpoint: checkpoint, FailuresFile: failures})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Total != 3 || report.Skipped != 1 || report.Succeeded != 0 || len(report.Failures) != 2 {
+				t.Fatalf("unexpected report %+v", report)
+			}
+			for _, failure := range report.Failures {
+				if !strings.Contains(failure.Err.Error(), test.wantErr) {
+					t.Errorf("expected %s to fail with %q, got %v", failure.Item.ID, test.wantErr, failure.Err)
+				}
+			}
+
+			data, err := os.ReadFile(failures)
+			if err != nil {
+				t.Fatal(err)
+			}
+			retry := EmbedCfg{}
+			if err = json.Unmarshal(data, &retry); err != nil {
+				t.Fatal(err)
+			}
+			if len(retry.Items) != 2 {
+				t.Errorf("expected both failed items in the failures file, got %+v", retry.Items)
+			}
+			data, err = os.ReadFile(checkpoint)
+			if err != nil || string(data) != "done\n" {
+				t.Errorf("expected the checkpoint to be unchanged, got %q %v", data, err)
+			}
+		})
+	}
+}
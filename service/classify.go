@@ -0,0 +1,103 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"object-detection-zero-shot/embedding"
+	"sort"
+	"strings"
+)
+
+// CLIP's learned logit scale, used to turn cosine similarities into a softmax over the labels
+const clipLogitScale = 100
+
+// SetClassifier makes Classify use a backend that does zero-shot classification itself
+func (h *Handler) SetClassifier(classifier embedding.Classifier) {
+	h.classifier = classifier
+}
+
+// Classify scores each candidate label for the image, best first, with the scores summing to 1.
+// Without a classifier the scores are computed the same way CLIP does, from the image and label embeddings.
+func (h *Handler) Classify(imagefile string, labels []string) ([]embedding.Classification, error) {
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("at least one label is required")
+	}
+	for _, label := range labels {
+		if strings.Contains(label, ",") {
+			return nil, fmt.Errorf("label %s cannot contain a comma", label)
+		}
+	}
+	labelsCSV := strings.Join(labels, ",")
+	if h.classifier != nil {
+		payload, err := embedding.CreateDetectionPayload(imagefile, labelsCSV, embedding.OPMODE_CLASSIFY)
+		if err != nil {
+			return nil, err
+		}
+		results, err := h.classifier.Classify(payload)
+		if err != nil {
+			return nil, err
+		}
+		sortClassifications(results)
+		return results, nil
+	}
+
+	imgvector, err := h.getEmbedding(imagefile, "", embedding.OPMODE_IMAGE_EMBED)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := embedding.CreateDetectionPayload("", labelsCSV, embedding.OPMODE_TEXT_EMBED)
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.clipmodel.Do(payload)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := data["embeddings"].([]any)
+	if !ok || len(rows) != len(labels) {
+		return nil, fmt.Errorf("expected %d label embeddings", len(labels))
+	}
+	logits := make([]float64, len(labels))
+	maxlogit := math.Inf(-1)
+	for i, row := range rows {
+		txtvector, err := h.getVector([]any{row})
+		if err != nil {
+			return nil, err
+		}
+		logits[i] = clipLogitScale * cosine(imgvector, txtvector)
+		maxlogit = math.Max(maxlogit, logits[i])
+	}
+	total := 0.0
+	for i := range logits {
+		logits[i] = math.Exp(logits[i] - maxlogit)
+		total += logits[i]
+	}
+	results := make([]embedding.Classification, len(labels))
+	for i, label := range labels {
+		results[i] = embedding.Classification{Label: label, Score: float32(logits[i] / total)}
+	}
+	sortClassifications(results)
+	return results, nil
+}
+
+func sortClassifications(results []embedding.Classification) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	dot, norma, normb := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		norma += float64(a[i]) * float64(a[i])
+		normb += float64(b[i]) * float64(b[i])
+	}
+	if norma == 0 || normb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(norma) * math.Sqrt(normb))
+}
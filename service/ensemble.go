@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type FusionStrategy string
+
+const (
+	FUSION_RRF  FusionStrategy = "rrf"  /// reciprocal rank fusion, only the rank of each label matters
+	FUSION_MEAN FusionStrategy = "mean" /// average of each model's best score, a model that missed the label scores 0
+
+	/// Damps the weight of the top ranks in reciprocal rank fusion, 60 is the value from the original paper
+	rrfK = 60
+)
+
+// NamedHandler is one model of an ensemble, with its own embedder and namespace
+type NamedHandler struct {
+	Name    string
+	Handler *Handler
+}
+
+// FusedLabel is a label ranked by the ensemble, with the score and rank each model gave it
+type FusedLabel struct {
+	Label  string             `json:"label"`
+	Score  float64            `json:"score"`
+	Scores map[string]float32 `json:"scores"` /// model name -> best match score
+	Ranks  map[string]int     `json:"ranks"`  /// model name -> 1 based rank, missing if the model didn't find the label
+}
+
+// Ensemble runs detection with several models and fuses their label rankings
+type Ensemble struct {
+	models   []NamedHandler
+	strategy FusionStrategy
+	topK     uint32
+}
+
+func NewEnsemble(models []NamedHandler, strategy FusionStrategy) (*Ensemble, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("an ensemble needs at least one model")
+	}
+	if strategy != FUSION_RRF && strategy != FUSION_MEAN {
+		return nil, fmt.Errorf("unknown fusion strategy %s", strategy)
+	}
+	return &Ensemble{
+		models:   models,
+		strategy: strategy,
+		topK:     DEFAULT_TOPK,
+	}, nil
+}
+
+// Models returns the models of the ensemble
+func (e *Ensemble) Models() []NamedHandler {
+	return e.models
+}
+
+// Rankings runs detection with every model at once and returns each model's ranked labels, by model name.
+// Vectors with the exclude IDs are left out of the rankings, e.g. the image's own vectors when it is indexed.
+func (e *Ensemble) Rankings(imagefile string, exclude ...string) (map[string][]LabelScore, error) {
+	excluded := make(map[string]bool)
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+	rankings := make(map[string][]LabelScore)
+	errs := make([]error, 0)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, model := range e.models {
+		wg.Add(1)
+		go func(model NamedHandler) {
+			defer wg.Done()
+			results, err := model.Handler.Search(imagefile, e.topK+uint32(len(excluded)))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("model %s: %w", model.Name, err))
+				return
+			}
+			kept := results[:0]
+			for _, result := range results {
+				if !excluded[result.ID] {
+					kept = append(kept, result)
+				}
+			}
+			rankings[model.Name] = RankLabels(kept)
+		}(model)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return rankings, nil
+}
+
+// Detect fuses the label rankings of every model, best label first
+func (e *Ensemble) Detect(imagefile string) ([]FusedLabel, error) {
+	rankings, err := e.Rankings(imagefile)
+	if err != nil {
+		return nil, err
+	}
+	return e.Fuse(rankings), nil
+}
+
+// Fuse combines the label rankings of each model into one ranking
+func (e *Ensemble) Fuse(rankings map[string][]LabelScore) []FusedLabel {
+	index := make(map[string]*FusedLabel)
+	fused := make([]*FusedLabel, 0)
+	for name, labels := range rankings {
+		for rank, label := range labels {
+			entry, exists := index[label.Label]
+			if !exists {
+				entry = &FusedLabel{
+					Label:  label.Label,
+					Scores: make(map[string]float32),
+					Ranks:  make(map[string]int),
+				}
+				index[label.Label] = entry
+				fused = append(fused, entry)
+			}
+			entry.Scores[name] = label.Score
+			entry.Ranks[name] = rank + 1
+			switch e.strategy {
+			case FUSION_RRF:
+				entry.Score += 1.0 / float64(rrfK+rank+1)
+			case FUSION_MEAN:
+				entry.Score += float64(label.Score) / float64(len(e.models))
+			}
+		}
+	}
+	sort.SliceStable(fused, func(i, j int) bool {
+		if fused[i].Score != fused[j].Score {
+			return fused[i].Score > fused[j].Score
+		}
+		return fused[i].Label < fused[j].Label
+	})
+	results := make([]FusedLabel, len(fused))
+	for i, entry := range fused {
+		results[i] = *entry
+	}
+	return results
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// ranking is one model's labels, best first
+func ranking(labels ...LabelScore) []LabelScore {
+	return labels
+}
+
+func TestFuse(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  FusionStrategy
+		models    int
+		rankings  map[string][]LabelScore
+		want      []string  /// fused labels, best first
+		wantScore []float64 /// in the same order
+		wantRanks map[string]map[string]int
+	}{
+		{
+			name:     "rrf sums 1/(60+rank)",
+			strategy: FUSION_RRF,
+			models:   2,
+			rankings: map[string][]LabelScore{
+				"a": ranking(LabelScore{Label: "cat", Score: 0.9}, LabelScore{Label: "dog", Score: 0.8}, LabelScore{Label: "bird", Score: 0.7}),
+				"b": ranking(LabelScore{Label: "bird", Score: 0.3}, LabelScore{Label: "cat", Score: 0.2}),
+			},
+			want:      []string{"cat", "bird", "dog"},
+			wantScore: []float64{1.0/61 + 1.0/62, 1.0/63 + 1.0/61, 1.0 / 62},
+			wantRanks: map[string]map[string]int{"cat": {"a": 1, "b": 2}, "bird": {"a": 3, "b": 1}, "dog": {"a": 2}},
+		},
+		{
+			name:     "rrf ignores the scores",
+			strategy: FUSION_RRF,
+			models:   2,
+			rankings: map[string][]LabelScore{
+				"a": ranking(LabelScore{Label: "cat", Score: 0.99}, LabelScore{Label: "dog", Score: 0.1}),
+				"b": ranking(LabelScore{Label: "dog", Score: 0.2}, LabelScore{Label: "cat", Score: 0.19}),
+			},
+			want:      []string{"cat", "dog"}, /// a tie, broken by label
+			wantScore: []float64{1.0/61 + 1.0/62, 1.0/61 + 1.0/62},
+			wantRanks: map[string]map[string]int{"cat": {"a": 1, "b": 2}, "dog": {"a": 2, "b": 1}},
+		},
+		{
+			name:     "mean counts a missing label as 0",
+			strategy: FUSION_MEAN,
+			models:   2,
+			rankings: map[string][]LabelScore{
+				"a": ranking(LabelScore{Label: "cat", Score: 0.9}, LabelScore{Label: "dog", Score: 0.5}),
+				"b": ranking(LabelScore{Label: "dog", Score: 0.6}),
+			},
+			want:      []string{"dog", "cat"},
+			wantScore: []float64{0.55, 0.45},
+			wantRanks: map[string]map[string]int{"cat": {"a": 1}, "dog": {"a": 2, "b": 1}},
+		},
+		{
+			name:     "mean divides by every model, even one with no labels",
+			strategy: FUSION_MEAN,
+			models:   3,
+			rankings: map[string][]LabelScore{
+				"a": ranking(LabelScore{Label: "zebra", Score: 0.6}),
+				"b": ranking(LabelScore{Label: "ant", Score: 0.6}),
+				"c": ranking(),
+			},
+			want:      []string{"ant", "zebra"}, /// a tie, broken by label
+			wantScore: []float64{0.2, 0.2},
+			wantRanks: map[string]map[string]int{"ant": {"b": 1}, "zebra": {"a": 1}},
+		},
+		{
+			name:      "no labels",
+			strategy:  FUSION_RRF,
+			models:    1,
+			rankings:  map[string][]LabelScore{"a": ranking()},
+			want:      []string{},
+			wantScore: []float64{},
+			wantRanks: map[string]map[string]int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			models := make([]NamedHandler, test.models)
+			ensemble, err := NewEnsemble(models, test.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			/// The order models are ranged over mustn't matter
+			for i := 0; i < 5; i++ {
+				fused := ensemble.Fuse(test.rankings)
+				labels := make([]string, len(fused))
+				for i, label := range fused {
+					labels[i] = label.Label
+					if math.Abs(label.Score-test.wantScore[i]) > 1e-6 {
+						t.Errorf("%s scored %f, want %f", label.Label, label.Score, test.wantScore[i])
+					}
+					if !reflect.DeepEqual(label.Ranks, test.wantRanks[label.Label]) {
+						t.Errorf("%s ranked %v, want %v", label.Label, label.Ranks, test.wantRanks[label.Label])
+					}
+					if len(label.Scores) != len(label.Ranks) {
+						t.Errorf("%s has scores %v for ranks %v", label.Label, label.Scores, label.Ranks)
+					}
+				}
+				if !reflect.DeepEqual(labels, test.want) {
+					t.Fatalf("got %v, want %v", labels, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewEnsemble(t *testing.T) {
+	if _, err := NewEnsemble(nil, FUSION_RRF); err == nil {
+		t.Error("expected an error for an ensemble without models")
+	}
+	if _, err := NewEnsemble(make([]NamedHandler, 2), "max"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
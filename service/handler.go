@@ -3,45 +3,99 @@ package service
 import (
 	"fmt"
 	"github.com/paul-at-nangalan/errorhandler/handlers"
-	"log"
 	"object-detection-zero-shot/embedding"
 	"object-detection-zero-shot/vectordb"
 	"os"
 )
 
+// METADATA_VALUE is the label of an item, on both of its vectors.
+// METADATA_IMAGE_FILE records where the image of an item was embedded from, so that it can be embedded again, see StoredItems.
+// Uploads record their blob storage key as METADATA_OBJECT_KEY instead.
+const (
+	METADATA_VALUE      = "value"
+	METADATA_IMAGE_FILE = "image_file"
+	METADATA_OBJECT_KEY = "object_key"
+)
+
+// Vector IDs are the item ID with one of these prefixes
+const (
+	imagePrefix = "img-"
+	textPrefix  = "text-"
+)
+
 type Handler struct {
-	clipmodel  *embedding.Embedder
-	pineconedb *vectordb.PineconeDB
+	clipmodel     embedding.Client
+	classifier    embedding.Classifier /// optional, see Classify
+	pineconedb    vectordb.VectorStore
+	version       *ModelVersion /// optional, see SetVersion
+	versionPolicy VersionPolicy
 }
 
-func NewHandler(clipmodel *embedding.Embedder, pineconedb *vectordb.PineconeDB) *Handler {
+func NewHandler(clipmodel embedding.Client, pineconedb vectordb.VectorStore) *Handler {
 	return &Handler{
 		clipmodel:  clipmodel,
 		pineconedb: pineconedb,
 	}
 }
 
-func (h *Handler) getVector(emb []any) []float32 {
+func (h *Handler) getVector(emb []any) ([]float32, error) {
 	vector := make([]float32, 0)
 	for _, vectors := range emb {
-		for _, val := range vectors.([]any) {
-			vector = append(vector, float32(val.(float64)))
+		values, ok := vectors.([]any)
+		if !ok {
+			return nil, fmt.Errorf("unexpected embeddings format %T", vectors)
+		}
+		for _, val := range values {
+			f, ok := val.(float64)
+			if !ok {
+				return nil, fmt.Errorf("unexpected embedding value %T", val)
+			}
+			vector = append(vector, float32(f))
 		}
 	}
-	return vector
+	return vector, nil
 }
 
-func (h *Handler) getEmbedding(imagefile string, labels string, mode embedding.OperationMode) []float32 {
-	/// First get text embeddings
+func (h *Handler) getEmbedding(imagefile string, labels string, mode embedding.OperationMode) ([]float32, error) {
 	payload, err := embedding.CreateDetectionPayload(imagefile, labels, mode)
-	handlers.PanicOnError(err)
-	data, err := h.clipmodel.Do(payload)
-	handlers.PanicOnError(err)
+	if err != nil {
+		return nil, err
+	}
+	emb, err := h.embed(payload)
+	if err != nil {
+		return nil, err
+	}
+	return h.getVector(emb)
+}
 
-	emb := data["embeddings"].([]any)
+// getTextEmbedding embeds the text as a whole, commas and all, e.g. a search query or a label
+func (h *Handler) getTextEmbedding(text string) ([]float32, error) {
+	payload, err := embedding.CreateTextPayload(text)
+	if err != nil {
+		return nil, err
+	}
+	emb, err := h.embed(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(emb) != 1 {
+		return nil, fmt.Errorf("expected 1 text embedding, got %d", len(emb))
+	}
 	return h.getVector(emb)
 }
 
+func (h *Handler) embed(payload *embedding.RequestPayload) ([]any, error) {
+	data, err := h.clipmodel.Do(payload)
+	if err != nil {
+		return nil, err
+	}
+	emb, ok := data["embeddings"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("response has no embeddings")
+	}
+	return emb, nil
+}
+
 /**
 {
 	"Items": [
@@ -55,12 +109,18 @@ type Item struct {
 	Imagefile string
 	Label     string
 	ID        string
+	Metadata  map[string]interface{} /// optional extra metadata stored with both vectors
 }
 
 type EmbedCfg struct {
 	Items []Item
 }
 
+// VectorIDs returns the IDs of the text and image vectors EmbedItem upserts for the item ID
+func VectorIDs(id string) []string {
+	return []string{textPrefix + id, imagePrefix + id}
+}
+
 func (e *EmbedCfg) Expand() {
 	for i, item := range e.Items {
 		e.Items[i].Imagefile = os.ExpandEnv(item.Imagefile)
@@ -71,44 +131,104 @@ func (e *EmbedCfg) Expand() {
 func (h *Handler) EmbedData(embeddings *EmbedCfg) {
 
 	for _, item := range embeddings.Items {
-		if item.ID == "" {
-			log.Panicln("Each item must have a non empty ID")
-		}
-		/// First get text embeddings
-		fmt.Println("Text embeddings: ")
-		txtembedding := h.getEmbedding("", item.Label, embedding.OPMODE_TEXT_EMBED)
-
-		/// Then get image embeddings
-		/// We must split the image filenames ourselves
-		fmt.Println("Image embeddings: ")
-		imgembedding := h.getEmbedding(item.Imagefile, "", embedding.OPMODE_IMAGE_EMBED)
-		txtid := "text-" + item.ID
-		metadata := map[string]interface{}{
-			"value": item.Label, //// don't store image data here
-		}
-		err := h.pineconedb.UpsertVector(txtembedding, txtid, metadata)
+		err := h.EmbedItem(item)
 		handlers.PanicOnError(err)
+	}
 
-		imgid := "img-" + item.ID
-		err = h.pineconedb.UpsertVector(imgembedding, imgid, metadata)
-		handlers.PanicOnError(err)
+}
+
+// EmbedItem generates the text and image embeddings for a single item and upserts both vectors
+func (h *Handler) EmbedItem(item Item) error {
+	if item.ID == "" {
+		return fmt.Errorf("Each item must have a non empty ID")
+	}
+	/// First get text embeddings
+	txtembedding, err := h.getTextEmbedding(item.Label)
+	if err != nil {
+		return fmt.Errorf("failed to get text embedding for %s: %w", item.ID, err)
+	}
+
+	/// Then get image embeddings
+	/// We must split the image filenames ourselves
+	imgembedding, err := h.getEmbedding(item.Imagefile, "", embedding.OPMODE_IMAGE_EMBED)
+	if err != nil {
+		return fmt.Errorf("failed to get image embedding for %s: %w", item.ID, err)
+	}
+	txtid := textPrefix + item.ID
+	metadata := map[string]interface{}{}
+	for key, val := range item.Metadata {
+		metadata[key] = val
+	}
+	metadata[METADATA_VALUE] = item.Label //// don't store image data here
+	_, hasfile := metadata[METADATA_IMAGE_FILE]
+	_, haskey := metadata[METADATA_OBJECT_KEY]
+	if !hasfile && !haskey {
+		metadata[METADATA_IMAGE_FILE] = item.Imagefile
+	}
+	h.versionMetadata(metadata, len(txtembedding))
+	metadata[METADATA_KIND] = KIND_TEXT
+	err = h.pineconedb.UpsertVector(txtembedding, txtid, metadata)
+	if err != nil {
+		return err
 	}
 
+	imgid := imagePrefix + item.ID
+	metadata[METADATA_KIND] = KIND_IMAGE
+	return h.pineconedb.UpsertVector(imgembedding, imgid, metadata)
 }
 
-func (h *Handler) ImageDetection(imagefile string) []vectordb.SearchResult {
+// DEFAULT_TOPK is the number of nearest vectors a detection searches
+const DEFAULT_TOPK = 20
 
-	payload, err := embedding.CreateDetectionPayload(imagefile, "", embedding.OPMODE_MAINOBJECT)
+func (h *Handler) ImageDetection(imagefile string) []vectordb.SearchResult {
+	results, err := h.Search(imagefile, DEFAULT_TOPK)
 	handlers.PanicOnError(err)
+	return results
+}
 
-	data, err := h.clipmodel.Do(payload)
-	handlers.PanicOnError(err)
+// DetectOptions tune a detection, see Detect
+type DetectOptions struct {
+	TopK     uint32  /// nearest vectors to search, DEFAULT_TOPK if 0
+	MinScore float32 /// matches scoring less are dropped
+}
 
-	emb := data["embeddings"].([]any)
-	vector := h.getVector(emb)
+// Detect searches the vectors nearest to the image, best first, without the ones scoring less than opts.MinScore
+func (h *Handler) Detect(imagefile string, opts DetectOptions) ([]vectordb.SearchResult, error) {
+	if opts.TopK == 0 {
+		opts.TopK = DEFAULT_TOPK
+	}
+	results, err := h.Search(imagefile, opts.TopK)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filter(results), nil
+}
 
-	results, err := h.pineconedb.SearchVectors(vector, 20)
-	handlers.PanicOnError(err)
+// filter drops the results scoring less than MinScore
+func (opts DetectOptions) filter(results []vectordb.SearchResult) []vectordb.SearchResult {
+	matches := make([]vectordb.SearchResult, 0, len(results))
+	for _, result := range results {
+		if result.Score >= opts.MinScore {
+			matches = append(matches, result)
+		}
+	}
+	return matches
+}
 
-	return results
+// Search returns the topK stored vectors nearest to the main object in the image
+func (h *Handler) Search(imagefile string, topK uint32) ([]vectordb.SearchResult, error) {
+	vector, err := h.getEmbedding(imagefile, "", embedding.OPMODE_MAINOBJECT)
+	if err != nil {
+		return nil, err
+	}
+	return h.searchVector(vector, topK)
+}
+
+// searchVector returns the topK stored vectors of the active version nearest to the vector
+func (h *Handler) searchVector(vector []float32, topK uint32) ([]vectordb.SearchResult, error) {
+	results, err := h.pineconedb.SearchVectorsFiltered(vector, topK, h.versionFilter())
+	if err != nil {
+		return nil, err
+	}
+	return results, h.checkResultVersions(results)
 }
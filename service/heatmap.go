@@ -0,0 +1,192 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"object-detection-zero-shot/embedding"
+	"os"
+	"sync"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// The model embeds a whole image, so to see where a label matches the image is split into a grid of
+// HEATMAP_GRID x HEATMAP_GRID cells and every window of HEATMAP_WINDOW x HEATMAP_WINDOW cells is embedded on its own
+const (
+	HEATMAP_GRID   = 4
+	HEATMAP_WINDOW = 2
+	heatmapSize    = 448 /// longest side the image is scaled down to, so a window is about the size the model sees
+)
+
+// Box is a region of an image, in fractions of its width and height from the top left
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Heatmap is how well each part of an image matches a label
+type Heatmap struct {
+	Label    string      `json:"label"`
+	Grid     int         `json:"grid"`
+	Cells    [][]float32 `json:"cells"` /// a row of cells at a time, each the mean similarity of the windows covering it
+	Box      Box         `json:"box"`   /// the window most similar to the label
+	BoxScore float32     `json:"box_score"`
+}
+
+// Heatmap compares every window of the image with the label's text embedding, the windows are embedded at once
+func (h *Handler) Heatmap(imagefile, label string) (*Heatmap, error) {
+	f, err := os.Open(imagefile)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", imagefile, err)
+	}
+	img = ScaleDown(img, heatmapSize)
+	if img.Bounds().Dx() < HEATMAP_GRID || img.Bounds().Dy() < HEATMAP_GRID {
+		return nil, fmt.Errorf("the image is too small for a %dx%d heatmap", HEATMAP_GRID, HEATMAP_GRID)
+	}
+	text, err := h.getTextEmbedding(label)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := heatmapWindows(HEATMAP_GRID, HEATMAP_WINDOW)
+	scores := make([]float32, len(windows))
+	errs := make([]error, len(windows))
+	wg := sync.WaitGroup{}
+	for i, window := range windows {
+		wg.Add(1)
+		go func(i int, window image.Rectangle) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[i] = fmt.Errorf("embedding window %v panicked: %v", window, r)
+				}
+			}()
+			vector, err := h.cropEmbedding(img, cellBounds(img.Bounds(), window, HEATMAP_GRID))
+			if err != nil {
+				errs[i] = fmt.Errorf("failed to embed window %v: %w", window, err)
+				return
+			}
+			scores[i] = float32(cosine(vector, text))
+		}(i, window)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	best := bestWindow(scores)
+	return &Heatmap{
+		Label:    label,
+		Grid:     HEATMAP_GRID,
+		Cells:    heatmapCells(HEATMAP_GRID, windows, scores),
+		Box:      windowBox(windows[best], HEATMAP_GRID),
+		BoxScore: scores[best],
+	}, nil
+}
+
+// cropEmbedding embeds the part of the image inside bounds
+func (h *Handler) cropEmbedding(img image.Image, bounds image.Rectangle) ([]float32, error) {
+	crop := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(crop, crop.Bounds(), img, bounds.Min, draw.Src)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, crop); err != nil {
+		return nil, err
+	}
+	emb, err := h.embed(embedding.CreateImagePayload(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return h.getVector(emb)
+}
+
+// heatmapWindows returns every window of size x size cells in a grid x grid grid, in cells, a row at a time
+func heatmapWindows(grid, size int) []image.Rectangle {
+	windows := make([]image.Rectangle, 0, (grid-size+1)*(grid-size+1))
+	for y := 0; y+size <= grid; y++ {
+		for x := 0; x+size <= grid; x++ {
+			windows = append(windows, image.Rect(x, y, x+size, y+size))
+		}
+	}
+	return windows
+}
+
+// heatmapCells averages the scores of the windows covering each cell
+func heatmapCells(grid int, windows []image.Rectangle, scores []float32) [][]float32 {
+	cells := make([][]float32, grid)
+	for y := range cells {
+		cells[y] = make([]float32, grid)
+		for x := range cells[y] {
+			cell := image.Rect(x, y, x+1, y+1)
+			sum, n := float32(0), 0
+			for i, window := range windows {
+				if cell.In(window) {
+					sum += scores[i]
+					n++
+				}
+			}
+			if n > 0 {
+				cells[y][x] = sum / float32(n)
+			}
+		}
+	}
+	return cells
+}
+
+// bestWindow returns the index of the highest score, the first if there is a tie
+func bestWindow(scores []float32) int {
+	best := 0
+	for i, score := range scores {
+		if score > scores[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// cellBounds returns the pixels of the window of cells within the image bounds
+func cellBounds(bounds image.Rectangle, window image.Rectangle, grid int) image.Rectangle {
+	at := func(cell image.Point) image.Point {
+		return image.Pt(bounds.Min.X+bounds.Dx()*cell.X/grid, bounds.Min.Y+bounds.Dy()*cell.Y/grid)
+	}
+	return image.Rectangle{Min: at(window.Min), Max: at(window.Max)}
+}
+
+func windowBox(window image.Rectangle, grid int) Box {
+	return Box{
+		X:      float64(window.Min.X) / float64(grid),
+		Y:      float64(window.Min.Y) / float64(grid),
+		Width:  float64(window.Dx()) / float64(grid),
+		Height: float64(window.Dy()) / float64(grid),
+	}
+}
+
+// ScaleDown scales the image so its longest side is at most size pixels, smaller images are returned as they are
+func ScaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+	if width >= height {
+		width, height = size, max(1, height*size/width)
+	} else {
+		width, height = max(1, width*size/height), size
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
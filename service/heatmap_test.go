@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"object-detection-zero-shot/embedding"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// redClient embeds an image as how red it is, [red share, other share], and the text "red" as [1, 0]
+type redClient struct {
+	textClient
+}
+
+func (c *redClient) Do(payload *embedding.RequestPayload) (map[string]interface{}, error) {
+	if payload.Inputs.Image == "" {
+		return c.textClient.Do(payload)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload.Inputs.Image)
+	if err != nil {
+		return nil, err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	red, total := 0.0, 0.0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r, g, _, _ := img.At(x, y).RGBA(); r > 0x8000 && g < 0x8000 {
+				red++
+			}
+			total++
+		}
+	}
+	return map[string]interface{}{"embeddings": []any{[]any{red / total, 1 - red/total}}}, nil
+}
+
+func TestHeatmapWindows(t *testing.T) {
+	windows := heatmapWindows(4, 2)
+	if len(windows) != 9 || windows[0] != image.Rect(0, 0, 2, 2) || windows[1] != image.Rect(1, 0, 3, 2) || windows[8] != image.Rect(2, 2, 4, 4) {
+		t.Errorf("unexpected windows %v", windows)
+	}
+	if windows := heatmapWindows(4, 4); len(windows) != 1 || windows[0] != image.Rect(0, 0, 4, 4) {
+		t.Errorf("unexpected whole image window %v", windows)
+	}
+
+	/// Only the bottom right window scores, its corner cell gets the full score and the cells it shares less
+	scores := []float32{0, 0, 0, 0, 0, 0, 0, 0, 0.8}
+	want := [][]float32{
+		{0, 0, 0, 0},
+		{0, 0, 0, 0},
+		{0, 0, 0.2, 0.4},
+		{0, 0, 0.4, 0.8},
+	}
+	if cells := heatmapCells(4, windows, scores); !reflect.DeepEqual(cells, want) {
+		t.Errorf("got cells %v, want %v", cells, want)
+	}
+	if best := bestWindow(scores); best != 8 {
+		t.Errorf("best window %d", best)
+	}
+	if best := bestWindow([]float32{0.5, 0.7, 0.7}); best != 1 {
+		t.Errorf("expected the first of a tie, got %d", best)
+	}
+	if box := windowBox(windows[8], 4); box != (Box{X: 0.5, Y: 0.5, Width: 0.5, Height: 0.5}) {
+		t.Errorf("unexpected box %+v", box)
+	}
+
+	/// Windows cover the whole image even when it doesn't divide into cells
+	bounds := image.Rect(10, 20, 111, 79)
+	if got := cellBounds(bounds, image.Rect(0, 0, 4, 4), 4); got != bounds {
+		t.Errorf("got %v, want %v", got, bounds)
+	}
+	if got := cellBounds(bounds, image.Rect(2, 2, 4, 4), 4); got != image.Rect(60, 49, 111, 79) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	/// A red square in the bottom right quarter of a white image twice as wide as the heatmap is scaled to
+	img := image.NewRGBA(image.Rect(0, 0, 2*heatmapSize, heatmapSize))
+	for y := 0; y < heatmapSize; y++ {
+		for x := 0; x < 2*heatmapSize; x++ {
+			img.Set(x, y, color.White)
+			if x >= heatmapSize && y >= heatmapSize/2 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+	imagefile := filepath.Join(t.TempDir(), "red.png")
+	f, err := os.Create(imagefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	client := &redClient{textClient{vectors: map[string][]float64{"red square": {1, 0}}}}
+	heatmap, err := NewHandler(client, nil).Heatmap(imagefile, "red square")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heatmap.Label != "red square" || heatmap.Grid != HEATMAP_GRID || len(heatmap.Cells) != HEATMAP_GRID {
+		t.Fatalf("unexpected heatmap %+v", heatmap)
+	}
+	if heatmap.Box != (Box{X: 0.5, Y: 0.5, Width: 0.5, Height: 0.5}) || heatmap.BoxScore < 0.99 {
+		t.Errorf("expected the box on the red square, got %+v %f", heatmap.Box, heatmap.BoxScore)
+	}
+	if heatmap.Cells[3][3] <= heatmap.Cells[0][0] || heatmap.Cells[0][0] != 0 {
+		t.Errorf("expected the cells to be hotter on the red square, got %v", heatmap.Cells)
+	}
+	if len(client.candidates) != 1 {
+		t.Errorf("expected the label to be embedded once, got %v", client.candidates)
+	}
+
+	if _, err = NewHandler(client, nil).Heatmap(imagefile, "blue square"); err == nil {
+		t.Error("expected the label embedding error")
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	tests := []struct {
+		width, height int
+		size          int
+		want          [2]int
+	}{
+		{1000, 500, 256, [2]int{256, 128}},
+		{500, 1000, 256, [2]int{128, 256}},
+		{300, 300, 256, [2]int{256, 256}},
+		{1000, 3, 256, [2]int{256, 1}}, /// never scaled to nothing
+		{200, 100, 256, [2]int{200, 100}},
+		{256, 10, 256, [2]int{256, 10}},
+	}
+	for _, test := range tests {
+		img := image.NewGray(image.Rect(0, 0, test.width, test.height))
+		scaled := ScaleDown(img, test.size)
+		if got := [2]int{scaled.Bounds().Dx(), scaled.Bounds().Dy()}; got != test.want {
+			t.Errorf("%dx%d at %d: got %v, want %v", test.width, test.height, test.size, got, test.want)
+		}
+		if test.width <= test.size && test.height <= test.size && scaled != image.Image(img) {
+			t.Errorf("%dx%d at %d: expected a small image to be returned as it is", test.width, test.height, test.size)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrItemNotFound = errors.New("no stored item")
+
+// Relabel embeds the label as the item's text vector, and rewrites the label in the metadata of both its vectors.
+// The image vector is kept as it is, so the image doesn't need to be embedded again.
+func (h *Handler) Relabel(id, label string) error {
+	imgid := imagePrefix + id
+	vectors, err := h.pineconedb.FetchVectors([]string{imgid})
+	if err != nil {
+		return err
+	}
+	img, ok := vectors[imgid]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrItemNotFound, id)
+	}
+	if h.version != nil && !h.sameVersion(img.Metadata) {
+		/// The new text vector would be from another model than the image vector
+		return fmt.Errorf("%w: %s, reindex it before relabeling", ErrVersionMismatch, id)
+	}
+	txtembedding, err := h.getTextEmbedding(label)
+	if err != nil {
+		return fmt.Errorf("failed to get text embedding for %s: %w", id, err)
+	}
+	metadata := map[string]interface{}{}
+	for key, val := range img.Metadata {
+		metadata[key] = val
+	}
+	metadata[METADATA_VALUE] = label
+	metadata[METADATA_KIND] = KIND_TEXT
+	if err = h.pineconedb.UpsertVector(txtembedding, textPrefix+id, metadata); err != nil {
+		return err
+	}
+	metadata[METADATA_KIND] = KIND_IMAGE
+	return h.pineconedb.UpsertVector(img.Values, imgid, metadata)
+}
+
+// DeleteItem deletes the text and image vectors of the item
+func (h *Handler) DeleteItem(id string) error {
+	return h.pineconedb.DeleteVectors(VectorIDs(id))
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"testing"
+)
+
+func TestRelabel(t *testing.T) {
+	active := map[string]interface{}{METADATA_MODEL_ID: "clip", METADATA_PREPROCESS: PreprocessVersion}
+	other := map[string]interface{}{METADATA_MODEL_ID: "siglip", METADATA_PREPROCESS: PreprocessVersion}
+	tests := []struct {
+		name     string
+		policy   VersionPolicy /// "" for no version
+		id       string
+		metadata map[string]interface{} /// the version of the stored item
+		wantErr  error
+	}{
+		{"no version", "", "a", nil, nil},
+		{"off, the active version", VERSION_POLICY_OFF, "a", active, nil},
+		{"off, another version", VERSION_POLICY_OFF, "a", other, ErrVersionMismatch}, /// the text vector would be from another model whatever the policy
+		{"filter, the active version", VERSION_POLICY_FILTER, "a", active, nil},
+		{"filter, another version", VERSION_POLICY_FILTER, "a", other, ErrVersionMismatch},
+		{"filter, unversioned", VERSION_POLICY_FILTER, "a", nil, ErrVersionMismatch},
+		{"filter-legacy, unversioned", VERSION_POLICY_FILTER_LEGACY, "a", nil, nil},
+		{"filter-legacy, another version", VERSION_POLICY_FILTER_LEGACY, "a", other, ErrVersionMismatch},
+		{"refuse, another version", VERSION_POLICY_REFUSE, "a", other, ErrVersionMismatch},
+		{"no vectors", "", "missing", nil, ErrItemNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := vectordb.NewMemoryDB()
+			for prefix, kind := range map[string]string{textPrefix: KIND_TEXT, imagePrefix: KIND_IMAGE} {
+				metadata := map[string]interface{}{METADATA_VALUE: "cat", METADATA_KIND: kind, METADATA_OBJECT_KEY: "indexed/a.jpg"}
+				for key, val := range test.metadata {
+					metadata[key] = val
+				}
+				if err := db.UpsertVector([]float32{1, 0}, prefix+"a", metadata); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before, _ := db.FetchVectors(VectorIDs("a"))
+			h := NewHandler(&textClient{vectors: map[string][]float64{"kitten": {0, 1}}}, db)
+			if test.policy != "" {
+				if err := h.SetVersion(ModelVersion{ModelID: "clip", Preprocess: PreprocessVersion}, test.policy); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := h.Relabel(test.id, "kitten")
+			after, _ := db.FetchVectors(VectorIDs("a"))
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %v", test.wantErr, err)
+				}
+				if !reflect.DeepEqual(after, before) {
+					t.Errorf("expected the vectors to be unchanged, got %+v", after)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, img := after[textPrefix+"a"], after[imagePrefix+"a"]
+			if !reflect.DeepEqual(text.Values, []float32{0, 1}) || !reflect.DeepEqual(img.Values, []float32{1, 0}) {
+				t.Errorf("expected a new text vector and the same image vector, got %v and %v", text.Values, img.Values)
+			}
+			for _, vector := range []vectordb.StoredVector{text, img} {
+				want := map[string]interface{}{}
+				for key, val := range before[vector.ID].Metadata {
+					want[key] = val
+				}
+				want[METADATA_VALUE] = "kitten"
+				if !reflect.DeepEqual(vector.Metadata, want) {
+					t.Errorf("%s: got metadata %v, want %v", vector.ID, vector.Metadata, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	db := vectordb.NewMemoryDB()
+	for _, id := range append(VectorIDs("a"), VectorIDs("b")...) {
+		if err := db.UpsertVector([]float32{1, 0}, id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandler(nil, db)
+	if err := h.DeleteItem("a"); err != nil {
+		t.Fatal(err)
+	}
+	/// Deleting an item that's gone isn't an error
+	if err := h.DeleteItem("a"); err != nil {
+		t.Fatal(err)
+	}
+	ids, _, err := db.ListIDs("", 10, "")
+	if err != nil || !reflect.DeepEqual(ids, []string{imagePrefix + "b", textPrefix + "b"}) {
+		t.Errorf("expected only b's vectors to be left, got %v %v", ids, err)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"object-detection-zero-shot/vectordb"
+	"strings"
+)
+
+// LabelScore is a label found in search results, with the score of its best match
+type LabelScore struct {
+	Label string   `json:"label"`
+	Score float32  `json:"score"`
+	IDs   []string `json:"ids"` /// every vector that matched with this label, best first
+}
+
+// RankLabels groups the search results by label, best label first.
+// Results without a label are ignored.
+func RankLabels(results []vectordb.SearchResult) []LabelScore {
+	index := make(map[string]int)
+	labels := make([]LabelScore, 0)
+	/// The results are already best first, so the first match for a label is its best
+	for _, result := range results {
+		label, ok := result.Metadata[METADATA_VALUE].(string)
+		if !ok {
+			continue
+		}
+		i, exists := index[label]
+		if !exists {
+			i = len(labels)
+			index[label] = i
+			labels = append(labels, LabelScore{Label: label, Score: result.Score})
+		}
+		labels[i].IDs = append(labels[i].IDs, result.ID)
+	}
+	return labels
+}
+
+// Kinds of stored vector, recorded as METADATA_KIND. Vectors upserted before it was recorded are told apart by VectorKind.
+const (
+	METADATA_KIND = "kind"
+	KIND_TEXT     = "text"
+	KIND_IMAGE    = "image"
+)
+
+// VectorKind returns whether the vector was embedded from the label text or the image of an item
+func VectorKind(id string) string {
+	switch {
+	case strings.HasPrefix(id, textPrefix):
+		return KIND_TEXT
+	case strings.HasPrefix(id, imagePrefix):
+		return KIND_IMAGE
+	}
+	return ""
+}
+
+// ItemID returns the ID of the item the vector was embedded from
+func ItemID(vectorID string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(vectorID, textPrefix), imagePrefix)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"testing"
+)
+
+func searchResult(id, label string, score float32) vectordb.SearchResult {
+	metadata := map[string]interface{}{}
+	if label != "" {
+		metadata["value"] = label
+	}
+	return vectordb.SearchResult{ID: id, Score: score, Metadata: metadata}
+}
+
+func TestRankLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []vectordb.SearchResult
+		want    []LabelScore
+	}{
+		{
+			name:    "no results",
+			results: nil,
+			want:    []LabelScore{},
+		},
+		{
+			name: "a label scores its best vector",
+			results: []vectordb.SearchResult{
+				searchResult("img-a", "cat", 0.9), searchResult("text-b", "dog", 0.8), searchResult("text-a", "cat", 0.7), searchResult("img-c", "cat", 0.5),
+			},
+			want: []LabelScore{
+				{Label: "cat", Score: 0.9, IDs: []string{"img-a", "text-a", "img-c"}},
+				{Label: "dog", Score: 0.8, IDs: []string{"text-b"}},
+			},
+		},
+		{
+			name: "many weak matches don't outrank one strong one",
+			results: []vectordb.SearchResult{
+				searchResult("img-a", "forklift", 0.95), searchResult("img-b", "pallet", 0.6), searchResult("img-c", "pallet", 0.59), searchResult("img-d", "pallet", 0.58),
+			},
+			want: []LabelScore{
+				{Label: "forklift", Score: 0.95, IDs: []string{"img-a"}},
+				{Label: "pallet", Score: 0.6, IDs: []string{"img-b", "img-c", "img-d"}},
+			},
+		},
+		{
+			name: "vectors without a label are skipped",
+			results: []vectordb.SearchResult{
+				searchResult("img-a", "", 0.9), searchResult("img-b", "cat", 0.8), {ID: "img-c", Score: 0.7, Metadata: map[string]interface{}{"value": 3}},
+			},
+			want: []LabelScore{{Label: "cat", Score: 0.8, IDs: []string{"img-b"}}},
+		},
+		{
+			name:    "labels are case sensitive",
+			results: []vectordb.SearchResult{searchResult("img-a", "Cat", 0.9), searchResult("img-b", "cat", 0.8)},
+			want: []LabelScore{
+				{Label: "Cat", Score: 0.9, IDs: []string{"img-a"}},
+				{Label: "cat", Score: 0.8, IDs: []string{"img-b"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := RankLabels(test.results)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVectorKind(t *testing.T) {
+	tests := []struct {
+		id, kind, item string
+	}{
+		{"text-9f86d081", KIND_TEXT, "9f86d081"},
+		{"img-9f86d081", KIND_IMAGE, "9f86d081"},
+		{"9f86d081", "", "9f86d081"},
+		{"image-9f86d081", "", "image-9f86d081"},
+	}
+	for _, test := range tests {
+		if kind := VectorKind(test.id); kind != test.kind {
+			t.Errorf("%s: kind %q, want %q", test.id, kind, test.kind)
+		}
+		if item := ItemID(test.id); item != test.item {
+			t.Errorf("%s: item %q, want %q", test.id, item, test.item)
+		}
+	}
+	ids := VectorIDs("9f86d081")
+	if VectorKind(ids[0]) != KIND_TEXT || VectorKind(ids[1]) != KIND_IMAGE {
+		t.Errorf("unexpected vector IDs %v", ids)
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/vectordb"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MAX_QUERY_TERMS limits the embeddings a composite query needs
+const MAX_QUERY_TERMS = 10
+
+// QueryTerm is one part of a composite query, set exactly one of Text, Imagefile and ItemID
+type QueryTerm struct {
+	Text      string
+	Imagefile string
+	ItemID    string  /// a stored item, its image vector is used as is
+	Weight    float32 /// negative to move away from the term
+}
+
+func (t QueryTerm) String() string {
+	switch {
+	case t.Imagefile != "":
+		return fmt.Sprintf("%+g*image:%s", t.Weight, t.Imagefile)
+	case t.ItemID != "":
+		return fmt.Sprintf("%+g*item:%s", t.Weight, t.ItemID)
+	}
+	return fmt.Sprintf("%+g*'%s'", t.Weight, t.Text)
+}
+
+func (t QueryTerm) validate() error {
+	set := 0
+	for _, val := range []string{t.Text, t.Imagefile, t.ItemID} {
+		if val != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("a query term needs exactly one of a text, an image or an item")
+	}
+	if t.Weight == 0 || math.IsNaN(float64(t.Weight)) || math.IsInf(float64(t.Weight), 0) {
+		return fmt.Errorf("the weight of %s must be a non zero number", t)
+	}
+	return nil
+}
+
+// ParseQuery parses terms joined by + or -, each optionally weighted, e.g.
+//
+//	image:photo.jpg + 'at night' - 0.5*'people' + item:3f2a...
+//
+// A term is image:<file>, item:<stored item ID> or text, which is quoted if it contains + or -.
+// The sign is only read as an operator with a space before it, so x-ray is a single word.
+func ParseQuery(query string) ([]QueryTerm, error) {
+	terms := make([]QueryTerm, 0)
+	rest := strings.TrimSpace(query)
+	for rest != "" {
+		sign := float32(1)
+		if op := rest[0]; op == '+' || op == '-' {
+			if op == '-' {
+				sign = -1
+			}
+			rest = strings.TrimSpace(rest[1:])
+			if rest == "" || rest[0] == '+' || rest[0] == '-' {
+				return nil, fmt.Errorf("expected a term after %c in %s", op, query)
+			}
+		} else if len(terms) > 0 {
+			return nil, fmt.Errorf("expected + or - before %s", rest)
+		}
+		weight := float32(1)
+		if star := strings.Index(rest, "*"); star > 0 {
+			if w, err := strconv.ParseFloat(strings.TrimSpace(rest[:star]), 32); err == nil {
+				weight = float32(w)
+				rest = strings.TrimSpace(rest[star+1:])
+			}
+		}
+		var term string
+		if rest != "" && (rest[0] == '\'' || rest[0] == '"') {
+			end := strings.IndexByte(rest[1:], rest[0])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %s", rest)
+			}
+			term, rest = rest[1:end+1], strings.TrimSpace(rest[end+2:])
+			terms = append(terms, QueryTerm{Text: term, Weight: sign * weight})
+			continue
+		}
+		term, rest = nextTerm(rest)
+		switch {
+		case term == "":
+			return nil, fmt.Errorf("empty term in %s", query)
+		case strings.HasPrefix(term, "image:"):
+			terms = append(terms, QueryTerm{Imagefile: strings.TrimPrefix(term, "image:"), Weight: sign * weight})
+		case strings.HasPrefix(term, "item:"):
+			terms = append(terms, QueryTerm{ItemID: strings.TrimPrefix(term, "item:"), Weight: sign * weight})
+		default:
+			terms = append(terms, QueryTerm{Text: term, Weight: sign * weight})
+		}
+	}
+	return terms, nil
+}
+
+// nextTerm splits an unquoted term from the rest, at the next + or - with a space before it
+func nextTerm(s string) (term, rest string) {
+	for i := 1; i < len(s); i++ {
+		if (s[i] == '+' || s[i] == '-') && unicode.IsSpace(rune(s[i-1])) {
+			return strings.TrimSpace(s[:i]), s[i:]
+		}
+	}
+	return strings.TrimSpace(s), ""
+}
+
+// QueryVector embeds each term, scales it to unit length and by its weight, and returns their normalized sum
+func (h *Handler) QueryVector(terms []QueryTerm) ([]float32, error) {
+	if len(terms) == 0 || len(terms) > MAX_QUERY_TERMS {
+		return nil, fmt.Errorf("a query needs 1 to %d terms", MAX_QUERY_TERMS)
+	}
+	var sum []float64
+	for _, term := range terms {
+		if err := term.validate(); err != nil {
+			return nil, err
+		}
+		vector, err := h.termVector(term)
+		if err != nil {
+			return nil, err
+		}
+		if sum == nil {
+			sum = make([]float64, len(vector))
+		}
+		if len(vector) != len(sum) {
+			return nil, fmt.Errorf("%s has %d dimensions, the other terms %d", term, len(vector), len(sum))
+		}
+		norm := norm(vector)
+		if norm == 0 {
+			return nil, fmt.Errorf("%s has an empty embedding", term)
+		}
+		for i, val := range vector {
+			sum[i] += float64(term.Weight) * float64(val) / norm
+		}
+	}
+	total := 0.0
+	for _, val := range sum {
+		total += val * val
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("the terms of the query cancel out")
+	}
+	total = math.Sqrt(total)
+	query := make([]float32, len(sum))
+	for i, val := range sum {
+		query[i] = float32(val / total)
+	}
+	return query, nil
+}
+
+func (h *Handler) termVector(term QueryTerm) ([]float32, error) {
+	switch {
+	case term.Text != "":
+		return h.getTextEmbedding(term.Text)
+	case term.Imagefile != "":
+		return h.getEmbedding(term.Imagefile, "", embedding.OPMODE_IMAGE_EMBED)
+	}
+	id := imagePrefix + term.ItemID
+	vectors, err := h.pineconedb.FetchVectors([]string{id})
+	if err != nil {
+		return nil, err
+	}
+	vector, ok := vectors[id]
+	if !ok {
+		return nil, fmt.Errorf("no stored item %s", term.ItemID)
+	}
+	return vector.Values, nil
+}
+
+func norm(vector []float32) float64 {
+	total := 0.0
+	for _, val := range vector {
+		total += float64(val) * float64(val)
+	}
+	return math.Sqrt(total)
+}
+
+// Query searches every stored vector with the composite query, like Detect, rank the results with RankLabels
+func (h *Handler) Query(terms []QueryTerm, opts DetectOptions) ([]vectordb.SearchResult, error) {
+	vector, err := h.QueryVector(terms)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TopK == 0 {
+		opts.TopK = DEFAULT_TOPK
+	}
+	results, err := h.searchVector(vector, opts.TopK)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filter(results), nil
+}
+
+// QueryImages returns the stored images that best match the composite query, like SearchText
+func (h *Handler) QueryImages(terms []QueryTerm, filter map[string]interface{}, offset, limit int) (*SearchPage, error) {
+	vector, err := h.QueryVector(terms)
+	if err != nil {
+		return nil, err
+	}
+	return h.searchKind(vector, KIND_IMAGE, filter, offset, limit)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []QueryTerm
+		wantErr bool
+	}{
+		{"forklift", []QueryTerm{{Text: "forklift", Weight: 1}}, false},
+		{"red forklift", []QueryTerm{{Text: "red forklift", Weight: 1}}, false},
+		{"x-ray + co2", []QueryTerm{{Text: "x-ray", Weight: 1}, {Text: "co2", Weight: 1}}, false},
+		{
+			"image:photo.jpg + 'at night' - 0.5*'people' + item:3f2a",
+			[]QueryTerm{
+				{Imagefile: "photo.jpg", Weight: 1},
+				{Text: "at night", Weight: 1},
+				{Text: "people", Weight: -0.5},
+				{ItemID: "3f2a", Weight: 1},
+			},
+			false,
+		},
+		{`- 2 * "a + b" + 1.5*dog`, []QueryTerm{{Text: "a + b", Weight: -2}, {Text: "dog", Weight: 1.5}}, false},
+		{"-cat", []QueryTerm{{Text: "cat", Weight: -1}}, false},
+		{"-0.5*cat", []QueryTerm{{Text: "cat", Weight: -0.5}}, false},
+		{"a*b", []QueryTerm{{Text: "a*b", Weight: 1}}, false},
+		{"0*cat", []QueryTerm{{Text: "cat", Weight: 0}}, false}, /// rejected when embedded, see TestQueryVector
+		{"", []QueryTerm{}, false},
+		{"cat +", nil, true},
+		{"cat + - dog", nil, true},
+		{"'at night", nil, true},
+		{"'cat' dog", nil, true},
+		{"cat - ''", []QueryTerm{{Text: "cat", Weight: 1}, {Text: "", Weight: -1}}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseQuery(test.query)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.query, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v %v, want %v", test.query, got, err, test.want)
+		}
+	}
+}
+
+func TestQueryVector(t *testing.T) {
+	client := &textClient{vectors: map[string][]float64{
+		"cat":       {3, 0, 0},
+		"dog":       {0, 2, 0},
+		"night":     {0, 0, 10},
+		"cat again": {6, 0, 0},
+		"short":     {1, 1},
+		"nothing":   {0, 0, 0},
+	}}
+	tests := []struct {
+		name    string
+		terms   []QueryTerm
+		want    []float32
+		wantErr string
+	}{
+		{"one term is normalized", []QueryTerm{{Text: "cat", Weight: 1}}, []float32{1, 0, 0}, ""},
+		{"terms count equally whatever their length", []QueryTerm{{Text: "cat", Weight: 1}, {Text: "night", Weight: 1}},
+			[]float32{math.Sqrt2 / 2, 0, math.Sqrt2 / 2}, ""},
+		{"weighted", []QueryTerm{{Text: "cat", Weight: 3}, {Text: "dog", Weight: 4}}, []float32{0.6, 0.8, 0}, ""},
+		{"negative weight moves away", []QueryTerm{{Text: "cat", Weight: 1}, {Text: "dog", Weight: -1}},
+			[]float32{math.Sqrt2 / 2, -math.Sqrt2 / 2, 0}, ""},
+		{"only a negative term", []QueryTerm{{Text: "dog", Weight: -0.5}}, []float32{0, -1, 0}, ""},
+		{"cancel out", []QueryTerm{{Text: "cat", Weight: 1}, {Text: "cat again", Weight: -1}}, nil, "cancel out"},
+		{"zero weight", []QueryTerm{{Text: "cat", Weight: 0}}, nil, "non zero"},
+		{"NaN weight", []QueryTerm{{Text: "cat", Weight: float32(math.NaN())}}, nil, "non zero"},
+		{"two kinds in a term", []QueryTerm{{Text: "cat", Imagefile: "cat.jpg", Weight: 1}}, nil, "exactly one"},
+		{"empty term", []QueryTerm{{Weight: 1}}, nil, "exactly one"},
+		{"dimensions differ", []QueryTerm{{Text: "cat", Weight: 1}, {Text: "short", Weight: 1}}, nil, "2 dimensions, the other terms 3"},
+		{"empty embedding", []QueryTerm{{Text: "nothing", Weight: 1}}, nil, "empty embedding"},
+		{"embedding fails", []QueryTerm{{Text: "unknown", Weight: 1}}, nil, "no vector"},
+		{"no terms", nil, nil, "1 to 10 terms"},
+		{"too many terms", make([]QueryTerm, MAX_QUERY_TERMS+1), nil, "1 to 10 terms"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NewHandler(client, nil).QueryVector(test.terms)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v %v", test.wantErr, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if math.Abs(float64(got[i]-test.want[i])) > 1e-6 {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"object-detection-zero-shot/vectordb"
+	"path/filepath"
+	"strings"
+)
+
+// StoredItems lists the items embedded in the handler's namespace, rebuilt from the metadata of their image vectors,
+// so that they can be embedded again. Items stored before the image file was recorded are looked for in imageDir
+// as <ID>.<ext>, the way the upload endpoint saves them. Items with no image found have an empty Imagefile.
+func (h *Handler) StoredItems(imageDir string) ([]Item, error) {
+	items := make([]Item, 0)
+	token := ""
+	for {
+		ids, next, err := h.pineconedb.ListIDs(imagePrefix, 100, token)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 {
+			vectors, err := h.pineconedb.FetchVectors(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				vector, ok := vectors[id]
+				if !ok {
+					continue
+				}
+				items = append(items, storedItem(vector.ID, vector.Metadata, imageDir))
+			}
+		}
+		if next == "" {
+			return items, nil
+		}
+		token = next
+	}
+}
+
+// StoredIDs returns the IDs of the items with an image vector in the handler's namespace
+func (h *Handler) StoredIDs() (map[string]bool, error) {
+	ids := make(map[string]bool)
+	token := ""
+	for {
+		page, next, err := h.pineconedb.ListIDs(imagePrefix, 100, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range page {
+			ids[strings.TrimPrefix(id, imagePrefix)] = true
+		}
+		if next == "" {
+			return ids, nil
+		}
+		token = next
+	}
+}
+
+func storedItem(vectorID string, metadata map[string]interface{}, imageDir string) Item {
+	item := Item{
+		ID:       strings.TrimPrefix(vectorID, imagePrefix),
+		Metadata: make(map[string]interface{}),
+	}
+	item.Label, _ = metadata[METADATA_VALUE].(string)
+	item.Imagefile, _ = metadata[METADATA_IMAGE_FILE].(string)
+	for key, val := range metadata {
+		switch key {
+		case METADATA_VALUE, METADATA_KIND, METADATA_MODEL_ID, METADATA_DIMENSION, METADATA_PREPROCESS:
+			/// value is set from the label, kind per vector, the version from the new model
+		default:
+			item.Metadata[key] = val
+		}
+	}
+	if item.Imagefile == "" && imageDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(imageDir, item.ID+".*"))
+		if len(matches) > 0 {
+			item.Imagefile = matches[0]
+		}
+	}
+	return item
+}
+
+// ReindexCheck compares a reindexed namespace with the namespace it was rebuilt from
+type ReindexCheck struct {
+	SourceCount    uint32
+	TargetCount    uint32
+	Sampled        int
+	SelfMatches    int     /// sampled items whose label is in the top 5 labels when searching the target with their new image vector, leaving out the image itself
+	MeanSimilarity float64 /// cosine similarity of the old and new image vectors, only if the dimensions are the same
+	Compared       int     /// sampled items the similarity was computed for
+}
+
+// MatchRate is the fraction of sampled items that found their own label
+func (c *ReindexCheck) MatchRate() float64 {
+	if c.Sampled == 0 {
+		return 0
+	}
+	return float64(c.SelfMatches) / float64(c.Sampled)
+}
+
+// OK is true if the target holds at least as many vectors as the source and enough samples found their own label
+func (c *ReindexCheck) OK(minMatchRate float64) bool {
+	return c.TargetCount >= c.SourceCount && c.MatchRate() >= minMatchRate
+}
+
+func (c *ReindexCheck) String() string {
+	summary := fmt.Sprintf("Vectors: %d in the source, %d in the target. %d of %d sampled items found their own label (%.3f).",
+		c.SourceCount, c.TargetCount, c.SelfMatches, c.Sampled, c.MatchRate())
+	if c.Compared > 0 {
+		summary += fmt.Sprintf(" Mean similarity of old and new image vectors %.3f over %d items.", c.MeanSimilarity, c.Compared)
+	}
+	return summary
+}
+
+// VerifyReindex compares the counts of the source and target namespaces, and searches the target with the
+// image vectors of up to sample of the items
+func VerifyReindex(source, target *Handler, items []Item, sample int, seed int64) (*ReindexCheck, error) {
+	check := &ReindexCheck{}
+	var err error
+	check.SourceCount, err = source.pineconedb.VectorCount()
+	if err != nil {
+		return nil, err
+	}
+	check.TargetCount, err = target.pineconedb.VectorCount()
+	if err != nil {
+		return nil, err
+	}
+
+	sampled := make([]Item, len(items))
+	copy(sampled, items)
+	rand.New(rand.NewSource(seed)).Shuffle(len(sampled), func(i, j int) {
+		sampled[i], sampled[j] = sampled[j], sampled[i]
+	})
+	if len(sampled) > sample {
+		sampled = sampled[:sample]
+	}
+	if len(sampled) == 0 {
+		return check, nil
+	}
+	ids := make([]string, 0, len(sampled))
+	for _, item := range sampled {
+		ids = append(ids, imagePrefix+item.ID)
+	}
+	newvectors, err := target.pineconedb.FetchVectors(ids)
+	if err != nil {
+		return nil, err
+	}
+	oldvectors, err := source.pineconedb.FetchVectors(ids)
+	if err != nil {
+		return nil, err
+	}
+	similarity := 0.0
+	for _, item := range sampled {
+		check.Sampled++
+		newvector, ok := newvectors[imagePrefix+item.ID]
+		if !ok {
+			continue
+		}
+		results, err := target.pineconedb.SearchVectorsFiltered(newvector.Values, 21, target.versionFilter())
+		if err != nil {
+			return nil, err
+		}
+		/// Leave out the image itself, its label should still be found from its text vector or similar images
+		others := make([]vectordb.SearchResult, 0, len(results))
+		for _, result := range results {
+			if result.ID != newvector.ID {
+				others = append(others, result)
+			}
+		}
+		labels := RankLabels(others)
+		for i := 0; i < len(labels) && i < 5; i++ {
+			if labels[i].Label == item.Label {
+				check.SelfMatches++
+				break
+			}
+		}
+		if oldvector, ok := oldvectors[imagePrefix+item.ID]; ok && len(oldvector.Values) == len(newvector.Values) {
+			similarity += cosine(oldvector.Values, newvector.Values)
+			check.Compared++
+		}
+	}
+	if check.Compared > 0 {
+		check.MeanSimilarity = similarity / float64(check.Compared)
+	}
+	return check, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStoredItem(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "abc.png"), []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		imageDir string
+		want     Item
+	}{
+		{
+			name: "version and kind are dropped",
+			metadata: map[string]interface{}{METADATA_VALUE: "cat", METADATA_KIND: KIND_IMAGE, METADATA_MODEL_ID: "clip",
+				METADATA_DIMENSION: 512.0, METADATA_PREPROCESS: "1", METADATA_OBJECT_KEY: "indexed/abc.png", "colour": "red"},
+			want: Item{ID: "abc", Label: "cat", Metadata: map[string]interface{}{METADATA_OBJECT_KEY: "indexed/abc.png", "colour": "red"}},
+		},
+		{
+			name:     "recorded image file",
+			metadata: map[string]interface{}{METADATA_VALUE: "cat", METADATA_IMAGE_FILE: "/data/cat.jpg"},
+			imageDir: dir,
+			want:     Item{ID: "abc", Label: "cat", Imagefile: "/data/cat.jpg", Metadata: map[string]interface{}{METADATA_IMAGE_FILE: "/data/cat.jpg"}},
+		},
+		{
+			name:     "old upload found in the image dir",
+			metadata: map[string]interface{}{METADATA_VALUE: "cat"},
+			imageDir: dir,
+			want:     Item{ID: "abc", Label: "cat", Imagefile: filepath.Join(dir, "abc.png"), Metadata: map[string]interface{}{}},
+		},
+		{
+			name:     "no image",
+			metadata: map[string]interface{}{METADATA_VALUE: "cat"},
+			want:     Item{ID: "abc", Label: "cat", Metadata: map[string]interface{}{}},
+		},
+	}
+	for _, test := range tests {
+		if got := storedItem(imagePrefix+"abc", test.metadata, test.imageDir); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReindexCheckOK(t *testing.T) {
+	tests := []struct {
+		name  string
+		check ReindexCheck
+		want  bool
+	}{
+		{"everything found", ReindexCheck{SourceCount: 10, TargetCount: 10, Sampled: 5, SelfMatches: 5}, true},
+		{"more in the target", ReindexCheck{SourceCount: 10, TargetCount: 12, Sampled: 5, SelfMatches: 5}, true},
+		{"vectors missing", ReindexCheck{SourceCount: 10, TargetCount: 9, Sampled: 5, SelfMatches: 5}, false},
+		{"at the match rate", ReindexCheck{SourceCount: 10, TargetCount: 10, Sampled: 10, SelfMatches: 9}, true},
+		{"under the match rate", ReindexCheck{SourceCount: 10, TargetCount: 10, Sampled: 10, SelfMatches: 8}, false},
+		{"nothing sampled", ReindexCheck{}, false},
+	}
+	for _, test := range tests {
+		if got := test.check.OK(0.9); got != test.want {
+			t.Errorf("%s: OK is %v with match rate %.2f", test.name, got, test.check.MatchRate())
+		}
+	}
+}
+
+// angled returns a unit vector at the angle in degrees
+func angled(degrees float64) []float32 {
+	radians := degrees * math.Pi / 180
+	return []float32{float32(math.Cos(radians)), float32(math.Sin(radians))}
+}
+
+func TestVerifyReindex(t *testing.T) {
+	version := map[string]interface{}{METADATA_MODEL_ID: "clip", METADATA_PREPROCESS: PreprocessVersion}
+	upsert := func(db *vectordb.MemoryDB, id, label string, text, image float64, metadata map[string]interface{}) {
+		for prefix, degrees := range map[string]float64{textPrefix: text, imagePrefix: image} {
+			withLabel := map[string]interface{}{METADATA_VALUE: label}
+			for key, val := range metadata {
+				withLabel[key] = val
+			}
+			if err := db.UpsertVector(angled(degrees), prefix+id, withLabel); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	/// Seven labels 30 degrees apart, each with an item whose image is at its label
+	source, target := vectordb.NewMemoryDB(), vectordb.NewMemoryDB()
+	items := make([]Item, 0)
+	for i := 0; i < 7; i++ {
+		id := fmt.Sprintf("l%d", i)
+		upsert(source, id, id, float64(30*i), float64(30*i), nil)
+		upsert(target, id, id, float64(30*i), float64(30*i), version)
+		items = append(items, Item{ID: id, Label: id})
+	}
+	/// The new model puts x's image opposite its label, so five other labels are nearer
+	upsert(source, "x", "l0", 0, 0, nil)
+	upsert(target, "x", "l0", 0, 180, version)
+	items = append(items, Item{ID: "x", Label: "l0"})
+	/// An unversioned vector next to x would find its label, but the target only searches its own version
+	if err := target.UpsertVector(angled(180), imagePrefix+"stray", map[string]interface{}{METADATA_VALUE: "l0"}); err != nil {
+		t.Fatal(err)
+	}
+	/// Failed to reindex
+	upsert(source, "gone", "l3", 90, 90, nil)
+	items = append(items, Item{ID: "gone", Label: "l3"})
+
+	sourceHandler := NewHandler(nil, source)
+	targetHandler := NewHandler(nil, target)
+	if err := targetHandler.SetVersion(ModelVersion{ModelID: "clip", Preprocess: PreprocessVersion}, VERSION_POLICY_FILTER); err != nil {
+		t.Fatal(err)
+	}
+	check, err := VerifyReindex(sourceHandler, targetHandler, items, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.SourceCount != 18 || check.TargetCount != 17 || check.Sampled != 9 || check.SelfMatches != 7 || check.Compared != 8 {
+		t.Errorf("unexpected check %+v", check)
+	}
+	/// Seven images are unchanged and x's is opposite
+	if math.Abs(check.MeanSimilarity-0.75) > 1e-6 {
+		t.Errorf("mean similarity %f, want 0.75", check.MeanSimilarity)
+	}
+	if check.OK(0.5) {
+		t.Error("expected the check to fail with vectors missing from the target")
+	}
+
+	check, err = VerifyReindex(sourceHandler, targetHandler, items, 3, 1)
+	if err != nil || check.Sampled != 3 {
+		t.Errorf("expected 3 sampled items, got %+v %v", check, err)
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"fmt"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/vectordb"
+	"strings"
+)
+
+// MAX_SEARCH_RESULTS is the most results a search can page through, the most Pinecone returns with metadata
+const MAX_SEARCH_RESULTS = 1000
+
+// SearchPage is one page of search results, best first
+type SearchPage struct {
+	Results []vectordb.SearchResult
+	Offset  int
+	More    bool /// there are results after this page
+}
+
+// SimilarImages returns the stored images nearest to the image, skipping the first offset results
+func (h *Handler) SimilarImages(imagefile string, offset, limit int) (*SearchPage, error) {
+	vector, err := h.getEmbedding(imagefile, "", embedding.OPMODE_IMAGE_EMBED)
+	if err != nil {
+		return nil, err
+	}
+	return h.searchKind(vector, KIND_IMAGE, nil, offset, limit)
+}
+
+// SearchText returns the stored images that best match the text, skipping the first offset results.
+// filter restricts the search by metadata, see ParseFilter.
+func (h *Handler) SearchText(text string, filter map[string]interface{}, offset, limit int) (*SearchPage, error) {
+	vector, err := h.getTextEmbedding(text)
+	if err != nil {
+		return nil, err
+	}
+	return h.searchKind(vector, KIND_IMAGE, filter, offset, limit)
+}
+
+// ParseFilter turns key:value conditions into a metadata filter that matches items with every value.
+// The key label is the item's label. It returns nil if there are no conditions.
+func ParseFilter(conditions []string) (map[string]interface{}, error) {
+	matches := make([]interface{}, 0, len(conditions))
+	for _, condition := range conditions {
+		key, val, ok := strings.Cut(condition, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.HasPrefix(key, "$") {
+			return nil, fmt.Errorf("invalid filter %s, use key:value", condition)
+		}
+		if key == "label" {
+			key = METADATA_VALUE
+		}
+		matches = append(matches, map[string]interface{}{key: map[string]interface{}{"$eq": strings.TrimSpace(val)}})
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	return map[string]interface{}{"$and": matches}, nil
+}
+
+// searchKind searches the vectors of one kind that also match the filter, which may be nil.
+// Pinecone has no offset, so the results up to the page are fetched and the ones before it dropped, see pageKind.
+func (h *Handler) searchKind(vector []float32, kind string, filter map[string]interface{}, offset, limit int) (*SearchPage, error) {
+	if offset < 0 || limit < 1 || offset+limit > MAX_SEARCH_RESULTS {
+		return nil, fmt.Errorf("can only page through the best %d results", MAX_SEARCH_RESULTS)
+	}
+	/// Vectors upserted before the kind was recorded don't have it, they are told apart by their ID below
+	conditions := []interface{}{
+		map[string]interface{}{
+			"$or": []interface{}{
+				map[string]interface{}{METADATA_KIND: map[string]interface{}{"$eq": kind}},
+				map[string]interface{}{METADATA_KIND: map[string]interface{}{"$exists": false}},
+			},
+		},
+	}
+	for _, f := range []map[string]interface{}{h.versionFilter(), filter} {
+		if f != nil {
+			conditions = append(conditions, f)
+		}
+	}
+	return pageKind(func(topK uint32) ([]vectordb.SearchResult, error) {
+		results, err := h.pineconedb.SearchVectorsFiltered(vector, topK, map[string]interface{}{"$and": conditions})
+		if err != nil {
+			return nil, err
+		}
+		return results, h.checkResultVersions(results)
+	}, kind, offset, limit)
+}
+
+// pageKind returns a page of the search results of one kind. Vectors without a recorded kind match either kind
+// in the filter and are dropped here, so the search is repeated with a larger topK until it finds one more match
+// than the page, to tell whether there is a next one, or runs out of results.
+func pageKind(search func(topK uint32) ([]vectordb.SearchResult, error), kind string, offset, limit int) (*SearchPage, error) {
+	want := offset + limit + 1
+	topK := min(want, MAX_SEARCH_RESULTS)
+	matches := make([]vectordb.SearchResult, 0, want)
+	for {
+		results, err := search(uint32(topK))
+		if err != nil {
+			return nil, err
+		}
+		matches = matches[:0]
+		for _, result := range results {
+			if VectorKind(result.ID) == kind {
+				matches = append(matches, result)
+			}
+		}
+		if len(matches) >= want || len(results) < topK || topK == MAX_SEARCH_RESULTS {
+			break
+		}
+		/// Assume the same share of the next results are of the kind, at least doubling
+		topK = min(max(2*topK, topK*want/max(len(matches), 1)), MAX_SEARCH_RESULTS)
+	}
+	page := &SearchPage{Offset: offset, Results: make([]vectordb.SearchResult, 0, limit)}
+	if offset < len(matches) {
+		page.Results = matches[offset:min(len(matches), offset+limit)]
+	}
+	page.More = len(matches) > offset+limit
+	return page, nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"testing"
+)
+
+// textClient embeds each candidate text as its vector in the map, and records the candidates it was sent
+type textClient struct {
+	vectors    map[string][]float64
+	candidates [][]string
+}
+
+func (c *textClient) Do(payload *embedding.RequestPayload) (map[string]interface{}, error) {
+	c.candidates = append(c.candidates, payload.Inputs.Candidates)
+	rows := make([]any, 0, len(payload.Inputs.Candidates))
+	for _, candidate := range payload.Inputs.Candidates {
+		vector, ok := c.vectors[candidate]
+		if !ok {
+			return nil, fmt.Errorf("no vector for %q", candidate)
+		}
+		row := make([]any, 0, len(vector))
+		for _, val := range vector {
+			row = append(row, val)
+		}
+		rows = append(rows, row)
+	}
+	return map[string]interface{}{"embeddings": rows}, nil
+}
+
+// rankedVectors returns n search results best first, where every kinds[i%len(kinds)] vector is of that kind
+func rankedVectors(n int, kinds ...string) []vectordb.SearchResult {
+	results := make([]vectordb.SearchResult, 0, n)
+	for i := 0; i < n; i++ {
+		prefix := imagePrefix
+		if kinds[i%len(kinds)] == KIND_TEXT {
+			prefix = textPrefix
+		}
+		results = append(results, vectordb.SearchResult{ID: fmt.Sprintf("%s%d", prefix, i), Score: 1 - float32(i)/float32(n)})
+	}
+	return results
+}
+
+func TestPageKind(t *testing.T) {
+	tests := []struct {
+		name          string
+		stored        []vectordb.SearchResult
+		offset, limit int
+		wantFirst     string /// ID of the first result on the page
+		wantLen       int
+		wantMore      bool
+		wantSearches  int
+	}{
+		{"kinds recorded", rankedVectors(100, KIND_IMAGE), 0, 10, "img-0", 10, true, 1},
+		{"last page", rankedVectors(25, KIND_IMAGE), 20, 10, "img-20", 5, false, 1},
+		{"exactly a page", rankedVectors(10, KIND_IMAGE), 0, 10, "img-0", 10, false, 1},
+		{"legacy text vectors in between", rankedVectors(100, KIND_TEXT, KIND_IMAGE), 0, 10, "img-1", 10, true, 2},
+		{"second page of legacy vectors", rankedVectors(100, KIND_TEXT, KIND_IMAGE), 10, 10, "img-21", 10, true, 2},
+		{"mostly text", rankedVectors(500, KIND_TEXT, KIND_TEXT, KIND_TEXT, KIND_TEXT, KIND_IMAGE), 0, 20, "img-4", 20, true, 2},
+		{"runs out", rankedVectors(30, KIND_TEXT, KIND_IMAGE), 10, 10, "img-21", 5, false, 2},
+		{"past the end", rankedVectors(30, KIND_TEXT, KIND_IMAGE), 20, 10, "", 0, false, 1},
+		{"nothing of the kind", rankedVectors(5000, KIND_TEXT), 0, 10, "", 0, false, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			searches := 0
+			lastTopK := uint32(0)
+			search := func(topK uint32) ([]vectordb.SearchResult, error) {
+				searches++
+				if topK <= lastTopK || topK > MAX_SEARCH_RESULTS {
+					t.Errorf("searched topK %d after %d", topK, lastTopK)
+				}
+				lastTopK = topK
+				return test.stored[:min(int(topK), len(test.stored))], nil
+			}
+			page, err := pageKind(search, KIND_IMAGE, test.offset, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Results) != test.wantLen || page.More != test.wantMore || page.Offset != test.offset {
+				t.Errorf("got %d results, more %t, offset %d, want %d, more %t", len(page.Results), page.More, page.Offset, test.wantLen, test.wantMore)
+			}
+			if test.wantLen > 0 && page.Results[0].ID != test.wantFirst {
+				t.Errorf("page starts with %s, want %s", page.Results[0].ID, test.wantFirst)
+			}
+			for _, result := range page.Results {
+				if VectorKind(result.ID) != KIND_IMAGE {
+					t.Errorf("got a %s vector %s", VectorKind(result.ID), result.ID)
+				}
+			}
+			if searches != test.wantSearches {
+				t.Errorf("searched %d times, want %d", searches, test.wantSearches)
+			}
+		})
+	}
+
+	failed := errors.New("unavailable")
+	_, err := pageKind(func(uint32) ([]vectordb.SearchResult, error) { return nil, failed }, KIND_IMAGE, 0, 10)
+	if !errors.Is(err, failed) {
+		t.Errorf("expected the search error, got %v", err)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	eq := func(key, val string) map[string]interface{} {
+		return map[string]interface{}{key: map[string]interface{}{"$eq": val}}
+	}
+	tests := []struct {
+		conditions []string
+		want       map[string]interface{}
+		wantErr    bool
+	}{
+		{nil, nil, false},
+		{[]string{"label:forklift"}, map[string]interface{}{"$and": []interface{}{eq("value", "forklift")}}, false},
+		{[]string{" label : red forklift ", "original_filename:dock.jpg"},
+			map[string]interface{}{"$and": []interface{}{eq("value", "red forklift"), eq("original_filename", "dock.jpg")}}, false},
+		{[]string{"camera:dock:2"}, map[string]interface{}{"$and": []interface{}{eq("camera", "dock:2")}}, false},
+		{[]string{"label:"}, map[string]interface{}{"$and": []interface{}{eq("value", "")}}, false},
+		{[]string{"forklift"}, nil, true},
+		{[]string{":forklift"}, nil, true},
+		{[]string{"$or:forklift"}, nil, true},
+		{[]string{"label:cat", "dog"}, nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseFilter(test.conditions)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.conditions, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v %v, want %v", test.conditions, got, err, test.want)
+		}
+	}
+}
+
+func TestTextEmbeddingIsNotSplit(t *testing.T) {
+	client := &textClient{vectors: map[string][]float64{
+		"red, white forklift": {1, 0},
+		"red":                 {0, 1},
+	}}
+	h := NewHandler(client, nil)
+	vector, err := h.getTextEmbedding(" red, white forklift ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vector, []float32{1, 0}) {
+		t.Errorf("got %v", vector)
+	}
+	vector, err = h.termVector(QueryTerm{Text: "red, white forklift", Weight: 1})
+	if err != nil || !reflect.DeepEqual(vector, []float32{1, 0}) {
+		t.Errorf("query term got %v %v", vector, err)
+	}
+	want := [][]string{{"red, white forklift"}, {"red, white forklift"}}
+	if !reflect.DeepEqual(client.candidates, want) {
+		t.Errorf("sent %q, want %q", client.candidates, want)
+	}
+	if _, err = h.getTextEmbedding("  "); err == nil {
+		t.Error("expected empty text to be rejected")
+	}
+}
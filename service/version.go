@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/vectordb"
+)
+
+// PreprocessVersion changes whenever the way images or labels are prepared before embedding changes,
+// i.e. embeddings from different versions can't be compared even with the same model
+const PreprocessVersion = "1"
+
+const (
+	METADATA_MODEL_ID   = "model_id"
+	METADATA_DIMENSION  = "dimension"
+	METADATA_PREPROCESS = "preprocess"
+)
+
+type VersionPolicy string
+
+const (
+	VERSION_POLICY_OFF           VersionPolicy = "off"           /// record the version but search everything
+	VERSION_POLICY_FILTER        VersionPolicy = "filter"        /// only search vectors with the active version
+	VERSION_POLICY_FILTER_LEGACY VersionPolicy = "filter-legacy" /// filter, but take vectors with no version to be of the active one
+	VERSION_POLICY_REFUSE        VersionPolicy = "refuse"        /// fail a search that finds vectors of another version
+)
+
+var ErrVersionMismatch = errors.New("index holds vectors from a different model or preprocessing version")
+
+// ModelVersion identifies the embedding space a vector belongs to
+type ModelVersion struct {
+	ModelID    string
+	Preprocess string
+}
+
+func (v ModelVersion) String() string {
+	return v.ModelID + " (preprocess " + v.Preprocess + ")"
+}
+
+// SetVersion records the version in the metadata of every vector upserted from now on,
+// and applies the policy to searches
+func (h *Handler) SetVersion(version ModelVersion, policy VersionPolicy) error {
+	switch policy {
+	case VERSION_POLICY_OFF, VERSION_POLICY_FILTER, VERSION_POLICY_FILTER_LEGACY, VERSION_POLICY_REFUSE:
+	default:
+		return fmt.Errorf("unknown version policy %s", policy)
+	}
+	h.version = &version
+	h.versionPolicy = policy
+	return nil
+}
+
+// versionMetadata is added to the metadata of upserted vectors
+func (h *Handler) versionMetadata(metadata map[string]interface{}, dimension int) {
+	if h.version == nil {
+		return
+	}
+	metadata[METADATA_MODEL_ID] = h.version.ModelID
+	metadata[METADATA_PREPROCESS] = h.version.Preprocess
+	metadata[METADATA_DIMENSION] = dimension
+}
+
+// versionFilter restricts a search to the vectors sameVersion accepts, or returns nil if the policy doesn't filter
+func (h *Handler) versionFilter() map[string]interface{} {
+	if h.version == nil || (h.versionPolicy != VERSION_POLICY_FILTER && h.versionPolicy != VERSION_POLICY_FILTER_LEGACY) {
+		return nil
+	}
+	active := map[string]interface{}{
+		METADATA_MODEL_ID:   map[string]interface{}{"$eq": h.version.ModelID},
+		METADATA_PREPROCESS: map[string]interface{}{"$eq": h.version.Preprocess},
+	}
+	if h.versionPolicy == VERSION_POLICY_FILTER {
+		return active
+	}
+	return map[string]interface{}{
+		"$or": []interface{}{
+			active,
+			map[string]interface{}{METADATA_MODEL_ID: map[string]interface{}{"$exists": false}},
+		},
+	}
+}
+
+// checkResultVersions enforces the refuse policy on search results
+func (h *Handler) checkResultVersions(results []vectordb.SearchResult) error {
+	if h.version == nil || h.versionPolicy != VERSION_POLICY_REFUSE {
+		return nil
+	}
+	for _, result := range results {
+		if !h.sameVersion(result.Metadata) {
+			return fmt.Errorf("%w: %s", ErrVersionMismatch, result.ID)
+		}
+	}
+	return nil
+}
+
+// sameVersion is true if the vector is of the active version. Vectors upserted before versions were recorded
+// have no model_id, they are only taken to be of the active version with the filter-legacy policy.
+func (h *Handler) sameVersion(metadata map[string]interface{}) bool {
+	if _, versioned := metadata[METADATA_MODEL_ID]; !versioned {
+		return h.versionPolicy == VERSION_POLICY_FILTER_LEGACY
+	}
+	return metadata[METADATA_MODEL_ID] == h.version.ModelID && metadata[METADATA_PREPROCESS] == h.version.Preprocess
+}
+
+// VersionCheck compares the index with the active embedder
+type VersionCheck struct {
+	Active            ModelVersion
+	IndexDimension    uint32
+	EmbedderDimension int
+	Sampled           int
+	Mismatched        int            /// sampled vectors of another version, see sameVersion
+	Versions          map[string]int /// version of each sampled vector -> count
+}
+
+// OK is true if the dimensions agree and every sampled vector has the active version
+func (c *VersionCheck) OK() bool {
+	return (c.IndexDimension == 0 || int(c.IndexDimension) == c.EmbedderDimension) && c.Mismatched == 0
+}
+
+func (c *VersionCheck) String() string {
+	summary := fmt.Sprintf("Active model %s, embedding dimension %d, index dimension %d. %d of %d sampled vectors have a different version.",
+		c.Active.String(), c.EmbedderDimension, c.IndexDimension, c.Mismatched, c.Sampled)
+	for version, count := range c.Versions {
+		summary += fmt.Sprintf("\n    %d x %s", count, version)
+	}
+	return summary
+}
+
+// CheckVersion embeds a probe label to find the active embedder's dimension, and compares it and the
+// versions of up to sample stored vectors with the active version
+func (h *Handler) CheckVersion(sample uint32) (*VersionCheck, error) {
+	if h.version == nil {
+		return nil, fmt.Errorf("no active version set")
+	}
+	check := &VersionCheck{
+		Active:   *h.version,
+		Versions: make(map[string]int),
+	}
+	probe, err := h.getEmbedding("", "object", embedding.OPMODE_TEXT_EMBED)
+	if err != nil {
+		return nil, err
+	}
+	check.EmbedderDimension = len(probe)
+	check.IndexDimension, err = h.pineconedb.IndexDimension()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, _, err := h.pineconedb.ListIDs("", sample, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return check, nil
+	}
+	vectors, err := h.pineconedb.FetchVectors(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, vector := range vectors {
+		check.Sampled++
+		version := "no version recorded"
+		if modelID, ok := vector.Metadata[METADATA_MODEL_ID].(string); ok {
+			version = ModelVersion{ModelID: modelID, Preprocess: fmt.Sprint(vector.Metadata[METADATA_PREPROCESS])}.String()
+		}
+		check.Versions[version]++
+		if !h.sameVersion(vector.Metadata) {
+			check.Mismatched++
+		}
+	}
+	return check, nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"testing"
+)
+
+func TestVersionFilter(t *testing.T) {
+	version := ModelVersion{ModelID: "clip", Preprocess: PreprocessVersion}
+	active := map[string]interface{}{
+		METADATA_MODEL_ID:   map[string]interface{}{"$eq": "clip"},
+		METADATA_PREPROCESS: map[string]interface{}{"$eq": PreprocessVersion},
+	}
+	legacy := map[string]interface{}{
+		"$or": []interface{}{
+			active,
+			map[string]interface{}{METADATA_MODEL_ID: map[string]interface{}{"$exists": false}},
+		},
+	}
+	tests := []struct {
+		policy VersionPolicy
+		want   map[string]interface{}
+	}{
+		{VERSION_POLICY_FILTER, active},
+		{VERSION_POLICY_FILTER_LEGACY, legacy},
+		{VERSION_POLICY_REFUSE, nil},
+		{VERSION_POLICY_OFF, nil},
+	}
+	for _, test := range tests {
+		h := NewHandler(nil, nil)
+		if err := h.SetVersion(version, test.policy); err != nil {
+			t.Fatal(err)
+		}
+		if got := h.versionFilter(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.policy, got, test.want)
+		}
+	}
+	if NewHandler(nil, nil).SetVersion(version, "strict") == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestCheckResultVersions(t *testing.T) {
+	version := ModelVersion{ModelID: "clip", Preprocess: PreprocessVersion}
+	current := map[string]interface{}{METADATA_MODEL_ID: "clip", METADATA_PREPROCESS: PreprocessVersion}
+	tests := []struct {
+		name     string
+		policy   VersionPolicy
+		metadata map[string]interface{}
+		wantErr  bool
+	}{
+		{"current", VERSION_POLICY_REFUSE, current, false},
+		{"other model", VERSION_POLICY_REFUSE, map[string]interface{}{METADATA_MODEL_ID: "siglip", METADATA_PREPROCESS: PreprocessVersion}, true},
+		{"other preprocess", VERSION_POLICY_REFUSE, map[string]interface{}{METADATA_MODEL_ID: "clip", METADATA_PREPROCESS: "0"}, true},
+		{"no version", VERSION_POLICY_REFUSE, map[string]interface{}{"value": "cat"}, true},
+		{"filtered by the search", VERSION_POLICY_FILTER, map[string]interface{}{METADATA_MODEL_ID: "siglip"}, false},
+		{"off", VERSION_POLICY_OFF, map[string]interface{}{METADATA_MODEL_ID: "siglip"}, false},
+	}
+	for _, test := range tests {
+		h := NewHandler(nil, nil)
+		if err := h.SetVersion(version, test.policy); err != nil {
+			t.Fatal(err)
+		}
+		err := h.checkResultVersions([]vectordb.SearchResult{{ID: "img-1", Metadata: test.metadata}})
+		if test.wantErr != errors.Is(err, ErrVersionMismatch) {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+// TestSameVersion checks every policy agrees with its search filter on which vectors are of the active version
+func TestSameVersion(t *testing.T) {
+	version := ModelVersion{ModelID: "clip", Preprocess: PreprocessVersion}
+	current := map[string]interface{}{METADATA_MODEL_ID: "clip", METADATA_PREPROCESS: PreprocessVersion}
+	other := map[string]interface{}{METADATA_MODEL_ID: "siglip", METADATA_PREPROCESS: PreprocessVersion}
+	unversioned := map[string]interface{}{METADATA_VALUE: "cat"}
+	tests := []struct {
+		policy          VersionPolicy
+		wantUnversioned bool
+	}{
+		{VERSION_POLICY_FILTER, false},
+		{VERSION_POLICY_FILTER_LEGACY, true},
+		{VERSION_POLICY_REFUSE, false},
+		{VERSION_POLICY_OFF, false},
+	}
+	for _, test := range tests {
+		h := NewHandler(nil, nil)
+		if err := h.SetVersion(version, test.policy); err != nil {
+			t.Fatal(err)
+		}
+		if !h.sameVersion(current) || h.sameVersion(other) {
+			t.Errorf("%s: expected only the current version to match", test.policy)
+		}
+		if got := h.sameVersion(unversioned); got != test.wantUnversioned {
+			t.Errorf("%s: unversioned vectors match %v, want %v", test.policy, got, test.wantUnversioned)
+		}
+		/// The search filter lets the unversioned vectors through exactly when sameVersion accepts them
+		filter := h.versionFilter()
+		if filter == nil {
+			continue
+		}
+		_, hasOr := filter["$or"]
+		if hasOr != test.wantUnversioned {
+			t.Errorf("%s: filter %v disagrees with sameVersion on unversioned vectors", test.policy, filter)
+		}
+	}
+}
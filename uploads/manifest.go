@@ -0,0 +1,218 @@
+package uploads
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/service"
+	"sort"
+	"time"
+)
+
+// Each entry is stored as its own object, so replicas sharing the blob store can record uploads at once
+const MANIFEST_PREFIX = "manifest/"
+
+// LEGACY_MANIFEST is the single JSON lines file entries were appended to before, it is still read
+const LEGACY_MANIFEST = "uploads.jsonl"
+
+// METADATA_ORIGINAL_FILENAME is the vector metadata key for the client's file name, which is kept only for reference
+const METADATA_ORIGINAL_FILENAME = "original_filename"
+
+// Entry links an uploaded file to the vectors embedded from it
+type Entry struct {
+	ID               string    `json:"id"`
+	File             string    `json:"file"` /// the object key
+	OriginalFilename string    `json:"original_filename,omitempty"`
+	Label            string    `json:"label"`
+	VectorIDs        []string  `json:"vector_ids"`
+	Created          time.Time `json:"created"`
+	Deleted          bool      `json:"deleted,omitempty"` /// replaces the entries of a deleted upload, see Manifest.Delete
+	Expired          bool      `json:"expired,omitempty"` /// the retention deleted the file but its vectors are kept, see Manifest.Expire
+}
+
+// Items returns the entries as items to embed again, their images are fetched by Store.Prepare
+func Items(entries []Entry) []service.Item {
+	items := make([]service.Item, 0, len(entries))
+	for _, entry := range entries {
+		item := service.Item{
+			Label: entry.Label,
+			ID:    entry.ID,
+			Metadata: map[string]interface{}{
+				service.METADATA_OBJECT_KEY: entry.File,
+			},
+		}
+		if entry.OriginalFilename != "" {
+			item.Metadata[METADATA_ORIGINAL_FILENAME] = entry.OriginalFilename
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// Manifest records the uploads in blob storage, a later entry for the same ID replaces an earlier one
+type Manifest struct {
+	blobs blob.Store
+}
+
+func NewManifest(blobs blob.Store) *Manifest {
+	return &Manifest{
+		blobs: blobs,
+	}
+}
+
+func (m *Manifest) key(id string) string {
+	return MANIFEST_PREFIX + id + ".json"
+}
+
+// Record stores the entry
+func (m *Manifest) Record(entry Entry) error {
+	if entry.Created.IsZero() {
+		entry.Created = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = m.blobs.Put(m.key(entry.ID), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to record upload %s: %w", entry.ID, err)
+	}
+	return nil
+}
+
+// Entries returns the latest entry for each ID, oldest first
+func (m *Manifest) Entries() ([]Entry, error) {
+	entries, err := m.legacyEntries()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for i, entry := range entries {
+		index[entry.ID] = i
+	}
+	objects, err := m.blobs.List(MANIFEST_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		entry, err := m.read(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		if i, exists := index[entry.ID]; exists {
+			entries[i] = *entry
+			continue
+		}
+		index[entry.ID] = len(entries)
+		entries = append(entries, *entry)
+	}
+	kept := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Deleted {
+			kept = append(kept, entry)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Created.Before(kept[j].Created)
+	})
+	return kept, nil
+}
+
+// Delete records that the upload was deleted. The entry is replaced rather than removed, so an entry for
+// the ID in the legacy manifest doesn't come back.
+func (m *Manifest) Delete(id string) error {
+	return m.Record(Entry{ID: id, Deleted: true})
+}
+
+// Expire records that the retention deleted the upload's file. The vectors are still searchable, so the entry
+// is kept for -verify rather than deleted, but there is nothing to embed again.
+func (m *Manifest) Expire(id string) error {
+	entry, err := m.Find(id)
+	if err != nil || entry == nil || entry.Expired {
+		return err
+	}
+	entry.Expired = true
+	return m.Record(*entry)
+}
+
+// Find returns the latest entry for the ID, or nil if it was never recorded or was deleted
+func (m *Manifest) Find(id string) (*Entry, error) {
+	entry, err := m.read(m.key(id))
+	if err == nil && entry.Deleted {
+		return nil, nil
+	}
+	if err == nil {
+		return entry, nil
+	}
+	if !errors.Is(err, blob.ErrNotFound) {
+		return nil, err
+	}
+	legacy, err := m.legacyEntries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range legacy {
+		if entry.ID == id {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Manifest) read(key string) (*Entry, error) {
+	r, err := m.blobs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	entry := &Entry{}
+	if err = json.NewDecoder(r).Decode(entry); err != nil {
+		return nil, fmt.Errorf("upload manifest %s: %w", key, err)
+	}
+	return entry, nil
+}
+
+// legacyEntries reads the JSON lines manifest, if there is one
+func (m *Manifest) legacyEntries() ([]Entry, error) {
+	r, err := m.blobs.Get(LEGACY_MANIFEST)
+	if errors.Is(err, blob.ErrNotFound) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload manifest: %w", err)
+	}
+	defer r.Close()
+	return readEntries(r)
+}
+
+func readEntries(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("upload manifest line %d: %w", line, err)
+		}
+		if i, exists := index[entry.ID]; exists {
+			entries[i] = entry
+			continue
+		}
+		index[entry.ID] = len(entries)
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read upload manifest: %w", err)
+	}
+	return entries, nil
+}
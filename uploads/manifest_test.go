@@ -0,0 +1,102 @@
+package uploads
+
+import (
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/service"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestEntries(t *testing.T) {
+	blobs := blob.NewLocalStore(t.TempDir())
+	created := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	/// Appended to by earlier versions, a later line for an ID replaced the earlier one
+	legacy := `{"id":"a","file":"a.jpg","label":"cat","created":"2026-10-01T00:00:00Z"}
+
+{"id":"b","file":"b.jpg","label":"dog","created":"2026-10-01T00:01:00Z"}
+{"id":"a","file":"a.jpg","label":"kitten","created":"2026-10-01T00:00:00Z"}
+{"id":"c","file":"c.jpg","label":"bird","created":"2026-10-01T00:02:00Z"}
+`
+	if err := blobs.Put(LEGACY_MANIFEST, strings.NewReader(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	manifest := NewManifest(blobs)
+	records := []Entry{
+		{ID: "d", File: "indexed/d.jpg", Label: "fish", Created: created.Add(-time.Minute)},
+		{ID: "e", File: "indexed/e.jpg", Label: "ant"},
+		/// Replaces the legacy entry, and then the recorded one
+		{ID: "b", File: "b.jpg", Label: "puppy", Created: created.Add(time.Minute)},
+		{ID: "d", File: "indexed/d.jpg", Label: "shark", Created: created.Add(-time.Minute)},
+	}
+	for _, entry := range records {
+		if err := manifest.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	/// Deleting a legacy entry keeps it deleted, expiring keeps the entry
+	for _, err := range []error{manifest.Delete("c"), manifest.Expire("a"), manifest.Expire("missing")} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := manifest.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0)
+	for _, entry := range entries {
+		got = append(got, entry.ID+":"+entry.Label)
+	}
+	if want := []string{"d:shark", "a:kitten", "b:puppy", "e:ant"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v, oldest first", got, want)
+	}
+	if !entries[1].Expired || entries[0].Expired {
+		t.Errorf("expected only a to be expired, got %+v", entries)
+	}
+	if entries[3].Created.IsZero() {
+		t.Error("expected Record to set the creation time")
+	}
+
+	tests := []struct {
+		id        string
+		wantLabel string /// "" if there is no entry
+	}{
+		{"a", "kitten"},
+		{"b", "puppy"},
+		{"c", ""},
+		{"d", "shark"},
+		{"missing", ""},
+	}
+	for _, test := range tests {
+		entry, err := manifest.Find(test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.wantLabel == "" && entry != nil || test.wantLabel != "" && (entry == nil || entry.Label != test.wantLabel) {
+			t.Errorf("%s: got %+v, want label %q", test.id, entry, test.wantLabel)
+		}
+	}
+
+	if err = blobs.Put(LEGACY_MANIFEST, strings.NewReader(legacy+"{\"id\":\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manifest.Entries(); err == nil || !strings.Contains(err.Error(), "line 6") {
+		t.Errorf("expected an error for line 6, got %v", err)
+	}
+}
+
+func TestItems(t *testing.T) {
+	items := Items([]Entry{
+		{ID: "a", File: "indexed/a.jpg", Label: "cat", OriginalFilename: "IMG_1.jpg"},
+		{ID: "b", File: "b.jpg", Label: "dog"},
+	})
+	want := []service.Item{
+		{ID: "a", Label: "cat", Metadata: map[string]interface{}{service.METADATA_OBJECT_KEY: "indexed/a.jpg", METADATA_ORIGINAL_FILENAME: "IMG_1.jpg"}},
+		{ID: "b", Label: "dog", Metadata: map[string]interface{}{service.METADATA_OBJECT_KEY: "b.jpg"}},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("got %+v, want %+v", items, want)
+	}
+}
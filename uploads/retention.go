@@ -0,0 +1,210 @@
+package uploads
+
+import (
+	"fmt"
+	"io"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/vectordb"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type RetentionMode string
+
+const (
+	RETAIN_ALL    RetentionMode = "all"    /// never delete
+	RETAIN_NONE   RetentionMode = "none"   /// delete once used
+	RETAIN_DAYS   RetentionMode = "days"   /// delete after N days
+	RETAIN_LATEST RetentionMode = "latest" /// keep only the N most recent
+)
+
+// Retention decides how long the uploads of a category are kept
+type Retention struct {
+	Mode RetentionMode
+	N    int
+}
+
+// ParseRetention parses all, none, days:<N> or latest:<N>. An empty string is all.
+func ParseRetention(s string) (Retention, error) {
+	mode, n, hasN := strings.Cut(strings.TrimSpace(s), ":")
+	retention := Retention{Mode: RetentionMode(mode)}
+	switch retention.Mode {
+	case "":
+		retention.Mode = RETAIN_ALL
+		return retention, nil
+	case RETAIN_ALL, RETAIN_NONE:
+		if hasN {
+			return retention, fmt.Errorf("retention %s takes no count", mode)
+		}
+		return retention, nil
+	case RETAIN_DAYS, RETAIN_LATEST:
+		var err error
+		retention.N, err = strconv.Atoi(n)
+		if err != nil || retention.N < 1 {
+			return retention, fmt.Errorf("retention %s needs a positive count, e.g. %s:7", mode, mode)
+		}
+		return retention, nil
+	}
+	return retention, fmt.Errorf("unknown retention %s, use all, none, days:<N> or latest:<N>", s)
+}
+
+func (r Retention) String() string {
+	if r.Mode == RETAIN_DAYS || r.Mode == RETAIN_LATEST {
+		return fmt.Sprintf("%s:%d", r.Mode, r.N)
+	}
+	return string(r.Mode)
+}
+
+// expired returns the objects the retention doesn't keep
+func (r Retention) expired(objects []blob.ObjectInfo, now time.Time) []blob.ObjectInfo {
+	switch r.Mode {
+	case RETAIN_NONE:
+		return objects
+	case RETAIN_DAYS:
+		cutoff := now.AddDate(0, 0, -r.N)
+		expired := make([]blob.ObjectInfo, 0)
+		for _, object := range objects {
+			if object.Modified.Before(cutoff) {
+				expired = append(expired, object)
+			}
+		}
+		return expired
+	case RETAIN_LATEST:
+		if len(objects) <= r.N {
+			return nil
+		}
+		sorted := make([]blob.ObjectInfo, len(objects))
+		copy(sorted, objects)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Modified.After(sorted[j].Modified)
+		})
+		return sorted[r.N:]
+	}
+	return nil
+}
+
+// SweepResult is what a sweep deleted from one category
+type SweepResult struct {
+	Category string
+	Deleted  int
+	Bytes    int64
+}
+
+// Sweep deletes the objects of the category that the retention doesn't keep. The thumbnails of indexed ones
+// are deleted too and their manifest entries marked expired, their vectors are kept, see DeleteExpiredVectors.
+func (s *Store) Sweep(category string, retention Retention, now time.Time) (*SweepResult, error) {
+	result := &SweepResult{Category: category}
+	if retention.Mode == RETAIN_ALL {
+		return result, nil
+	}
+	objects, err := s.blobs.List(category + "/")
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range retention.expired(objects, now) {
+		if err = s.blobs.Delete(object.Key); err != nil {
+			return result, err
+		}
+		if category == CATEGORY_INDEXED {
+			id := strings.TrimSuffix(path.Base(object.Key), path.Ext(object.Key))
+			if err = s.DeleteThumbnails(id); err != nil {
+				return result, err
+			}
+			if err = NewManifest(s.blobs).Expire(id); err != nil {
+				return result, err
+			}
+		}
+		result.Deleted++
+		result.Bytes += object.Size
+	}
+	return result, nil
+}
+
+// DeleteExpiredVectors deletes the vectors of the uploads the retention deleted, and then their manifest entries,
+// for when the index should only find images that can still be shown. It returns how many uploads it deleted.
+func (s *Store) DeleteExpiredVectors(pc vectordb.VectorStore) (int, error) {
+	manifest := NewManifest(s.blobs)
+	entries, err := manifest.Entries()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, entry := range entries {
+		if !entry.Expired {
+			continue
+		}
+		if len(entry.VectorIDs) > 0 {
+			if err = pc.DeleteVectors(entry.VectorIDs); err != nil {
+				return deleted, fmt.Errorf("failed to delete the vectors of %s: %w", entry.ID, err)
+			}
+		}
+		if err = manifest.Delete(entry.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// CategoryUsage is the storage used by one category of uploads
+type CategoryUsage struct {
+	Category string
+	Objects  int
+	Bytes    int64
+	Oldest   time.Time
+	Newest   time.Time
+}
+
+// Usage sums the storage used per category. Objects outside the categories, e.g. uploads from before
+// they were stored by content, are summed as "other".
+func (s *Store) Usage() ([]CategoryUsage, error) {
+	objects, err := s.blobs.List("")
+	if err != nil {
+		return nil, err
+	}
+	categories := []string{CATEGORY_INDEXED, CATEGORY_QUERIES, strings.TrimSuffix(THUMBNAIL_PREFIX, "/"),
+		strings.TrimSuffix(MANIFEST_PREFIX, "/"), "other"}
+	usage := make(map[string]*CategoryUsage)
+	for _, category := range categories {
+		usage[category] = &CategoryUsage{Category: category}
+	}
+	for _, object := range objects {
+		category, _, found := strings.Cut(object.Key, "/")
+		if _, ok := usage[category]; !ok || !found {
+			category = "other"
+		}
+		u := usage[category]
+		u.Objects++
+		u.Bytes += object.Size
+		if u.Oldest.IsZero() || object.Modified.Before(u.Oldest) {
+			u.Oldest = object.Modified
+		}
+		if object.Modified.After(u.Newest) {
+			u.Newest = object.Modified
+		}
+	}
+	results := make([]CategoryUsage, 0, len(categories))
+	for _, category := range categories {
+		results = append(results, *usage[category])
+	}
+	return results, nil
+}
+
+// WriteUsage writes the usage as a table
+func WriteUsage(w io.Writer, usage []CategoryUsage) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tOBJECTS\tMB\tOLDEST\tNEWEST")
+	for _, u := range usage {
+		oldest, newest := "-", "-"
+		if u.Objects > 0 {
+			oldest = u.Oldest.Format(time.DateTime)
+			newest = u.Newest.Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%s\t%s\n", u.Category, u.Objects, float64(u.Bytes)/(1<<20), oldest, newest)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,163 @@
+package uploads
+
+import (
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Retention
+		wantErr bool
+	}{
+		{"", Retention{Mode: RETAIN_ALL}, false},
+		{" all ", Retention{Mode: RETAIN_ALL}, false},
+		{"none", Retention{Mode: RETAIN_NONE}, false},
+		{"days:7", Retention{Mode: RETAIN_DAYS, N: 7}, false},
+		{"latest:100", Retention{Mode: RETAIN_LATEST, N: 100}, false},
+		{"all:3", Retention{}, true},
+		{"none:1", Retention{}, true},
+		{"days", Retention{}, true},
+		{"days:", Retention{}, true},
+		{"days:0", Retention{}, true},
+		{"latest:-1", Retention{}, true},
+		{"latest:ten", Retention{}, true},
+		{"forever", Retention{}, true},
+		{"ALL", Retention{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseRetention(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.in, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %+v %v, want %+v", test.in, got, err, test.want)
+		}
+		if test.in != "" && got.String() != strings.TrimSpace(test.in) {
+			t.Errorf("%q: String() is %s", test.in, got.String())
+		}
+	}
+}
+
+func TestRetentionExpired(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	objects := []blob.ObjectInfo{
+		{Key: "indexed/a.jpg", Modified: now.AddDate(0, 0, -10)},
+		{Key: "indexed/b.jpg", Modified: now.Add(-time.Hour)},
+		{Key: "indexed/c.jpg", Modified: now.AddDate(0, 0, -3)},
+		{Key: "indexed/d.jpg", Modified: now.AddDate(0, 0, -7).Add(time.Minute)},
+	}
+	tests := []struct {
+		retention Retention
+		want      []string
+	}{
+		{Retention{Mode: RETAIN_ALL}, nil},
+		{Retention{Mode: RETAIN_NONE}, []string{"indexed/a.jpg", "indexed/b.jpg", "indexed/c.jpg", "indexed/d.jpg"}},
+		{Retention{Mode: RETAIN_DAYS, N: 7}, []string{"indexed/a.jpg"}},
+		{Retention{Mode: RETAIN_DAYS, N: 1}, []string{"indexed/a.jpg", "indexed/c.jpg", "indexed/d.jpg"}},
+		{Retention{Mode: RETAIN_DAYS, N: 30}, nil},
+		{Retention{Mode: RETAIN_LATEST, N: 2}, []string{"indexed/a.jpg", "indexed/d.jpg"}},
+		{Retention{Mode: RETAIN_LATEST, N: 1}, []string{"indexed/a.jpg", "indexed/c.jpg", "indexed/d.jpg"}},
+		{Retention{Mode: RETAIN_LATEST, N: 4}, nil},
+		{Retention{Mode: RETAIN_LATEST, N: 10}, nil},
+	}
+	for _, test := range tests {
+		got := make([]string, 0)
+		for _, object := range test.retention.expired(objects, now) {
+			got = append(got, object.Key)
+		}
+		sort.Strings(got)
+		if len(got) == 0 && len(test.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expired %v, want %v", test.retention.String(), got, test.want)
+		}
+	}
+	if objects[0].Key != "indexed/a.jpg" || objects[1].Key != "indexed/b.jpg" {
+		t.Error("expired reordered the objects it was given")
+	}
+}
+
+func TestSweepExpiresManifestEntries(t *testing.T) {
+	blobs := blob.NewLocalStore(t.TempDir())
+	store := NewStore(blobs, t.TempDir())
+	manifest := NewManifest(blobs)
+	for _, id := range []string{"a", "b"} {
+		key := CATEGORY_INDEXED + "/" + id + ".jpg"
+		if err := blobs.Put(key, strings.NewReader("jpeg")); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifest.Record(Entry{ID: id, File: key, Label: "cat", VectorIDs: []string{id + "-text", id + "-img"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := store.Sweep(CATEGORY_INDEXED, Retention{Mode: RETAIN_NONE}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 2 || result.Bytes != 8 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	entries, err := manifest.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected the entries to be kept, got %+v", entries)
+	}
+	for _, entry := range entries {
+		if !entry.Expired || entry.Label != "cat" || len(entry.VectorIDs) != 2 {
+			t.Errorf("expected %s to be kept and marked expired, got %+v", entry.ID, entry)
+		}
+	}
+	/// Sweeping again doesn't find the deleted files
+	if result, err = store.Sweep(CATEGORY_INDEXED, Retention{Mode: RETAIN_NONE}, time.Now()); err != nil || result.Deleted != 0 {
+		t.Errorf("unexpected second sweep %+v %v", result, err)
+	}
+}
+
+func TestDeleteExpiredVectors(t *testing.T) {
+	blobs := blob.NewLocalStore(t.TempDir())
+	store := NewStore(blobs, t.TempDir())
+	manifest := NewManifest(blobs)
+	db := vectordb.NewMemoryDB()
+	for _, entry := range []Entry{
+		{ID: "a", File: "indexed/a.jpg", Expired: true},
+		{ID: "b", File: "indexed/b.jpg"},
+		{ID: "c", File: "indexed/c.jpg", Expired: true},
+	} {
+		entry.VectorIDs = service.VectorIDs(entry.ID)
+		if err := manifest.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range entry.VectorIDs {
+			if err := db.UpsertVector([]float32{1, 0}, id, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deleted, err := store.DeleteExpiredVectors(db)
+	if err != nil || deleted != 2 {
+		t.Fatalf("expected 2 uploads to be deleted, got %d %v", deleted, err)
+	}
+	if ids, _, _ := db.ListIDs("", 10, ""); !reflect.DeepEqual(ids, []string{"img-b", "text-b"}) {
+		t.Errorf("expected only b's vectors to be left, got %v", ids)
+	}
+	if entries, err := manifest.Entries(); err != nil || len(entries) != 1 || entries[0].ID != "b" {
+		t.Errorf("expected only b's entry to be left, got %+v %v", entries, err)
+	}
+	if deleted, err = store.DeleteExpiredVectors(db); err != nil || deleted != 0 {
+		t.Errorf("expected nothing left to delete, got %d %v", deleted, err)
+	}
+}
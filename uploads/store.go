@@ -0,0 +1,129 @@
+package uploads
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/service"
+	"os"
+	"path"
+)
+
+// Uploads are kept under a key prefix per category
+const (
+	CATEGORY_INDEXED = "indexed" /// images embedded into the index
+	CATEGORY_QUERIES = "queries" /// images sent for detection
+)
+
+// Object is an upload stored under the hash of its content
+type Object struct {
+	ID      string /// derived from the content, the same bytes always get the same ID
+	Key     string /// <category>/<ID><ext>
+	Existed bool   /// the same bytes were already stored
+	File    string /// a local copy of the content, e.g. to embed it, removed by Release
+}
+
+// Release removes the local copy
+func (o *Object) Release() {
+	os.Remove(o.File)
+}
+
+// Store keeps uploads in blob storage under the hash of their content, so uploads with the same client
+// file name can't overwrite each other, and uploading the same bytes twice stores them once
+type Store struct {
+	blobs  blob.Store
+	tmpdir string
+}
+
+// NewStore creates a store for the blobs, the local copies are kept in tmpdir
+func NewStore(blobs blob.Store, tmpdir string) *Store {
+	return &Store{
+		blobs:  blobs,
+		tmpdir: tmpdir,
+	}
+}
+
+func (s *Store) Blobs() blob.Store {
+	return s.blobs
+}
+
+// Save stores the content under its hash in the category. ext is kept on the key, see ImageInfo.Ext.
+// The caller must Release the returned object.
+func (s *Store) Save(category string, r io.Reader, ext string) (*Object, error) {
+	tmp, err := os.CreateTemp(s.tmpdir, "upload-*"+ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))[:32]
+	obj := &Object{
+		ID:   id,
+		Key:  category + "/" + id + ext,
+		File: tmp.Name(),
+	}
+	obj.Existed, err = s.blobs.Exists(obj.Key)
+	if err == nil && !obj.Existed {
+		err = s.put(obj.Key, obj.File)
+	}
+	if err != nil {
+		obj.Release()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *Store) put(key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.blobs.Put(key, f)
+}
+
+// Fetch copies the object to a local temp file, which the caller must remove
+func (s *Store) Fetch(key string) (string, error) {
+	r, err := s.blobs.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	tmp, err := os.CreateTemp(s.tmpdir, "fetch-*"+path.Ext(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to fetch %s: %w", key, err)
+	}
+	return tmp.Name(), nil
+}
+
+// Prepare is a service.BulkOptions.Prepare that fetches the image of items stored as objects
+func (s *Store) Prepare(item service.Item) (service.Item, func(), error) {
+	key, _ := item.Metadata[service.METADATA_OBJECT_KEY].(string)
+	if item.Imagefile != "" || key == "" {
+		return item, nil, nil
+	}
+	file, err := s.Fetch(key)
+	if err != nil {
+		return item, nil, err
+	}
+	item.Imagefile = file
+	return item, func() { os.Remove(file) }, nil
+}
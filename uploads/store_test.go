@@ -0,0 +1,54 @@
+package uploads
+
+import (
+	"io"
+	"object-detection-zero-shot/blob"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSave(t *testing.T) {
+	store := NewStore(blob.NewLocalStore(t.TempDir()), t.TempDir())
+	keyPattern := regexp.MustCompile(`^indexed/[0-9a-f]{32}\.jpg$`)
+	save := func(content string) *Object {
+		obj, err := store.Save(CATEGORY_INDEXED, strings.NewReader(content), ".jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer obj.Release()
+		if !keyPattern.MatchString(obj.Key) || obj.Key != CATEGORY_INDEXED+"/"+obj.ID+".jpg" {
+			t.Errorf("unexpected key %s for ID %s", obj.Key, obj.ID)
+		}
+		if data, err := os.ReadFile(obj.File); err != nil || string(data) != content {
+			t.Errorf("expected a local copy of the content, got %q %v", data, err)
+		}
+		return obj
+	}
+
+	/// Two clients uploading photo.jpg, the file name has no part in the key
+	first := save("first photo.jpg")
+	second := save("second photo.jpg")
+	if first.ID == second.ID || first.Existed || second.Existed {
+		t.Errorf("expected different content to be stored apart, got %+v and %+v", first, second)
+	}
+	again := save("first photo.jpg")
+	if again.ID != first.ID || !again.Existed {
+		t.Errorf("expected the same content to get the same ID and exist, got %+v", again)
+	}
+	for obj, want := range map[*Object]string{first: "first photo.jpg", second: "second photo.jpg"} {
+		r, err := store.Blobs().Get(obj.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if string(data) != want {
+			t.Errorf("%s holds %q, want %q", obj.Key, data, want)
+		}
+	}
+	if _, err := os.Stat(first.File); !os.IsNotExist(err) {
+		t.Errorf("expected Release to remove the local copy, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package uploads
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"object-detection-zero-shot/service"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// Thumbnails are kept under THUMBNAIL_PREFIX<ID>/<size><ext>
+const THUMBNAIL_PREFIX = "thumbs/"
+
+type ThumbnailFormat string
+
+const (
+	THUMBNAIL_JPEG ThumbnailFormat = "jpeg"
+	THUMBNAIL_WEBP ThumbnailFormat = "webp" /// lossless, so larger than JPEG for photos
+)
+
+// ThumbnailConfig sets the thumbnails made of each indexed upload
+type ThumbnailConfig struct {
+	Sizes   []int /// of the longest side in pixels, smallest first. Images are never scaled up.
+	Format  ThumbnailFormat
+	Quality int /// JPEG quality, 1 to 100
+}
+
+func DefaultThumbnailConfig() ThumbnailConfig {
+	return ThumbnailConfig{
+		Sizes:   []int{256},
+		Format:  THUMBNAIL_JPEG,
+		Quality: 80,
+	}
+}
+
+// ParseThumbnailSizes parses comma separated sizes, e.g. 128,512
+func ParseThumbnailSizes(s string) ([]int, error) {
+	sizes := make([]int, 0)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size < 16 || size > 2048 {
+			return nil, fmt.Errorf("invalid thumbnail size %s, use 16 to 2048 pixels", field)
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no thumbnail sizes in %s", s)
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+func ParseThumbnailFormat(s string) (ThumbnailFormat, error) {
+	switch format := ThumbnailFormat(strings.ToLower(s)); format {
+	case THUMBNAIL_JPEG, THUMBNAIL_WEBP:
+		return format, nil
+	case "jpg":
+		return THUMBNAIL_JPEG, nil
+	}
+	return "", fmt.Errorf("unknown thumbnail format %s, use jpeg or webp", s)
+}
+
+func (f ThumbnailFormat) Ext() string {
+	if f == THUMBNAIL_WEBP {
+		return ".webp"
+	}
+	return ".jpg"
+}
+
+func (f ThumbnailFormat) ContentType() string {
+	if f == THUMBNAIL_WEBP {
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// HasSize returns whether thumbnails are made at the size
+func (c ThumbnailConfig) HasSize(size int) bool {
+	for _, s := range c.Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// ThumbnailKey is where the thumbnail of the upload at the size is stored
+func ThumbnailKey(id string, size int, format ThumbnailFormat) string {
+	return fmt.Sprintf("%s%s/%d%s", THUMBNAIL_PREFIX, id, size, format.Ext())
+}
+
+// MakeThumbnails stores a thumbnail of the image file at each configured size
+func (s *Store) MakeThumbnails(id, file string, cfg ThumbnailConfig) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	/// The dimensions were checked by Validate, so decoding is bounded
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", id, err)
+	}
+	for _, size := range cfg.Sizes {
+		buf := &bytes.Buffer{}
+		if err = encodeThumbnail(buf, service.ScaleDown(img, size), cfg); err != nil {
+			return fmt.Errorf("failed to encode the %d pixel thumbnail of %s: %w", size, id, err)
+		}
+		if err = s.blobs.Put(ThumbnailKey(id, size, cfg.Format), buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasThumbnails returns whether every configured thumbnail of the upload is stored
+func (s *Store) HasThumbnails(id string, cfg ThumbnailConfig) (bool, error) {
+	for _, size := range cfg.Sizes {
+		exists, err := s.blobs.Exists(ThumbnailKey(id, size, cfg.Format))
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Thumbnail returns the stored thumbnail, or an error wrapping blob.ErrNotFound if it wasn't made
+func (s *Store) Thumbnail(id string, size int, format ThumbnailFormat) (io.ReadCloser, error) {
+	return s.blobs.Get(ThumbnailKey(id, size, format))
+}
+
+// DeleteThumbnails deletes every thumbnail of the upload, in any size or format
+func (s *Store) DeleteThumbnails(id string) error {
+	objects, err := s.blobs.List(THUMBNAIL_PREFIX + id + "/")
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err = s.blobs.Delete(object.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeThumbnail(w io.Writer, img image.Image, cfg ThumbnailConfig) error {
+	if cfg.Format == THUMBNAIL_WEBP {
+		return nativewebp.Encode(w, img, nil)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: cfg.Quality})
+}
@@ -0,0 +1,134 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"object-detection-zero-shot/blob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseThumbnailSizes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{"256", []int{256}, false},
+		{"512, 128,", []int{128, 512}, false},
+		{"16,2048", []int{16, 2048}, false},
+		{"", nil, true},
+		{" , ", nil, true},
+		{"15", nil, true},
+		{"2049", nil, true},
+		{"128,big", nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseThumbnailSizes(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", test.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v %v, want %v", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestParseThumbnailFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ThumbnailFormat
+		wantExt string
+		wantErr bool
+	}{
+		{"jpeg", THUMBNAIL_JPEG, ".jpg", false},
+		{"JPG", THUMBNAIL_JPEG, ".jpg", false},
+		{"webp", THUMBNAIL_WEBP, ".webp", false},
+		{"png", "", "", true},
+		{"", "", "", true},
+	}
+	for _, test := range tests {
+		got, err := ParseThumbnailFormat(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %s", test.in, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want || got.Ext() != test.wantExt {
+			t.Errorf("%q: got %s %v, want %s", test.in, got, err, test.want)
+		}
+	}
+}
+
+func TestMakeThumbnails(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "upload.png")
+	if err := os.WriteFile(file, encoded(t, "png", 400, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cfg      ThumbnailConfig
+		wantKeys []string
+		wantSize map[string][2]int
+	}{
+		{
+			cfg:      ThumbnailConfig{Sizes: []int{64, 200, 1024}, Format: THUMBNAIL_JPEG, Quality: 80},
+			wantKeys: []string{"thumbs/abc/1024.jpg", "thumbs/abc/200.jpg", "thumbs/abc/64.jpg"},
+			wantSize: map[string][2]int{"thumbs/abc/64.jpg": {64, 16}, "thumbs/abc/200.jpg": {200, 50}, "thumbs/abc/1024.jpg": {400, 100}},
+		},
+		{
+			cfg:      ThumbnailConfig{Sizes: []int{128}, Format: THUMBNAIL_WEBP},
+			wantKeys: []string{"thumbs/abc/128.webp"},
+			wantSize: map[string][2]int{"thumbs/abc/128.webp": {128, 32}},
+		},
+	}
+	for _, test := range tests {
+		store := NewStore(blob.NewLocalStore(t.TempDir()), t.TempDir())
+		if ok, err := store.HasThumbnails("abc", test.cfg); ok || err != nil {
+			t.Fatalf("expected no thumbnails yet, got %v %v", ok, err)
+		}
+		if err := store.MakeThumbnails("abc", file, test.cfg); err != nil {
+			t.Fatal(err)
+		}
+		objects, err := store.Blobs().List(THUMBNAIL_PREFIX)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]string, 0)
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		if !reflect.DeepEqual(keys, test.wantKeys) {
+			t.Errorf("got keys %v, want %v", keys, test.wantKeys)
+		}
+		for _, size := range test.cfg.Sizes {
+			thumb, err := store.Thumbnail("abc", size, test.cfg.Format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(thumb)
+			thumb.Close()
+			config, format, err := image.DecodeConfig(bytes.NewReader(data))
+			key := ThumbnailKey("abc", size, test.cfg.Format)
+			if err != nil || format != string(test.cfg.Format) || [2]int{config.Width, config.Height} != test.wantSize[key] {
+				t.Errorf("%s: got a %s %dx%d %v, want %v", key, format, config.Width, config.Height, err, test.wantSize[key])
+			}
+		}
+		if ok, err := store.HasThumbnails("abc", test.cfg); !ok || err != nil {
+			t.Errorf("expected every thumbnail to be stored, got %v %v", ok, err)
+		}
+		if err = store.DeleteThumbnails("abc"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.Thumbnail("abc", test.cfg.Sizes[0], test.cfg.Format); !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("expected the thumbnails to be deleted, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package uploads
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image too large")
+)
+
+// allowedTypes are the sniffed content types that are accepted, and the extension they are stored with
+var allowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Limits for uploaded images
+type Limits struct {
+	MaxBytes     int64 /// of the whole request
+	MaxDimension int   /// width or height in pixels
+	MaxPixels    int   /// width * height, guards against decompression bombs that are small on the wire
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBytes:     10 << 20,
+		MaxDimension: 8192,
+		MaxPixels:    40_000_000,
+	}
+}
+
+// ImageInfo is what validation found out about an image without decoding it
+type ImageInfo struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+// Validate sniffs the content type from the magic bytes and reads the dimensions from the image header,
+// without decoding the pixels. It returns an error wrapping ErrUnsupportedType or ErrTooLarge if the image
+// isn't accepted. The reader is consumed, so seek back before reading it again.
+func Validate(r io.Reader, limits Limits) (*ImageInfo, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	header = header[:n]
+	info := &ImageInfo{ContentType: http.DetectContentType(header)}
+	ext, ok := allowedTypes[info.ContentType]
+	if !ok {
+		return nil, fmt.Errorf("%w %s, use JPEG, PNG, GIF or WebP", ErrUnsupportedType, strings.Split(info.ContentType, ";")[0])
+	}
+	info.Ext = ext
+
+	config, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(header), r))
+	if err != nil {
+		return nil, fmt.Errorf("%w, the %s header can't be read: %s", ErrUnsupportedType, info.ContentType, err)
+	}
+	info.Width, info.Height = config.Width, config.Height
+	if info.Width > limits.MaxDimension || info.Height > limits.MaxDimension {
+		return nil, fmt.Errorf("%w, %dx%d pixels is more than the limit of %d pixels wide or high",
+			ErrTooLarge, info.Width, info.Height, limits.MaxDimension)
+	}
+	if info.Width*info.Height > limits.MaxPixels {
+		return nil, fmt.Errorf("%w, %dx%d is more than the limit of %d pixels",
+			ErrTooLarge, info.Width, info.Height, limits.MaxPixels)
+	}
+	return info, nil
+}
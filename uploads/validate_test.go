@@ -0,0 +1,88 @@
+package uploads
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encoded returns a small image in the format
+func encoded(t *testing.T, format string, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	buf := &bytes.Buffer{}
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(buf, img)
+	case "jpeg":
+		err = jpeg.Encode(buf, img, nil)
+	case "gif":
+		err = gif.Encode(buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader is just the signature and IHDR chunk of a PNG, which is all Validate reads. A header claiming
+// a huge size with next to no data is what a decompression bomb looks like before it's decoded.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 0, 17)
+	ihdr = append(ihdr, "IHDR"...)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) /// 8 bit RGB, not interlaced
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, 13)
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestValidate(t *testing.T) {
+	limits := Limits{MaxBytes: 1 << 20, MaxDimension: 1000, MaxPixels: 500_000}
+	tests := []struct {
+		name     string
+		data     []byte
+		wantExt  string
+		wantSize [2]int
+		wantErr  error
+	}{
+		{"png", encoded(t, "png", 20, 10), ".png", [2]int{20, 10}, nil},
+		{"jpeg", encoded(t, "jpeg", 20, 10), ".jpg", [2]int{20, 10}, nil},
+		{"gif", encoded(t, "gif", 20, 10), ".gif", [2]int{20, 10}, nil},
+		{"header only", pngHeader(1000, 500), ".png", [2]int{1000, 500}, nil},
+		{"text", []byte("name,label\ncat.jpg,cat\n"), "", [2]int{}, ErrUnsupportedType},
+		{"html", []byte("<html><body><img src=x></body></html>"), "", [2]int{}, ErrUnsupportedType},
+		{"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`), "", [2]int{}, ErrUnsupportedType},
+		{"empty", []byte{}, "", [2]int{}, ErrUnsupportedType},
+		{"truncated header", pngHeader(10, 10)[:20], "", [2]int{}, ErrUnsupportedType},
+		{"too wide", pngHeader(1001, 10), "", [2]int{}, ErrTooLarge},
+		{"too high", pngHeader(10, 1001), "", [2]int{}, ErrTooLarge},
+		{"pixel bomb", pngHeader(1000, 1000), "", [2]int{}, ErrTooLarge},
+		{"overflowing header", pngHeader(1<<30, 1<<30), "", [2]int{}, ErrUnsupportedType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := Validate(bytes.NewReader(test.data), limits)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected %v, got %+v %v", test.wantErr, info, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Ext != test.wantExt || info.Width != test.wantSize[0] || info.Height != test.wantSize[1] {
+				t.Errorf("got %+v, want %s %v", info, test.wantExt, test.wantSize)
+			}
+		})
+	}
+}
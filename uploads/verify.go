@@ -0,0 +1,158 @@
+package uploads
+
+import (
+	"fmt"
+	"io"
+	"object-detection-zero-shot/dataset"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Consistency lists the orphans in both directions between the upload dir and the vector store
+type Consistency struct {
+	Entries int
+	Files   int
+	Vectors int
+	Expired int /// recorded uploads whose file the retention deleted, their vectors are expected to have no file
+
+	/// Files without vectors
+	MissingVectors  []Entry  /// recorded uploads with one or more of their vectors missing
+	UnrecordedFiles []string /// stored uploads that aren't in the manifest
+
+	/// Vectors without files
+	MissingFiles  []Entry  /// recorded uploads whose object is gone
+	OrphanVectors []string /// vectors of no recorded upload, whose object or image_file doesn't exist either
+}
+
+// OK is true if there are no orphans
+func (c *Consistency) OK() bool {
+	return len(c.MissingVectors)+len(c.UnrecordedFiles)+len(c.MissingFiles)+len(c.OrphanVectors) == 0
+}
+
+// WriteText writes a summary followed by every orphan
+func (c *Consistency) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Manifest entries: %d (%d expired by the retention), image files: %d, vectors: %d\n",
+		c.Entries, c.Expired, c.Files, c.Vectors)
+	fmt.Fprintf(w, "Files without vectors: %d recorded, %d not in the manifest\n", len(c.MissingVectors), len(c.UnrecordedFiles))
+	for _, entry := range c.MissingVectors {
+		fmt.Fprintf(w, "    %s\t%s\n", entry.ID, entry.File)
+	}
+	for _, file := range c.UnrecordedFiles {
+		fmt.Fprintf(w, "    -\t%s\n", file)
+	}
+	fmt.Fprintf(w, "Vectors without files: %d recorded, %d not in the manifest\n", len(c.MissingFiles), len(c.OrphanVectors))
+	for _, entry := range c.MissingFiles {
+		fmt.Fprintf(w, "    %s\t%s\n", entry.ID, strings.Join(entry.VectorIDs, ","))
+	}
+	for _, id := range c.OrphanVectors {
+		fmt.Fprintf(w, "    %s\n", id)
+	}
+}
+
+// Verify compares the manifest and the stored uploads with every vector in the namespace
+func Verify(manifest *Manifest, pc vectordb.VectorStore) (*Consistency, error) {
+	entries, err := manifest.Entries()
+	if err != nil {
+		return nil, err
+	}
+	check := &Consistency{Entries: len(entries)}
+
+	vectors := make(map[string]bool)
+	token := ""
+	for {
+		ids, next, err := pc.ListIDs("", 100, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			vectors[id] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	check.Vectors = len(vectors)
+
+	/// Uploads from before they were stored by content are images at the top level
+	objects, err := manifest.blobs.List("")
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool)
+	for _, object := range objects {
+		if strings.HasPrefix(object.Key, CATEGORY_INDEXED+"/") || (!strings.Contains(object.Key, "/") && dataset.IsImage(object.Key)) {
+			stored[object.Key] = true
+		}
+	}
+	check.Files = len(stored)
+
+	recordedFiles := make(map[string]bool)
+	recordedVectors := make(map[string]bool)
+	for _, entry := range entries {
+		recordedFiles[entry.File] = true
+		missing := false
+		for _, id := range entry.VectorIDs {
+			recordedVectors[id] = true
+			if !vectors[id] {
+				missing = true
+			}
+		}
+		if missing {
+			check.MissingVectors = append(check.MissingVectors, entry)
+		}
+		if entry.Expired {
+			check.Expired++
+			continue
+		}
+		if !stored[entry.File] {
+			check.MissingFiles = append(check.MissingFiles, entry)
+		}
+	}
+	for key := range stored {
+		if !recordedFiles[key] {
+			check.UnrecordedFiles = append(check.UnrecordedFiles, key)
+		}
+	}
+	sort.Strings(check.UnrecordedFiles)
+
+	/// Vectors that aren't from an upload, e.g. imported from the command line, are fine as long as their image exists
+	unrecorded := make([]string, 0)
+	for id := range vectors {
+		if !recordedVectors[id] {
+			unrecorded = append(unrecorded, id)
+		}
+	}
+	sort.Strings(unrecorded)
+	for start := 0; start < len(unrecorded); start += 100 {
+		end := min(start+100, len(unrecorded))
+		vectors, err := pc.FetchVectors(unrecorded[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range unrecorded[start:end] {
+			metadata := vectors[id].Metadata
+			if key, ok := metadata[service.METADATA_OBJECT_KEY].(string); ok {
+				if stored[key] {
+					continue
+				}
+				check.OrphanVectors = append(check.OrphanVectors, id)
+				continue
+			}
+			imagefile, _ := metadata[service.METADATA_IMAGE_FILE].(string)
+			if strings.HasPrefix(imagefile, "http://") || strings.HasPrefix(imagefile, "https://") {
+				continue
+			}
+			if imagefile != "" {
+				if _, err := os.Stat(imagefile); err == nil {
+					continue
+				}
+			}
+			check.OrphanVectors = append(check.OrphanVectors, id)
+		}
+	}
+	return check, nil
+}
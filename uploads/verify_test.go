@@ -0,0 +1,120 @@
+package uploads
+
+import (
+	"bytes"
+	"fmt"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/vectordb"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	blobs := blob.NewLocalStore(t.TempDir())
+	manifest := NewManifest(blobs)
+	db := vectordb.NewMemoryDB()
+	upsert := func(id string, metadata map[string]interface{}) {
+		if err := db.UpsertVector([]float32{1, 0}, id, metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put := func(key string) {
+		if err := blobs.Put(key, strings.NewReader("image")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries := []struct {
+		entry   Entry
+		stored  bool
+		vectors []string
+	}{
+		{Entry{ID: "ok", File: "indexed/ok.jpg"}, true, service.VectorIDs("ok")},
+		{Entry{ID: "novec", File: "indexed/novec.jpg"}, true, service.VectorIDs("novec")[1:]},
+		{Entry{ID: "nofile", File: "indexed/nofile.jpg"}, false, service.VectorIDs("nofile")},
+		{Entry{ID: "expired", File: "indexed/expired.jpg", Expired: true}, false, service.VectorIDs("expired")},
+		{Entry{ID: "legacy", File: "legacy.jpg"}, true, service.VectorIDs("legacy")},
+	}
+	for _, e := range entries {
+		e.entry.VectorIDs = service.VectorIDs(e.entry.ID)
+		if err := manifest.Record(e.entry); err != nil {
+			t.Fatal(err)
+		}
+		if e.stored {
+			put(e.entry.File)
+		}
+		for _, id := range e.vectors {
+			upsert(id, map[string]interface{}{service.METADATA_OBJECT_KEY: e.entry.File})
+		}
+	}
+	/// Files that aren't uploads are ignored
+	for _, key := range []string{"indexed/stray.jpg", "old.png", "notes.txt", "queries/q.jpg", "thumbs/ok/256.jpg"} {
+		put(key)
+	}
+
+	/// Vectors imported from the command line, more than a page of them
+	local := filepath.Join(t.TempDir(), "local.jpg")
+	if err := os.WriteFile(local, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 120; i++ {
+		upsert(fmt.Sprintf("url-%03d", i), map[string]interface{}{service.METADATA_IMAGE_FILE: fmt.Sprintf("https://example.com/%d.jpg", i)})
+	}
+	upsert("local", map[string]interface{}{service.METADATA_IMAGE_FILE: local})
+	upsert("keyed", map[string]interface{}{service.METADATA_OBJECT_KEY: "indexed/stray.jpg"})
+	upsert("gone", map[string]interface{}{service.METADATA_IMAGE_FILE: filepath.Join(t.TempDir(), "gone.jpg")})
+	upsert("keyed-gone", map[string]interface{}{service.METADATA_OBJECT_KEY: "indexed/gone.jpg"})
+	upsert("bare", nil)
+
+	check, err := Verify(manifest, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryIDs := func(entries []Entry) []string {
+		ids := make([]string, 0)
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+	if check.Entries != 5 || check.Files != 5 || check.Vectors != 9+125 || check.Expired != 1 {
+		t.Errorf("unexpected counts %+v", check)
+	}
+	if got := entryIDs(check.MissingVectors); !reflect.DeepEqual(got, []string{"novec"}) {
+		t.Errorf("got missing vectors %v", got)
+	}
+	if got := entryIDs(check.MissingFiles); !reflect.DeepEqual(got, []string{"nofile"}) {
+		t.Errorf("got missing files %v", got)
+	}
+	if !reflect.DeepEqual(check.UnrecordedFiles, []string{"indexed/stray.jpg", "old.png"}) {
+		t.Errorf("got unrecorded files %v", check.UnrecordedFiles)
+	}
+	if !reflect.DeepEqual(check.OrphanVectors, []string{"bare", "gone", "keyed-gone"}) {
+		t.Errorf("got orphan vectors %v", check.OrphanVectors)
+	}
+	if check.OK() {
+		t.Error("expected the orphans to fail the check")
+	}
+	buf := &bytes.Buffer{}
+	check.WriteText(buf)
+	for _, line := range []string{"Files without vectors: 1 recorded, 2 not in the manifest", "    nofile\ttext-nofile,img-nofile", "    keyed-gone"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected %q in\n%s", line, buf)
+		}
+	}
+
+	/// Fixing every orphan passes
+	for _, id := range []string{"bare", "gone", "keyed-gone"} {
+		db.DeleteVectors([]string{id})
+	}
+	upsert("text-novec", nil)
+	put("indexed/nofile.jpg")
+	manifest.Record(Entry{ID: "stray", File: "indexed/stray.jpg"})
+	manifest.Record(Entry{ID: "old", File: "old.png"})
+	if check, err = Verify(manifest, db); err != nil || !check.OK() {
+		t.Errorf("expected no orphans, got %+v %v", check, err)
+	}
+}
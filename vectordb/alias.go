@@ -0,0 +1,101 @@
+package vectordb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/**
+The alias file names the namespace that serves searches, e.g.
+{
+	"active": "objects-1760000000",
+	"active_version": "openai/clip-vit-large-patch14 (preprocess 1)",
+	"previous": "objects",
+	"previous_version": "openai/clip-vit-base-patch32 (preprocess 1)",
+	"updated": "2026-10-19T10:00:00Z"
+}
+so a reindexed namespace can be swapped in while the old one keeps serving, and swapped back out again.
+*/
+
+type Alias struct {
+	Active          string    `json:"active"`
+	ActiveVersion   string    `json:"active_version,omitempty"` /// the model version the active namespace was embedded with
+	Previous        string    `json:"previous,omitempty"`
+	PreviousVersion string    `json:"previous_version,omitempty"`
+	Updated         time.Time `json:"updated"`
+}
+
+// ReadAlias reads the alias file, it returns nil if the file doesn't exist yet
+func ReadAlias(file string) (*Alias, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alias: %w", err)
+	}
+	alias := &Alias{}
+	if err = json.Unmarshal(data, alias); err != nil {
+		return nil, fmt.Errorf("failed to decode alias %s: %w", file, err)
+	}
+	if alias.Active == "" {
+		return nil, fmt.Errorf("alias %s has no active namespace", file)
+	}
+	return alias, nil
+}
+
+// WriteAlias replaces the alias file in one rename, so readers never see a partly written file
+func WriteAlias(file string, alias *Alias) error {
+	alias.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(alias, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write alias: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write alias: %w", err)
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// Swap makes the namespace active, keeping the current one as the rollback target
+func (a *Alias) Swap(namespace, version string) {
+	a.Previous, a.PreviousVersion = a.Active, a.ActiveVersion
+	a.Active, a.ActiveVersion = namespace, version
+}
+
+// Rollback makes the previous namespace active again
+func (a *Alias) Rollback() error {
+	if a.Previous == "" {
+		return fmt.Errorf("there is no previous namespace to roll back to")
+	}
+	a.Active, a.Previous = a.Previous, a.Active
+	a.ActiveVersion, a.PreviousVersion = a.PreviousVersion, a.ActiveVersion
+	return nil
+}
+
+// NamespaceFor returns the namespace a service running the model version should search: the active namespace
+// if it was embedded with that version (or its version is unknown), otherwise the previous namespace if that was,
+// so that services still running the old model keep serving until they are restarted with the new one.
+// It returns an empty string if neither matches.
+func (a *Alias) NamespaceFor(version string) string {
+	switch {
+	case a.ActiveVersion == "" || a.ActiveVersion == version:
+		return a.Active
+	case a.Previous != "" && a.PreviousVersion == version:
+		return a.Previous
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package vectordb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAliasSwapAndRollback(t *testing.T) {
+	alias := &Alias{Active: "objects", ActiveVersion: "clip-b (preprocess 1)"}
+	if err := alias.Rollback(); err == nil {
+		t.Fatal("expected no rollback before a swap")
+	}
+
+	alias.Swap("objects-2", "clip-l (preprocess 1)")
+	want := Alias{Active: "objects-2", ActiveVersion: "clip-l (preprocess 1)", Previous: "objects", PreviousVersion: "clip-b (preprocess 1)"}
+	if *alias != want {
+		t.Fatalf("after the swap got %+v, want %+v", *alias, want)
+	}
+
+	if err := alias.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	back := Alias{Active: "objects", ActiveVersion: "clip-b (preprocess 1)", Previous: "objects-2", PreviousVersion: "clip-l (preprocess 1)"}
+	if *alias != back {
+		t.Fatalf("after the rollback got %+v, want %+v", *alias, back)
+	}
+
+	/// Rolling back again rolls forward to the reindexed namespace
+	if err := alias.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if *alias != want {
+		t.Fatalf("after rolling forward got %+v, want %+v", *alias, want)
+	}
+
+	/// A second reindex replaces the rollback target
+	alias.Swap("objects-3", "siglip (preprocess 1)")
+	if alias.Active != "objects-3" || alias.Previous != "objects-2" || alias.PreviousVersion != "clip-l (preprocess 1)" {
+		t.Errorf("after a second swap got %+v", *alias)
+	}
+}
+
+func TestAliasNamespaceFor(t *testing.T) {
+	swapped := &Alias{Active: "new", ActiveVersion: "clip-l", Previous: "old", PreviousVersion: "clip-b"}
+	tests := []struct {
+		name    string
+		alias   *Alias
+		version string
+		want    string
+	}{
+		{"active version", swapped, "clip-l", "new"},
+		{"previous version", swapped, "clip-b", "old"},
+		{"neither version", swapped, "siglip", ""},
+		{"unknown active version", &Alias{Active: "new", Previous: "old", PreviousVersion: "clip-b"}, "clip-b", "new"},
+		{"no previous namespace", &Alias{Active: "new", ActiveVersion: "clip-l"}, "clip-b", ""},
+		{"previous with no version", &Alias{Active: "new", ActiveVersion: "clip-l", Previous: "old"}, "", "old"},
+		{"previous version without a namespace", &Alias{Active: "new", ActiveVersion: "clip-l", PreviousVersion: "clip-b"}, "clip-b", ""},
+	}
+	for _, test := range tests {
+		if got := test.alias.NamespaceFor(test.version); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadWriteAlias(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "alias.json")
+	alias, err := ReadAlias(file)
+	if alias != nil || err != nil {
+		t.Fatalf("expected no alias before it is written, got %+v %v", alias, err)
+	}
+	written := &Alias{Active: "new", ActiveVersion: "clip-l", Previous: "old", PreviousVersion: "clip-b"}
+	if err = WriteAlias(file, written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Updated.IsZero() {
+		t.Error("expected WriteAlias to set the update time")
+	}
+	alias, err = ReadAlias(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !alias.Updated.Equal(written.Updated) {
+		t.Errorf("got updated %s, want %s", alias.Updated, written.Updated)
+	}
+	alias.Updated = written.Updated
+	if *alias != *written {
+		t.Errorf("read %+v, want %+v", *alias, *written)
+	}
+	if matches, _ := filepath.Glob(file + ".*"); len(matches) > 0 {
+		t.Errorf("temp files left behind: %v", matches)
+	}
+
+	if err = WriteAlias(file, &Alias{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadAlias(file); err == nil {
+		t.Error("expected an alias with no active namespace to be refused")
+	}
+}
@@ -0,0 +1,250 @@
+package vectordb
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// VectorStore is the part of PineconeDB the service needs, so that it can run against a MemoryDB in tests
+type VectorStore interface {
+	UpsertVector(vectorValues []float32, vectorID string, metadata map[string]interface{}) error
+	SearchVectorsFiltered(queryVector []float32, topK uint32, filter map[string]interface{}) ([]SearchResult, error)
+	FetchVectors(ids []string) (map[string]StoredVector, error)
+	DeleteVectors(ids []string) error
+	ListIDs(prefix string, limit uint32, token string) ([]string, string, error)
+	VectorCount() (uint32, error)
+	IndexDimension() (uint32, error)
+}
+
+// MemoryDB keeps the vectors of one namespace in memory. Metadata goes through the same conversion as Pinecone's,
+// so numbers come back as float64 and lists as []interface{}, and searches score by cosine similarity.
+// Filters support $eq, $ne, $in, $nin, $exists, $and and $or, the operators the service uses.
+type MemoryDB struct {
+	mu      sync.Mutex
+	vectors map[string]StoredVector
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{vectors: make(map[string]StoredVector)}
+}
+
+func (m *MemoryDB) UpsertVector(vectorValues []float32, vectorID string, metadata map[string]interface{}) error {
+	stored := StoredVector{ID: vectorID, Values: append([]float32{}, vectorValues...)}
+	if metadata != nil {
+		metadataStruct, err := structpb.NewStruct(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata struct: %v", err)
+		}
+		stored.Metadata = metadataStruct.AsMap()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.vectors {
+		if len(other.Values) != len(vectorValues) {
+			return fmt.Errorf("vector dimension %d does not match the dimension of the index %d", len(vectorValues), len(other.Values))
+		}
+		break /// they all have the index's dimension
+	}
+	m.vectors[vectorID] = stored
+	return nil
+}
+
+func (m *MemoryDB) SearchVectorsFiltered(queryVector []float32, topK uint32, filter map[string]interface{}) ([]SearchResult, error) {
+	if filter != nil {
+		filterStruct, err := structpb.NewStruct(filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metadata filter: %v", err)
+		}
+		filter = filterStruct.AsMap()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make([]SearchResult, 0)
+	for _, vector := range m.vectors {
+		ok, err := matchesFilter(vector.Metadata, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			results = append(results, SearchResult{ID: vector.ID, Score: cosine(queryVector, vector.Values), Metadata: vector.Metadata})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > int(topK) {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+func (m *MemoryDB) FetchVectors(ids []string) (map[string]StoredVector, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	vectors := make(map[string]StoredVector)
+	for _, id := range ids {
+		if vector, ok := m.vectors[id]; ok {
+			vectors[id] = vector
+		}
+	}
+	return vectors, nil
+}
+
+func (m *MemoryDB) DeleteVectors(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		delete(m.vectors, id)
+	}
+	return nil
+}
+
+// ListIDs returns the IDs with the prefix in order, the token is the offset of the next page
+func (m *MemoryDB) ListIDs(prefix string, limit uint32, token string) ([]string, string, error) {
+	offset := 0
+	if token != "" {
+		var err error
+		if offset, err = strconv.Atoi(token); err != nil {
+			return nil, "", fmt.Errorf("invalid pagination token %s", token)
+		}
+	}
+	m.mu.Lock()
+	ids := make([]string, 0)
+	for id := range m.vectors {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(ids)
+	if offset >= len(ids) {
+		return []string{}, "", nil
+	}
+	end := min(offset+int(limit), len(ids))
+	next := ""
+	if end < len(ids) {
+		next = strconv.Itoa(end)
+	}
+	return ids[offset:end], next, nil
+}
+
+func (m *MemoryDB) VectorCount() (uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint32(len(m.vectors)), nil
+}
+
+func (m *MemoryDB) IndexDimension() (uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, vector := range m.vectors {
+		return uint32(len(vector.Values)), nil
+	}
+	return 0, nil
+}
+
+// matchesFilter applies a Pinecone metadata filter, a nil filter matches everything
+func matchesFilter(metadata, filter map[string]interface{}) (bool, error) {
+	for key, condition := range filter {
+		switch key {
+		case "$and", "$or":
+			clauses, ok := condition.([]interface{})
+			if !ok {
+				return false, fmt.Errorf("%s needs a list of filters", key)
+			}
+			matched := 0
+			for _, clause := range clauses {
+				sub, ok := clause.(map[string]interface{})
+				if !ok {
+					return false, fmt.Errorf("%s needs a list of filters", key)
+				}
+				ok, err := matchesFilter(metadata, sub)
+				if err != nil {
+					return false, err
+				}
+				if ok {
+					matched++
+				}
+			}
+			if (key == "$and" && matched < len(clauses)) || (key == "$or" && matched == 0) {
+				return false, nil
+			}
+		default:
+			operators, ok := condition.(map[string]interface{})
+			if !ok {
+				operators = map[string]interface{}{"$eq": condition}
+			}
+			for operator, arg := range operators {
+				ok, err := matchesOperator(metadata, key, operator, arg)
+				if err != nil || !ok {
+					return false, err
+				}
+			}
+		}
+	}
+	return true, nil
+}
+
+func matchesOperator(metadata map[string]interface{}, key, operator string, arg interface{}) (bool, error) {
+	value, exists := metadata[key]
+	switch operator {
+	case "$exists":
+		want, ok := arg.(bool)
+		if !ok {
+			return false, fmt.Errorf("$exists needs true or false")
+		}
+		return exists == want, nil
+	case "$eq", "$ne":
+		return exists && hasValue(value, arg) == (operator == "$eq"), nil
+	case "$in", "$nin":
+		args, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("%s needs a list", operator)
+		}
+		found := false
+		for _, a := range args {
+			found = found || hasValue(value, a)
+		}
+		return exists && found == (operator == "$in"), nil
+	}
+	return false, fmt.Errorf("unsupported filter operator %s", operator)
+}
+
+// hasValue is true if the metadata value is arg, or is a list containing it
+func hasValue(value, arg interface{}) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, element := range list {
+			if reflect.DeepEqual(element, arg) {
+				return true
+			}
+		}
+		return false
+	}
+	return reflect.DeepEqual(value, arg)
+}
+
+func cosine(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	dot, na, nb := 0.0, 0.0, 0.0
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(na) * math.Sqrt(nb)))
+}
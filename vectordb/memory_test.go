@@ -0,0 +1,111 @@
+package vectordb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMemoryDBFilters(t *testing.T) {
+	db := NewMemoryDB()
+	vectors := []struct {
+		id       string
+		metadata map[string]interface{}
+	}{
+		{"text-cat", map[string]interface{}{"value": "cat", "kind": "text", "model_id": "clip"}},
+		{"img-cat", map[string]interface{}{"value": "cat", "kind": "image", "model_id": "clip", "tags": []interface{}{"pet", "indoor"}}},
+		{"img-dog", map[string]interface{}{"value": "dog", "kind": "image", "model_id": "siglip", "dimension": 2}},
+		{"img-old", map[string]interface{}{"value": "dog"}},
+	}
+	for _, vector := range vectors {
+		if err := db.UpsertVector([]float32{1, 0}, vector.id, vector.metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		filter  map[string]interface{}
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, []string{"img-cat", "img-dog", "img-old", "text-cat"}, false},
+		{"eq", map[string]interface{}{"kind": map[string]interface{}{"$eq": "image"}}, []string{"img-cat", "img-dog"}, false},
+		{"shorthand eq", map[string]interface{}{"value": "dog"}, []string{"img-dog", "img-old"}, false},
+		{"int matches float", map[string]interface{}{"dimension": map[string]interface{}{"$eq": 2}}, []string{"img-dog"}, false},
+		{"list contains", map[string]interface{}{"tags": map[string]interface{}{"$eq": "pet"}}, []string{"img-cat"}, false},
+		{"ne skips missing", map[string]interface{}{"model_id": map[string]interface{}{"$ne": "clip"}}, []string{"img-dog"}, false},
+		{"in", map[string]interface{}{"value": map[string]interface{}{"$in": []interface{}{"cat", "bird"}}}, []string{"img-cat", "text-cat"}, false},
+		{"nin", map[string]interface{}{"value": map[string]interface{}{"$nin": []interface{}{"cat"}}}, []string{"img-dog", "img-old"}, false},
+		{"exists", map[string]interface{}{"model_id": map[string]interface{}{"$exists": false}}, []string{"img-old"}, false},
+		{"and", map[string]interface{}{"$and": []interface{}{
+			map[string]interface{}{"kind": map[string]interface{}{"$eq": "image"}},
+			map[string]interface{}{"value": map[string]interface{}{"$eq": "dog"}},
+		}}, []string{"img-dog"}, false},
+		{"or", map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"model_id": map[string]interface{}{"$eq": "clip"}},
+			map[string]interface{}{"model_id": map[string]interface{}{"$exists": false}},
+		}}, []string{"img-cat", "img-old", "text-cat"}, false},
+		{"unsupported operator", map[string]interface{}{"value": map[string]interface{}{"$regex": "c.*"}}, nil, true},
+	}
+	for _, test := range tests {
+		results, err := db.SearchVectorsFiltered([]float32{1, 0}, 10, test.filter)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got := make([]string, 0)
+		for _, result := range results {
+			got = append(got, result.ID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMemoryDBSearchAndList(t *testing.T) {
+	db := NewMemoryDB()
+	for id, vector := range map[string][]float32{"img-a": {1, 0}, "img-b": {1, 1}, "img-c": {0, 1}, "text-a": {-1, 0}} {
+		if err := db.UpsertVector(vector, id, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.UpsertVector([]float32{1, 2, 3}, "img-d", nil); err == nil {
+		t.Error("expected a vector of another dimension to be refused")
+	}
+	results, err := db.SearchVectorsFiltered([]float32{1, 0}, 2, nil)
+	if err != nil || len(results) != 2 || results[0].ID != "img-a" || results[1].ID != "img-b" {
+		t.Errorf("expected the 2 nearest, got %+v %v", results, err)
+	}
+
+	ids := make([]string, 0)
+	token := ""
+	for pages := 1; ; pages++ {
+		page, next, err := db.ListIDs("img-", 2, token)
+		if err != nil || pages > 2 {
+			t.Fatalf("unexpected page %d %v %v", pages, page, err)
+		}
+		ids = append(ids, page...)
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if !reflect.DeepEqual(ids, []string{"img-a", "img-b", "img-c"}) {
+		t.Errorf("listed %v", ids)
+	}
+
+	if err = db.DeleteVectors([]string{"img-a", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	vectors, _ := db.FetchVectors([]string{"img-a", "img-b"})
+	count, _ := db.VectorCount()
+	dimension, _ := db.IndexDimension()
+	if _, ok := vectors["img-a"]; ok || len(vectors) != 1 || count != 3 || dimension != 2 {
+		t.Errorf("after deleting got %v, %d vectors of dimension %d", vectors, count, dimension)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"google.golang.org/protobuf/types/known/structpb"
 	"log"
 	"net/http"
+	"sync"
 )
 
 type PineconeDB struct {
@@ -14,6 +15,7 @@ type PineconeDB struct {
 	apiKey    string
 	namespace string
 	client    *http.Client
+	mu        sync.RWMutex /// guards namespace, which can be switched while serving
 }
 
 func NewPineconeDB(host string,
@@ -35,32 +37,35 @@ func NewPineconeDB(host string,
 	}
 }
 
+// Namespace returns the namespace the vectors are currently read from and written to
+func (p *PineconeDB) Namespace() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.namespace
+}
+
+// SetNamespace switches every following call to another namespace of the same index
+func (p *PineconeDB) SetNamespace(namespace string) {
+	if namespace == "" {
+		log.Panicln("Namespace cannot be empty")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.namespace = namespace
+}
+
 // UpsertVector uploads a single vector to Pinecone DB
 func (p *PineconeDB) UpsertVector(
 	vectorValues []float32,
 	vectorID string,
 	metadata map[string]interface{},
 ) error {
-	// Create context
 	ctx := context.Background()
-
-	// Initialize Pinecone client
-	pc, err := pinecone.NewClient(pinecone.NewClientParams{
-		ApiKey:     p.apiKey,
-		RestClient: p.client, ///// Turn HTTP errors into actual errors so that the SDK doesn't ignore them
-	})
+	idxConnection, err := p.indexConnection()
 	if err != nil {
-		return fmt.Errorf("failed to create Pinecone client: %v", err)
-	}
-
-	// Create index connection
-	idxConnection, err := pc.Index(pinecone.NewIndexConnParams{
-		Host:      p.host,
-		Namespace: p.namespace,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create index connection: %v", err)
+		return err
 	}
+	defer idxConnection.Close()
 	// Convert metadata to structpb
 	var metadataStruct *structpb.Struct
 	if metadata != nil {
@@ -98,27 +103,35 @@ func (p *PineconeDB) SearchVectors(
 	queryVector []float32,
 	topK uint32,
 ) ([]SearchResult, error) {
-	// Create context
-	ctx := context.Background()
-	// Initialize Pinecone client
-	pc, err := pinecone.NewClient(pinecone.NewClientParams{
-		ApiKey: p.apiKey,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Pinecone client: %v", err)
+	return p.SearchVectorsFiltered(queryVector, topK, nil)
+}
+
+// SearchVectorsFiltered performs a similarity search restricted to vectors whose metadata matches the filter,
+// e.g. {"kind": {"$eq": "image"}}. A nil filter searches everything.
+func (p *PineconeDB) SearchVectorsFiltered(
+	queryVector []float32,
+	topK uint32,
+	filter map[string]interface{},
+) ([]SearchResult, error) {
+	var metadataFilter *pinecone.MetadataFilter
+	if filter != nil {
+		var err error
+		metadataFilter, err = structpb.NewStruct(filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metadata filter: %v", err)
+		}
 	}
-	// Create index connection
-	idxConnection, err := pc.Index(pinecone.NewIndexConnParams{
-		Host:      p.host,
-		Namespace: p.namespace,
-	})
+	ctx := context.Background()
+	idxConnection, err := p.indexConnection()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create index connection: %v", err)
+		return nil, err
 	}
+	defer idxConnection.Close()
 	// Perform the query
 	queryResponse, err := idxConnection.QueryByVectorValues(ctx, &pinecone.QueryByVectorValuesRequest{
 		Vector:          queryVector,
 		TopK:            topK,
+		MetadataFilter:  metadataFilter,
 		IncludeValues:   false,
 		IncludeMetadata: true,
 	})
@@ -141,3 +154,149 @@ func (p *PineconeDB) SearchVectors(
 	}
 	return results, nil
 }
+
+// indexConnection opens a connection to the configured index and namespace
+func (p *PineconeDB) indexConnection() (*pinecone.IndexConnection, error) {
+	pc, err := pinecone.NewClient(pinecone.NewClientParams{
+		ApiKey:     p.apiKey,
+		RestClient: p.client, ///// Turn HTTP errors into actual errors so that the SDK doesn't ignore them
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pinecone client: %v", err)
+	}
+	idxConnection, err := pc.Index(pinecone.NewIndexConnParams{
+		Host:      p.host,
+		Namespace: p.Namespace(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create index connection: %v", err)
+	}
+	return idxConnection, nil
+}
+
+// VectorCount returns the number of vectors currently stored in the namespace
+func (p *PineconeDB) VectorCount() (uint32, error) {
+	idxConnection, err := p.indexConnection()
+	if err != nil {
+		return 0, err
+	}
+	defer idxConnection.Close()
+	stats, err := idxConnection.DescribeIndexStats(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe index stats: %v", err)
+	}
+	summary, ok := stats.Namespaces[p.Namespace()]
+	if !ok {
+		return 0, nil
+	}
+	return summary.VectorCount, nil
+}
+
+// DeleteNamespace removes every vector in the namespace
+func (p *PineconeDB) DeleteNamespace() error {
+	idxConnection, err := p.indexConnection()
+	if err != nil {
+		return err
+	}
+	defer idxConnection.Close()
+	err = idxConnection.DeleteAllVectorsInNamespace(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to delete namespace %s: %v", p.Namespace(), err)
+	}
+	return nil
+}
+
+// DeleteVectors removes the vectors with the IDs, IDs that don't exist are ignored
+func (p *PineconeDB) DeleteVectors(ids []string) error {
+	idxConnection, err := p.indexConnection()
+	if err != nil {
+		return err
+	}
+	defer idxConnection.Close()
+	err = idxConnection.DeleteVectorsById(context.Background(), ids)
+	if err != nil {
+		return fmt.Errorf("failed to delete vectors: %v", err)
+	}
+	return nil
+}
+
+// IndexDimension returns the dimension of the vectors the index holds
+func (p *PineconeDB) IndexDimension() (uint32, error) {
+	idxConnection, err := p.indexConnection()
+	if err != nil {
+		return 0, err
+	}
+	defer idxConnection.Close()
+	stats, err := idxConnection.DescribeIndexStats(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe index stats: %v", err)
+	}
+	if stats.Dimension == nil {
+		return 0, nil
+	}
+	return *stats.Dimension, nil
+}
+
+// ListIDs returns a page of up to limit vector IDs with the prefix, and the token for the next page.
+// The token is empty on the last page.
+func (p *PineconeDB) ListIDs(prefix string, limit uint32, token string) ([]string, string, error) {
+	idxConnection, err := p.indexConnection()
+	if err != nil {
+		return nil, "", err
+	}
+	defer idxConnection.Close()
+	req := &pinecone.ListVectorsRequest{
+		Prefix: &prefix,
+		Limit:  &limit,
+	}
+	if token != "" {
+		req.PaginationToken = &token
+	}
+	resp, err := idxConnection.ListVectors(context.Background(), req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list vectors: %v", err)
+	}
+	ids := make([]string, 0, len(resp.VectorIds))
+	for _, id := range resp.VectorIds {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	next := ""
+	if resp.NextPaginationToken != nil {
+		next = *resp.NextPaginationToken
+	}
+	return ids, next, nil
+}
+
+// StoredVector is a vector fetched by ID
+type StoredVector struct {
+	ID       string
+	Values   []float32
+	Metadata map[string]interface{}
+}
+
+// FetchVectors returns the stored vectors with the IDs, IDs that don't exist are missing from the result
+func (p *PineconeDB) FetchVectors(ids []string) (map[string]StoredVector, error) {
+	idxConnection, err := p.indexConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer idxConnection.Close()
+	resp, err := idxConnection.FetchVectors(context.Background(), ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch vectors: %v", err)
+	}
+	vectors := make(map[string]StoredVector)
+	for id, vector := range resp.Vectors {
+		stored := StoredVector{ID: id}
+		if vector.Values != nil {
+			stored.Values = *vector.Values
+		}
+		if vector.Metadata != nil {
+			stored.Metadata = vector.Metadata.AsMap()
+		}
+		vectors[id] = stored
+	}
+	return vectors, nil
+}
@@ -0,0 +1,305 @@
+package webfront
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"html/template"
+	"net/http"
+	"net/url"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/middleware"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+The admin UI lists the uploads in the upload manifest, so items imported from the command line aren't shown.
+Every page needs one of the AUTH_TOKENS, which the login page keeps in a cookie, the same one the thumbnails accept.
+*/
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+const (
+	ADMIN_PAGE_SIZE = 24
+	ADMIN_SESSION   = 12 * time.Hour
+	/// How long the manifest is cached between page views, another replica's uploads show up after this
+	ADMIN_ENTRIES_TTL = 30 * time.Second
+)
+
+// adminEntries caches the manifest, which is read an object at a time
+type adminEntries struct {
+	mu      sync.Mutex
+	entries []uploads.Entry
+	read    time.Time
+}
+
+func parseTemplates() *template.Template {
+	return template.Must(template.New("").Funcs(template.FuncMap{
+		"thumb":  ThumbnailURL,
+		"itemID": service.ItemID,
+		"inc":    func(i int) int { return i + 1 },
+	}).ParseFS(templateFS, "templates/*.html"))
+}
+
+func (h *Handler) registerAdmin() {
+	h.templates = parseTemplates()
+
+	throttleLogin := middleware.NewThrottleMiddleware(20, 1)
+	http.HandleFunc("GET /admin/login", h.HandleAdminLogin)
+	http.HandleFunc("POST /admin/login", throttleLogin.Wrap(h.HandleAdminLogin))
+	http.HandleFunc("POST /admin/logout", h.HandleAdminLogout)
+	http.HandleFunc("GET /admin", h.admin(h.HandleAdminItems))
+	http.HandleFunc("POST /admin/items/{id}/label", h.admin(h.HandleAdminRelabel))
+	http.HandleFunc("POST /admin/items/{id}/delete", h.admin(h.HandleAdminDelete))
+	http.HandleFunc("/admin/detect", h.admin(h.HandleAdminDetect))
+}
+
+// admin sends requests without a token to the login page
+func (h *Handler) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.auth.Allowed(r) {
+			next(w, r)
+			return
+		}
+		if r.Method == http.MethodGet {
+			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+func (h *Handler) render(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.templates.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Println("Error rendering ", name, err)
+	}
+}
+
+func (h *Handler) HandleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	if r.Method == http.MethodGet {
+		h.render(w, http.StatusOK, "login.html", map[string]string{})
+		return
+	}
+	if !h.auth.Valid(r.FormValue("token")) {
+		h.render(w, http.StatusUnauthorized, "login.html", map[string]string{"Error": "Unknown token"})
+		return
+	}
+	/// Path / so the thumbnails get the cookie too, SameSite so other sites can't post the admin forms
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.AUTH_COOKIE,
+		Value:    r.FormValue("token"),
+		Path:     "/",
+		MaxAge:   int(ADMIN_SESSION.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (h *Handler) HandleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.AUTH_COOKIE,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+// manifestEntries returns the cached manifest entries, newest first
+func (h *Handler) manifestEntries() ([]uploads.Entry, error) {
+	h.entries.mu.Lock()
+	defer h.entries.mu.Unlock()
+	if h.entries.entries != nil && time.Since(h.entries.read) < ADMIN_ENTRIES_TTL {
+		return h.entries.entries, nil
+	}
+	entries, err := h.manifest.Entries()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	h.entries.entries, h.entries.read = entries, time.Now()
+	return entries, nil
+}
+
+// forgetEntries makes the next page view read the manifest again
+func (h *Handler) forgetEntries() {
+	h.entries.mu.Lock()
+	defer h.entries.mu.Unlock()
+	h.entries.entries = nil
+}
+
+type adminItemsPage struct {
+	Items  []uploads.Entry
+	Query  string
+	Total  int
+	Page   int
+	Pages  int
+	Return string /// this page, to come back to after a change
+}
+
+func (p *adminItemsPage) PrevPage() int { return p.Page - 1 }
+func (p *adminItemsPage) NextPage() int { return p.Page + 1 }
+
+func (p *adminItemsPage) PageURL(page int) string {
+	query := url.Values{}
+	if p.Query != "" {
+		query.Set("q", p.Query)
+	}
+	query.Set("page", strconv.Itoa(page))
+	return "/admin?" + query.Encode()
+}
+
+// HandleAdminItems shows a page of uploads, newest first, filtered by q
+func (h *Handler) HandleAdminItems(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	entries, err := h.manifestEntries()
+	handlers.PanicOnError(err)
+	page := &adminItemsPage{Query: strings.TrimSpace(r.FormValue("q")), Page: 1}
+	matches := entries
+	if page.Query != "" {
+		query := strings.ToLower(page.Query)
+		matches = make([]uploads.Entry, 0)
+		for _, entry := range entries {
+			if strings.Contains(strings.ToLower(entry.Label), query) || strings.Contains(entry.ID, query) ||
+				strings.Contains(strings.ToLower(entry.OriginalFilename), query) {
+				matches = append(matches, entry)
+			}
+		}
+	}
+	page.Total = len(matches)
+	page.Pages = max(1, (len(matches)+ADMIN_PAGE_SIZE-1)/ADMIN_PAGE_SIZE)
+	if p, err := strconv.Atoi(r.FormValue("page")); err == nil {
+		page.Page = min(max(p, 1), page.Pages)
+	}
+	start := (page.Page - 1) * ADMIN_PAGE_SIZE
+	page.Items = matches[start:min(start+ADMIN_PAGE_SIZE, len(matches))]
+	page.Return = page.PageURL(page.Page)
+	h.render(w, http.StatusOK, "items.html", page)
+}
+
+// returnURL is the admin page to go back to after a change
+func returnURL(r *http.Request) string {
+	back := r.FormValue("return")
+	if !strings.HasPrefix(back, "/admin") {
+		return "/admin"
+	}
+	return back
+}
+
+// HandleAdminRelabel embeds the new label of an upload, see service.Handler.Relabel
+func (h *Handler) HandleAdminRelabel(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	id := r.PathValue("id")
+	label := strings.TrimSpace(r.FormValue("label"))
+	if label == "" {
+		http.Error(w, "The label is required", http.StatusBadRequest)
+		return
+	}
+	entry, err := h.manifest.Find(id)
+	handlers.PanicOnError(err)
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if label != entry.Label {
+		err = h.svc.Relabel(id, label)
+		switch {
+		case errors.Is(err, service.ErrItemNotFound):
+			http.Error(w, "The item has no vectors, rebuild the index with -rebuild", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrVersionMismatch):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		handlers.PanicOnError(err)
+		entry.Label = label
+		err = h.manifest.Record(*entry)
+		handlers.PanicOnError(err)
+		h.forgetEntries()
+	}
+	http.Redirect(w, r, returnURL(r), http.StatusSeeOther)
+}
+
+// HandleAdminDelete deletes the vectors, image and thumbnails of an upload
+func (h *Handler) HandleAdminDelete(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	id := r.PathValue("id")
+	entry, err := h.manifest.Find(id)
+	handlers.PanicOnError(err)
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+	handlers.PanicOnError(h.svc.DeleteItem(id))
+	err = h.store.Blobs().Delete(entry.File)
+	if err != nil && !errors.Is(err, blob.ErrNotFound) {
+		handlers.PanicOnError(err)
+	}
+	handlers.PanicOnError(h.store.DeleteThumbnails(id))
+	/// Last, so a failure above leaves the entry to delete again
+	handlers.PanicOnError(h.manifest.Delete(id))
+	h.forgetEntries()
+	fmt.Println("Deleted upload ", id, entry.Label)
+	http.Redirect(w, r, returnURL(r), http.StatusSeeOther)
+}
+
+type adminDetectPage struct {
+	K        int
+	MinScore float32
+	Result   *DectionResponse
+}
+
+// HandleAdminDetect shows the k best labels and the nearest vectors of an image, it isn't rate limited
+func (h *Handler) HandleAdminDetect(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	page := &adminDetectPage{K: DEFAULT_DETECT_LABELS}
+	if r.Method != http.MethodPost {
+		h.render(w, http.StatusOK, "detect.html", page)
+		return
+	}
+	file, _, info, ok := h.readImage(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	params, err := parseDetectParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params.includeMatches = true
+	page.K, page.MinScore = params.labels, params.minScore
+	obj, err := h.store.Save(uploads.CATEGORY_QUERIES, file, info.Ext)
+	if err != nil {
+		fmt.Println("Failed to store upload ", err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+	defer obj.Release()
+	results, err := h.svc.Detect(obj.File, service.DetectOptions{TopK: params.topK(), MinScore: params.minScore})
+	h.discard(h.queries, obj)
+	handlers.PanicOnError(err)
+	resp := detectionResponse(results, params)
+	page.Result = &resp
+	h.render(w, http.StatusOK, "detect.html", page)
+}
@@ -0,0 +1,350 @@
+package webfront
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/embedding"
+	"object-detection-zero-shot/middleware"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// labelClient embeds every text as the same vector, and an image as another
+type labelClient struct{}
+
+func (labelClient) Do(payload *embedding.RequestPayload) (map[string]interface{}, error) {
+	if len(payload.Inputs.Candidates) == 0 {
+		return map[string]interface{}{"embeddings": []any{[]any{1.0, 0.0}}}, nil
+	}
+	rows := make([]any, 0, len(payload.Inputs.Candidates))
+	for range payload.Inputs.Candidates {
+		rows = append(rows, []any{0.0, 1.0})
+	}
+	return map[string]interface{}{"embeddings": rows}, nil
+}
+
+// adminHandler returns a handler with n uploads item00, item01... labelled cat and dog in turn, oldest first,
+// each with its vectors, file and thumbnail
+func adminHandler(t *testing.T, n int) (*Handler, *vectordb.MemoryDB) {
+	blobs := blob.NewLocalStore(t.TempDir())
+	db := vectordb.NewMemoryDB()
+	h := &Handler{
+		svc:        service.NewHandler(labelClient{}, db),
+		store:      uploads.NewStore(blobs, t.TempDir()),
+		manifest:   uploads.NewManifest(blobs),
+		thumbnails: uploads.DefaultThumbnailConfig(),
+		auth:       middleware.NewTokenAuth([]string{"secret"}),
+		templates:  parseTemplates(),
+	}
+	created := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		entry := uploads.Entry{
+			ID:               fmt.Sprintf("item%02d", i),
+			File:             fmt.Sprintf("indexed/item%02d.jpg", i),
+			OriginalFilename: fmt.Sprintf("photo%02d.jpg", i),
+			Label:            []string{"cat", "dog"}[i%2],
+			VectorIDs:        service.VectorIDs(fmt.Sprintf("item%02d", i)),
+			Created:          created.Add(time.Duration(i) * time.Minute),
+		}
+		if err := h.manifest.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{entry.File, uploads.ThumbnailKey(entry.ID, 256, uploads.THUMBNAIL_JPEG)} {
+			if err := blobs.Put(key, strings.NewReader("image")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, id := range entry.VectorIDs {
+			if err := db.UpsertVector([]float32{1, 0}, id, map[string]interface{}{service.METADATA_VALUE: entry.Label}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return h, db
+}
+
+func withToken(r *http.Request) *http.Request {
+	r.AddCookie(&http.Cookie{Name: middleware.AUTH_COOKIE, Value: "secret"})
+	return r
+}
+
+func form(method, target string, values url.Values) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestAdminAuth(t *testing.T) {
+	h, _ := adminHandler(t, 1)
+	page := h.admin(h.HandleAdminItems)
+	tests := []struct {
+		name         string
+		request      *http.Request
+		wantStatus   int
+		wantLocation string
+	}{
+		{"page with the cookie", withToken(httptest.NewRequest(http.MethodGet, "/admin", nil)), http.StatusOK, ""},
+		{"page with a bearer token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			return r
+		}(), http.StatusOK, ""},
+		{"page without a token", httptest.NewRequest(http.MethodGet, "/admin", nil), http.StatusSeeOther, "/admin/login"},
+		{"page with an unknown token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+			r.AddCookie(&http.Cookie{Name: middleware.AUTH_COOKIE, Value: "guess"})
+			return r
+		}(), http.StatusSeeOther, "/admin/login"},
+		{"form without a token", form(http.MethodPost, "/admin/items/item00/delete", url.Values{}), http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			page(w, test.request)
+			if w.Code != test.wantStatus || w.Header().Get("Location") != test.wantLocation {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), test.wantStatus, test.wantLocation)
+			}
+		})
+	}
+}
+
+func TestHandleAdminLogin(t *testing.T) {
+	h, _ := adminHandler(t, 0)
+	tests := []struct {
+		name       string
+		request    *http.Request
+		wantStatus int
+		wantCookie string /// "" for none
+	}{
+		{"login page", httptest.NewRequest(http.MethodGet, "/admin/login", nil), http.StatusOK, ""},
+		{"known token", form(http.MethodPost, "/admin/login", url.Values{"token": {"secret"}}), http.StatusSeeOther, "secret"},
+		{"unknown token", form(http.MethodPost, "/admin/login", url.Values{"token": {"guess"}}), http.StatusUnauthorized, ""},
+		{"no token", form(http.MethodPost, "/admin/login", url.Values{}), http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.HandleAdminLogin(w, test.request)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, test.wantStatus)
+			}
+			cookies := w.Result().Cookies()
+			if test.wantCookie == "" {
+				if len(cookies) > 0 {
+					t.Errorf("expected no cookie, got %v", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 {
+				t.Fatalf("expected the token cookie, got %v", cookies)
+			}
+			cookie := cookies[0]
+			if cookie.Name != middleware.AUTH_COOKIE || cookie.Value != test.wantCookie || cookie.Path != "/" ||
+				!cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != int(ADMIN_SESSION.Seconds()) {
+				t.Errorf("unexpected cookie %+v", cookie)
+			}
+			if w.Header().Get("Location") != "/admin" {
+				t.Errorf("expected a redirect to /admin, got %q", w.Header().Get("Location"))
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	h.HandleAdminLogout(w, withToken(form(http.MethodPost, "/admin/logout", url.Values{})))
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != middleware.AUTH_COOKIE || cookies[0].MaxAge != -1 {
+		t.Errorf("expected logout to clear the cookie, got %v", cookies)
+	}
+}
+
+// listedItems returns the IDs of the items on an admin page, in order
+func listedItems(body string) []string {
+	ids := make([]string, 0)
+	for _, part := range strings.Split(body, `action="/admin/items/`)[1:] {
+		if id, found := strings.CutSuffix(part[:strings.Index(part, `"`)], "/label"); found {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func itemRange(from, to int) []string {
+	ids := make([]string, 0)
+	for i := from; i >= to; i-- {
+		ids = append(ids, fmt.Sprintf("item%02d", i))
+	}
+	return ids
+}
+
+func TestHandleAdminItems(t *testing.T) {
+	h, _ := adminHandler(t, 30)
+	tests := []struct {
+		query     string
+		want      []string /// newest first
+		wantPager string   /// "" if there is one page
+	}{
+		{"", itemRange(29, 6), "Page 1 of 2"},
+		{"page=2", itemRange(5, 0), "Page 2 of 2"},
+		{"page=9", itemRange(5, 0), "Page 2 of 2"},
+		{"page=0", itemRange(29, 6), "Page 1 of 2"},
+		{"page=next", itemRange(29, 6), "Page 1 of 2"},
+		{"q=+DOG+", []string{"item29", "item27", "item25", "item23", "item21", "item19", "item17", "item15", "item13", "item11", "item09", "item07", "item05", "item03", "item01"}, ""},
+		{"q=item1", itemRange(19, 10), ""},
+		{"q=PHOTO03", []string{"item03"}, ""},
+		{"q=zebra&page=2", []string{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.HandleAdminItems(w, withToken(httptest.NewRequest(http.MethodGet, "/admin?"+test.query, nil)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got %d %s", w.Code, w.Body)
+			}
+			body := w.Body.String()
+			if got := listedItems(body); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+			if test.wantPager != "" && !strings.Contains(body, test.wantPager) || test.wantPager == "" && strings.Contains(body, "pagination") {
+				t.Errorf("expected pager %q", test.wantPager)
+			}
+		})
+	}
+}
+
+func TestReturnURL(t *testing.T) {
+	tests := []struct {
+		back string
+		want string
+	}{
+		{"/admin?q=cat&page=2", "/admin?q=cat&page=2"},
+		{"/admin", "/admin"},
+		{"", "/admin"},
+		{"https://example.com/admin", "/admin"},
+		{"//example.com/admin", "/admin"},
+		{"/search?q=cat", "/admin"},
+		{"javascript:alert(1)", "/admin"},
+	}
+	for _, test := range tests {
+		r := form(http.MethodPost, "/admin/items/a/delete", url.Values{"return": {test.back}})
+		if got := returnURL(r); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.back, got, test.want)
+		}
+	}
+}
+
+// adminPost posts the form to the item's action, as the admin wrapper would let it through
+func adminPost(h *Handler, handler http.HandlerFunc, id, action string, values url.Values) *httptest.ResponseRecorder {
+	r := withToken(form(http.MethodPost, "/admin/items/"+id+"/"+action, values))
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	h.admin(handler)(w, r)
+	return w
+}
+
+func TestHandleAdminRelabel(t *testing.T) {
+	h, db := adminHandler(t, 3)
+	if err := db.DeleteVectors(service.VectorIDs("item02")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		id           string
+		label        string
+		back         string
+		wantStatus   int
+		wantLocation string
+		wantLabel    string /// of the item afterwards, in the manifest and the vectors
+	}{
+		{"new label", "item00", " kitten ", "/admin?page=1", http.StatusSeeOther, "/admin?page=1", "kitten"},
+		{"the same label", "item01", "dog", "", http.StatusSeeOther, "/admin", "dog"},
+		{"elsewhere after", "item01", "puppy", "https://example.com/", http.StatusSeeOther, "/admin", "puppy"},
+		{"no label", "item01", "  ", "", http.StatusBadRequest, "", "puppy"},
+		{"unknown item", "item09", "cat", "", http.StatusNotFound, "", ""},
+		{"no vectors", "item02", "bird", "", http.StatusNotFound, "", "cat"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			/// Views the page first, so a stale cached manifest would show
+			h.HandleAdminItems(httptest.NewRecorder(), withToken(httptest.NewRequest(http.MethodGet, "/admin", nil)))
+			w := adminPost(h, h.HandleAdminRelabel, test.id, "label", url.Values{"label": {test.label}, "return": {test.back}})
+			if w.Code != test.wantStatus || w.Header().Get("Location") != test.wantLocation {
+				t.Fatalf("got %d to %q %s, want %d to %q", w.Code, w.Header().Get("Location"), w.Body, test.wantStatus, test.wantLocation)
+			}
+			entry, err := h.manifest.Find(test.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantLabel == "" {
+				if entry != nil {
+					t.Errorf("expected no entry, got %+v", entry)
+				}
+				return
+			}
+			if entry.Label != test.wantLabel || entry.File != "indexed/"+test.id+".jpg" {
+				t.Errorf("got entry %+v, want label %s", entry, test.wantLabel)
+			}
+			vectors, err := db.FetchVectors(service.VectorIDs(test.id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for id, vector := range vectors {
+				if vector.Metadata[service.METADATA_VALUE] != test.wantLabel {
+					t.Errorf("%s is labelled %v, want %s", id, vector.Metadata[service.METADATA_VALUE], test.wantLabel)
+				}
+			}
+			page := httptest.NewRecorder()
+			h.HandleAdminItems(page, withToken(httptest.NewRequest(http.MethodGet, "/admin?q="+test.id, nil)))
+			if !strings.Contains(page.Body.String(), `value="`+test.wantLabel+`"`) {
+				t.Errorf("expected the page to show %s", test.wantLabel)
+			}
+		})
+	}
+}
+
+func TestHandleAdminDelete(t *testing.T) {
+	h, db := adminHandler(t, 2)
+	h.HandleAdminItems(httptest.NewRecorder(), withToken(httptest.NewRequest(http.MethodGet, "/admin", nil)))
+
+	w := adminPost(h, h.HandleAdminDelete, "item00", "delete", url.Values{"return": {"/admin?q=cat"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin?q=cat" {
+		t.Fatalf("got %d to %q %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	if entry, err := h.manifest.Find("item00"); entry != nil || err != nil {
+		t.Errorf("expected the entry to be deleted, got %+v %v", entry, err)
+	}
+	if ids, _, _ := db.ListIDs("", 10, ""); !reflect.DeepEqual(ids, []string{"img-item01", "text-item01"}) {
+		t.Errorf("expected only item01's vectors to be left, got %v", ids)
+	}
+	for _, key := range []string{"indexed/item00.jpg", uploads.ThumbnailKey("item00", 256, uploads.THUMBNAIL_JPEG)} {
+		if _, err := h.store.Blobs().Get(key); !errors.Is(err, blob.ErrNotFound) {
+			t.Errorf("expected %s to be deleted, got %v", key, err)
+		}
+	}
+	page := httptest.NewRecorder()
+	h.HandleAdminItems(page, withToken(httptest.NewRequest(http.MethodGet, "/admin", nil)))
+	if got := listedItems(page.Body.String()); !reflect.DeepEqual(got, []string{"item01"}) {
+		t.Errorf("expected the page to list only item01, got %v", got)
+	}
+
+	/// The file already gone, e.g. to the retention, doesn't stop the rest being deleted
+	if err := h.store.Blobs().Delete("indexed/item01.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if w = adminPost(h, h.HandleAdminDelete, "item01", "delete", url.Values{}); w.Code != http.StatusSeeOther {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+	if entry, _ := h.manifest.Find("item01"); entry != nil {
+		t.Errorf("expected the entry to be deleted, got %+v", entry)
+	}
+	if w = adminPost(h, h.HandleAdminDelete, "item01", "delete", url.Values{}); w.Code != http.StatusNotFound {
+		t.Errorf("expected deleting again to be not found, got %d", w.Code)
+	}
+}
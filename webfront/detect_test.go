@@ -0,0 +1,108 @@
+package webfront
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"object-detection-zero-shot/vectordb"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDetectParams(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    detectParams
+		wantErr bool
+	}{
+		{"", detectParams{labels: DEFAULT_DETECT_LABELS}, false},
+		{"k=1&min_score=0.25", detectParams{labels: 1, minScore: 0.25}, false},
+		{"k=50&include=matches", detectParams{labels: 50, includeMatches: true}, false},
+		{"include=,matches", detectParams{labels: DEFAULT_DETECT_LABELS, includeMatches: true}, false},
+		{"include=matches,heatmap", detectParams{labels: DEFAULT_DETECT_LABELS, includeMatches: true, includeHeatmap: true}, false},
+		{"k=0", detectParams{}, true},
+		{"k=51", detectParams{}, true},
+		{"k=five", detectParams{}, true},
+		{"min_score=high", detectParams{}, true},
+		{"include=boxes", detectParams{}, true},
+	}
+	for _, test := range tests {
+		params, err := parseDetectParams(httptest.NewRequest("POST", "/image/detect?"+test.query, nil))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.query, params)
+			}
+			continue
+		}
+		if err != nil || *params != test.want {
+			t.Errorf("%q: got %+v %v, want %+v", test.query, params, err, test.want)
+		}
+	}
+	if topK := (&detectParams{labels: 50}).topK(); topK != 200 {
+		t.Errorf("expected 4 neighbours per label, got %d", topK)
+	}
+}
+
+func TestDetectionResponse(t *testing.T) {
+	results := []vectordb.SearchResult{
+		{ID: "img-a", Score: 0.9, Metadata: map[string]interface{}{"value": "cat"}},
+		{ID: "text-b", Score: 0.8, Metadata: map[string]interface{}{"value": "dog"}},
+		{ID: "text-a", Score: 0.7, Metadata: map[string]interface{}{"value": "cat"}},
+		{ID: "img-c", Score: 0.6, Metadata: map[string]interface{}{"value": "bird"}},
+	}
+	tests := []struct {
+		name        string
+		results     []vectordb.SearchResult
+		params      detectParams
+		wantLabels  []string
+		wantMatches int
+	}{
+		{"labels best first", results, detectParams{labels: 5}, []string{"cat", "dog", "bird"}, 0},
+		{"limited to k", results, detectParams{labels: 2}, []string{"cat", "dog"}, 0},
+		{"with matches", results, detectParams{labels: 1, includeMatches: true}, []string{"cat"}, 4},
+		{"nothing found", nil, detectParams{labels: 5}, []string{}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := detectionResponse(test.results, &test.params)
+			labels := make([]string, 0)
+			for _, label := range resp.Labels {
+				labels = append(labels, label.Label)
+			}
+			if !reflect.DeepEqual(labels, test.wantLabels) {
+				t.Errorf("got labels %v, want %v", labels, test.wantLabels)
+			}
+			if resp.Found != (len(test.wantLabels) > 0) {
+				t.Errorf("found is %t", resp.Found)
+			}
+			if resp.Found && (resp.Label != resp.Labels[0].Label || resp.Score != resp.Labels[0].Score) {
+				t.Errorf("expected label and score to be the best label's, got %s %f", resp.Label, resp.Score)
+			}
+			if len(resp.Matches) != test.wantMatches {
+				t.Errorf("got %d matches, want %d", len(resp.Matches), test.wantMatches)
+			}
+			/// Older clients read label and score, and labels is never null
+			data, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range []string{`"found":`, `"label":`, `"score":`, `"labels":[`} {
+				if !strings.Contains(string(data), field) {
+					t.Errorf("expected %s in %s", field, data)
+				}
+			}
+			if strings.Contains(string(data), `"matches"`) != (test.wantMatches > 0) {
+				t.Errorf("unexpected matches in %s", data)
+			}
+			if strings.Contains(string(data), `"heatmap"`) {
+				t.Errorf("expected the heatmap to be left out until it's made, got %s", data)
+			}
+		})
+	}
+
+	resp := detectionResponse(results, &detectParams{labels: 5})
+	want := []VectorMatch{{ID: "img-a", Kind: "image", Score: 0.9}, {ID: "text-a", Kind: "text", Score: 0.7}}
+	if !reflect.DeepEqual(resp.Labels[0].Vectors, want) {
+		t.Errorf("got vectors %+v, want %+v", resp.Labels[0].Vectors, want)
+	}
+}
@@ -2,54 +2,159 @@ package webfront
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"html/template"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/embedding"
 	"object-detection-zero-shot/middleware"
 	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"object-detection-zero-shot/vectordb"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 )
 
 type Handler struct {
-	svc       *service.Handler
-	uploadDir string
+	svc        *service.Handler
+	tmpDir     string
+	store      *uploads.Store
+	manifest   *uploads.Manifest
+	indexed    uploads.Retention /// retention of embedded images, see SetRetention
+	queries    uploads.Retention /// retention of detection images
+	limits     uploads.Limits
+	thumbnails uploads.ThumbnailConfig
+	auth       *middleware.TokenAuth /// for the item images and the admin UI, see SetAuthTokens
+	templates  *template.Template
+	entries    adminEntries
 }
 
-func NewHandler(svc *service.Handler, uploadDir string) *Handler {
+// NewHandler serves the endpoints. Uploads are stored in blobs, tmpDir only holds temporary copies.
+func NewHandler(svc *service.Handler, blobs blob.Store, tmpDir string) *Handler {
 	h := &Handler{
-		svc:       svc,
-		uploadDir: uploadDir,
+		svc:        svc,
+		tmpDir:     tmpDir,
+		store:      uploads.NewStore(blobs, tmpDir),
+		manifest:   uploads.NewManifest(blobs),
+		indexed:    uploads.Retention{Mode: uploads.RETAIN_ALL},
+		queries:    uploads.Retention{Mode: uploads.RETAIN_ALL},
+		limits:     uploads.DefaultLimits(),
+		thumbnails: uploads.DefaultThumbnailConfig(),
+		auth:       middleware.NewTokenAuth(nil),
 	}
 
 	throttleEmbed := middleware.NewThrottleMiddleware(30, 24)
 	http.HandleFunc("/image/embed", throttleEmbed.Wrap(h.HandleImageUpload))
 	throttleDetect := middleware.NewThrottleMiddleware(30, 24)
 	http.HandleFunc("/image/detect", throttleDetect.Wrap(h.HandleImageDetection))
+	throttleClassify := middleware.NewThrottleMiddleware(30, 24)
+	http.HandleFunc("/image/classify", throttleClassify.Wrap(h.HandleImageClassify))
+	throttleSimilar := middleware.NewThrottleMiddleware(30, 24)
+	http.HandleFunc("/image/similar", throttleSimilar.Wrap(h.HandleSimilarImages))
+	throttleSearch := middleware.NewThrottleMiddleware(30, 24)
+	http.HandleFunc("GET /search", throttleSearch.Wrap(h.HandleTextSearch))
+	throttleQuery := middleware.NewThrottleMiddleware(30, 24)
+	http.HandleFunc("/query", throttleQuery.Wrap(h.HandleQuery))
+	http.HandleFunc("GET /images/{id}/thumb", h.auth.Wrap(h.HandleThumbnail))
+
+	h.registerAdmin()
 
 	http.Handle("/", http.FileServer(http.Dir("/webfront/static")))
 	return h
 }
 
-func (h *Handler) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
-	defer handlers.NetHandlePanic(w)
+// SetRetention sets how long uploads are kept. Uploads with retention none are deleted as soon as they are used,
+// the other retentions are enforced by a sweep, see uploads.Store.Sweep.
+func (h *Handler) SetRetention(indexed, queries uploads.Retention) {
+	h.indexed = indexed
+	h.queries = queries
+}
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// SetLimits sets the maximum request size and image dimensions
+func (h *Handler) SetLimits(limits uploads.Limits) {
+	h.limits = limits
+}
+
+// SetThumbnails sets the thumbnails made of indexed uploads
+func (h *Handler) SetThumbnails(cfg uploads.ThumbnailConfig) {
+	h.thumbnails = cfg
+}
+
+// SetAuthTokens sets the tokens that can view the images of indexed items and use the admin UI, with none they can't be used
+func (h *Handler) SetAuthTokens(tokens []string) {
+	h.auth.SetTokens(tokens)
+}
+
+// readImage parses the form and validates its image before anything is stored or embedded.
+// If the image isn't accepted the error response has been written and ok is false.
+func (h *Handler) readImage(w http.ResponseWriter, r *http.Request) (file multipart.File, header *multipart.FileHeader, info *uploads.ImageInfo, ok bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.limits.MaxBytes)
 	// Parse multipart form with 10MB max memory
 	err := r.ParseMultipartForm(10 << 20)
+	var maxerr *http.MaxBytesError
+	if errors.As(err, &maxerr) {
+		http.Error(w, fmt.Sprintf("Request too large, the limit is %d bytes", h.limits.MaxBytes), http.StatusRequestEntityTooLarge)
+		return nil, nil, nil, false
+	}
 	if err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
-		return
+		return nil, nil, nil, false
 	}
 	// Get the file from form data
-	file, header, err := r.FormFile("image")
+	file, header, err = r.FormFile("image")
 	if err != nil {
 		http.Error(w, "Failed to get file from form", http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	info, err = uploads.Validate(file, h.limits)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	switch {
+	case err == nil:
+		return file, header, info, true
+	case errors.Is(err, uploads.ErrUnsupportedType):
+		http.Error(w, strings.ToUpper(err.Error()[:1])+err.Error()[1:], http.StatusUnsupportedMediaType)
+	case errors.Is(err, uploads.ErrTooLarge):
+		http.Error(w, strings.ToUpper(err.Error()[:1])+err.Error()[1:], http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, "Failed to read image", http.StatusBadRequest)
+	}
+	file.Close()
+	return nil, nil, nil, false
+}
+
+// discard deletes an upload that the retention doesn't keep at all. An indexed upload's manifest entry is
+// marked expired, as its vectors are kept.
+func (h *Handler) discard(retention uploads.Retention, obj *uploads.Object) {
+	if retention.Mode != uploads.RETAIN_NONE {
+		return
+	}
+	if err := h.store.Blobs().Delete(obj.Key); err != nil {
+		fmt.Println("Failed to delete upload ", obj.Key, err)
+		return
+	}
+	if strings.HasPrefix(obj.Key, uploads.CATEGORY_INDEXED+"/") {
+		if err := h.manifest.Expire(obj.ID); err != nil {
+			fmt.Println("Failed to mark upload expired ", obj.ID, err)
+		}
+	}
+}
+
+func (h *Handler) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file, header, info, ok := h.readImage(w, r)
+	if !ok {
 		return
 	}
 	defer file.Close()
@@ -59,47 +164,67 @@ func (h *Handler) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Text description is required", http.StatusBadRequest)
 		return
 	}
-	// Create sanitized ID from filename
-	filename := header.Filename
-	ext := filepath.Ext(filename)
-	baseFilename := strings.TrimSuffix(filename, ext)
-	sanitizedID := strings.Map(func(r rune) rune {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
-			return r
-		}
-		return '-'
-	}, baseFilename)
-	// Save file to disk
-	newFilename := sanitizedID + ext
-	filepath := filepath.Join(h.uploadDir, newFilename)
-	dst, err := os.Create(filepath)
+	// Store by content, the ID is derived from the bytes rather than the client's file name
+	obj, err := h.store.Save(uploads.CATEGORY_INDEXED, file, info.Ext)
 	if err != nil {
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
-		return
-	}
-	defer dst.Close()
-	if _, err = io.Copy(dst, file); err != nil {
+		fmt.Println("Failed to store upload ", err)
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
-	// Create embedding configuration
-	embedCfg := &service.EmbedCfg{
-		Items: []service.Item{
-			{
-				Imagefile: filepath,
-				Label:     text,
-				ID:        sanitizedID,
+	defer obj.Release()
+	/// The same bytes are already embedded, keep their label rather than overwrite another user's, see the admin UI to relabel
+	duplicate := false
+	label := text
+	if obj.Existed {
+		entry, err := h.manifest.Find(obj.ID)
+		handlers.PanicOnError(err)
+		if entry != nil {
+			duplicate = true
+			label = entry.Label
+		}
+	}
+	if !duplicate {
+		// Create embeddings, the vectors refer to the stored object rather than the local copy
+		err = h.svc.EmbedItem(service.Item{
+			Imagefile: obj.File,
+			Label:     text,
+			ID:        obj.ID,
+			Metadata: map[string]interface{}{
+				service.METADATA_OBJECT_KEY:        obj.Key,
+				uploads.METADATA_ORIGINAL_FILENAME: header.Filename,
 			},
-		},
+		})
+		if err != nil && !obj.Existed {
+			/// Don't keep an upload that has no vectors
+			if delerr := h.store.Blobs().Delete(obj.Key); delerr != nil {
+				fmt.Println("Failed to delete upload ", obj.Key, delerr)
+			}
+		}
+		handlers.PanicOnError(err)
+		err = h.manifest.Record(uploads.Entry{
+			ID:               obj.ID,
+			File:             obj.Key,
+			OriginalFilename: header.Filename,
+			Label:            text,
+			VectorIDs:        service.VectorIDs(obj.ID),
+		})
+		handlers.PanicOnError(err)
+	}
+	if h.indexed.Mode != uploads.RETAIN_NONE {
+		/// The upload is indexed either way, it just can't be shown until the thumbnails are made, see -thumbnails
+		if err = h.store.MakeThumbnails(obj.ID, obj.File, h.thumbnails); err != nil {
+			fmt.Println("Failed to make thumbnails ", obj.ID, err)
+		}
 	}
-	// Create embeddings
-	h.svc.EmbedData(embedCfg)
+	h.discard(h.indexed, obj)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	enc := json.NewEncoder(w)
 	resp := map[string]any{
-		"status": "success",
-		"id":     sanitizedID,
+		"status":    "success",
+		"id":        obj.ID,
+		"label":     label,
+		"duplicate": duplicate,
 	}
 	err = enc.Encode(resp)
 	if err != nil {
@@ -108,11 +233,118 @@ func (h *Handler) HandleImageUpload(w http.ResponseWriter, r *http.Request) {
 }
 
 type DectionResponse struct {
-	Found bool    `json:"found"`
-	Label string  `json:"label"`
+	Found   bool             `json:"found"`
+	Label   string           `json:"label"` /// the best of Labels, kept for older clients
+	Score   float32          `json:"score"`
+	Labels  []LabelMatch     `json:"labels"`
+	Matches []Neighbour      `json:"matches,omitempty"` /// only with include=matches
+	Heatmap *service.Heatmap `json:"heatmap,omitempty"` /// where in the image the best label matches, only with include=heatmap
+}
+
+// LabelMatch is a label ranked by its best match, with every vector that matched it
+type LabelMatch struct {
+	Label   string        `json:"label"`
+	Score   float32       `json:"score"`
+	Vectors []VectorMatch `json:"vectors"`
+}
+
+type VectorMatch struct {
+	ID    string  `json:"id"`
+	Kind  string  `json:"kind"` /// text or image, see service.VectorKind
 	Score float32 `json:"score"`
 }
 
+// Neighbour is a raw search result, for debugging
+type Neighbour struct {
+	VectorMatch
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// Detection query parameters
+const (
+	DEFAULT_DETECT_LABELS = 5
+	MAX_DETECT_LABELS     = 50
+)
+
+type detectParams struct {
+	labels         int
+	minScore       float32
+	includeMatches bool
+	includeHeatmap bool
+}
+
+// parseDetectParams reads k, the number of labels to return, min_score and include=matches,heatmap
+func parseDetectParams(r *http.Request) (*detectParams, error) {
+	params := &detectParams{labels: DEFAULT_DETECT_LABELS}
+	if k := r.FormValue("k"); k != "" {
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 1 || n > MAX_DETECT_LABELS {
+			return nil, fmt.Errorf("k must be a number from 1 to %d", MAX_DETECT_LABELS)
+		}
+		params.labels = n
+	}
+	if minscore := r.FormValue("min_score"); minscore != "" {
+		score, err := strconv.ParseFloat(minscore, 32)
+		if err != nil {
+			return nil, fmt.Errorf("min_score must be a number")
+		}
+		params.minScore = float32(score)
+	}
+	for _, include := range strings.Split(r.FormValue("include"), ",") {
+		switch strings.TrimSpace(include) {
+		case "":
+		case "matches":
+			params.includeMatches = true
+		case "heatmap":
+			params.includeHeatmap = true
+		default:
+			return nil, fmt.Errorf("unknown include %s, use matches or heatmap", include)
+		}
+	}
+	return params, nil
+}
+
+// topK searches enough neighbours to find k labels, each item has a text and an image vector
+func (p *detectParams) topK() uint32 {
+	return uint32(max(service.DEFAULT_TOPK, 4*p.labels))
+}
+
+func detectionResponse(results []vectordb.SearchResult, params *detectParams) DectionResponse {
+	scores := make(map[string]float32, len(results))
+	for _, result := range results {
+		scores[result.ID] = result.Score
+	}
+	resp := DectionResponse{
+		Labels: make([]LabelMatch, 0),
+	}
+	for _, ranked := range service.RankLabels(results) {
+		if len(resp.Labels) == params.labels {
+			break
+		}
+		label := LabelMatch{Label: ranked.Label, Score: ranked.Score, Vectors: make([]VectorMatch, 0, len(ranked.IDs))}
+		for _, id := range ranked.IDs {
+			label.Vectors = append(label.Vectors, VectorMatch{ID: id, Kind: service.VectorKind(id), Score: scores[id]})
+		}
+		resp.Labels = append(resp.Labels, label)
+	}
+	if len(resp.Labels) > 0 {
+		resp.Found = true
+		resp.Label = resp.Labels[0].Label
+		resp.Score = resp.Labels[0].Score
+	}
+	if params.includeMatches {
+		resp.Matches = make([]Neighbour, 0, len(results))
+		for _, result := range results {
+			resp.Matches = append(resp.Matches, Neighbour{
+				VectorMatch: VectorMatch{ID: result.ID, Kind: service.VectorKind(result.ID), Score: result.Score},
+				Metadata:    result.Metadata,
+			})
+		}
+	}
+	return resp
+}
+
+// HandleImageDetection returns the k best labels for the image, see parseDetectParams
 func (h *Handler) HandleImageDetection(w http.ResponseWriter, r *http.Request) {
 	defer handlers.NetHandlePanic(w)
 
@@ -120,57 +352,93 @@ func (h *Handler) HandleImageDetection(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	// Parse multipart form with 10MB max memory
-	err := r.ParseMultipartForm(10 << 20)
+	file, _, info, ok := h.readImage(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	params, err := parseDetectParams(r)
 	if err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	// Get the file from form data
-	file, header, err := r.FormFile("image")
+	// Store by content, so query images can't overwrite the indexed ones
+	obj, err := h.store.Save(uploads.CATEGORY_QUERIES, file, info.Ext)
 	if err != nil {
-		http.Error(w, "Failed to get file from form", http.StatusBadRequest)
+		fmt.Println("Failed to store upload ", err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+	defer obj.Release()
+	// Perform image detection
+	results, err := h.svc.Detect(obj.File, service.DetectOptions{TopK: params.topK(), MinScore: params.minScore})
+	h.discard(h.queries, obj)
+	handlers.PanicOnError(err)
+	resp := detectionResponse(results, params)
+	if params.includeHeatmap && resp.Found {
+		/// The labels are still worth returning without it
+		resp.Heatmap, err = h.svc.Heatmap(obj.File, resp.Label)
+		if err != nil {
+			fmt.Println("Failed to make the heatmap ", err)
+		}
+	}
+
+	// Return results as JSON
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		fmt.Println("Error writing response ", err)
+	}
+}
+
+type ClassifyResponse struct {
+	Classifications []embedding.Classification `json:"classifications"`
+}
+
+// HandleImageClassify scores the image against the comma separated candidate labels in the form.
+// Unlike detection it doesn't use the index, and the image is not kept.
+func (h *Handler) HandleImageClassify(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file, _, _, ok := h.readImage(w, r)
+	if !ok {
 		return
 	}
 	defer file.Close()
-	// Create sanitized ID from filename
-	filename := header.Filename
-	ext := filepath.Ext(filename)
-	baseFilename := strings.TrimSuffix(filename, ext)
-	sanitizedID := strings.Map(func(r rune) rune {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
-			return r
+	labels := make([]string, 0)
+	for _, label := range strings.Split(r.FormValue("labels"), ",") {
+		label = strings.TrimSpace(label)
+		if label != "" {
+			labels = append(labels, label)
 		}
-		return '-'
-	}, baseFilename)
-	// Save file to disk
-	newFilename := sanitizedID + ext
-	filepath := filepath.Join(h.uploadDir, newFilename)
-	dst, err := os.Create(filepath)
+	}
+	if len(labels) == 0 {
+		http.Error(w, "Comma separated labels are required", http.StatusBadRequest)
+		return
+	}
+	// Save to a temp file, which is removed once classified
+	dst, err := os.CreateTemp(h.tmpDir, "classify-*")
 	if err != nil {
 		http.Error(w, "Failed to create file", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
-	if _, err = io.Copy(dst, file); err != nil {
+	defer os.Remove(dst.Name())
+	_, err = io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
 		return
 	}
-	// Perform image detection
-	results := h.svc.ImageDetection(filepath)
 
-	resp := DectionResponse{
-		Found: false,
-	}
-	if len(results) > 0 {
-		resp.Found = true
-		resp.Label = results[0].Metadata["value"].(string)
-		resp.Score = results[0].Score
-	}
+	results, err := h.svc.Classify(dst.Name(), labels)
+	handlers.PanicOnError(err)
 
-	// Return results as JSON
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(resp)
+	err = json.NewEncoder(w).Encode(ClassifyResponse{Classifications: results})
 	if err != nil {
 		fmt.Println("Error writing response ", err)
 	}
@@ -0,0 +1,140 @@
+package webfront
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"strings"
+	"testing"
+)
+
+// multipartImage returns a POST with the data as the image field of the form
+func multipartImage(t *testing.T, data []byte) *http.Request {
+	return uploadRequest(t, data, "upload.png", "cat")
+}
+
+// uploadRequest returns a POST with the data as the image field of the form, under the file name and with the text
+func uploadRequest(t *testing.T, data []byte, filename, text string) *http.Request {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.WriteField("text", text)
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/image/embed", body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return r
+}
+
+func pngImage(t *testing.T, width, height int) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadImage(t *testing.T) {
+	limits := uploads.Limits{MaxBytes: 64 << 10, MaxDimension: 100, MaxPixels: 5000}
+	tests := []struct {
+		name       string
+		data       []byte
+		wantStatus int /// 0 if the image is accepted
+	}{
+		{"accepted", pngImage(t, 50, 50), 0},
+		{"not an image", []byte("#!/bin/sh\nrm -rf /\n"), http.StatusUnsupportedMediaType},
+		{"corrupt image", pngImage(t, 50, 50)[:30], http.StatusUnsupportedMediaType},
+		{"too wide", pngImage(t, 101, 1), http.StatusRequestEntityTooLarge},
+		{"too many pixels", pngImage(t, 100, 100), http.StatusRequestEntityTooLarge},
+		{"request too large", append(pngImage(t, 10, 10), make([]byte, 64<<10)...), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := &Handler{limits: limits}
+			w := httptest.NewRecorder()
+			file, _, info, ok := h.readImage(w, multipartImage(t, test.data))
+			if test.wantStatus == 0 {
+				if !ok {
+					t.Fatalf("expected the image to be accepted, got %d %s", w.Code, w.Body)
+				}
+				defer file.Close()
+				if info.Ext != ".png" || info.Width != 50 {
+					t.Errorf("unexpected info %+v", info)
+				}
+				/// The file is read again to store it
+				head := make([]byte, 4)
+				if _, err := file.Read(head); err != nil || string(head) != "\x89PNG" {
+					t.Errorf("expected the file to be rewound, got %q %v", head, err)
+				}
+				return
+			}
+			if ok {
+				file.Close()
+				t.Fatal("expected the image to be rejected")
+			}
+			if w.Code != test.wantStatus {
+				t.Errorf("got %d %s, want %d", w.Code, strings.TrimSpace(w.Body.String()), test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestHandleImageUploadDuplicate(t *testing.T) {
+	h, db := adminHandler(t, 0)
+	h.limits = uploads.DefaultLimits()
+	h.indexed = uploads.Retention{Mode: uploads.RETAIN_ALL}
+	upload := func(data []byte, filename, text string) map[string]any {
+		w := httptest.NewRecorder()
+		h.HandleImageUpload(w, uploadRequest(t, data, filename, text))
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d %s", w.Code, w.Body)
+		}
+		resp := map[string]any{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := upload(pngImage(t, 20, 20), "photo.png", "cat")
+	if first["duplicate"] != false || first["label"] != "cat" {
+		t.Errorf("unexpected first upload %v", first)
+	}
+	/// The same bytes from another client keep the first label
+	again := upload(pngImage(t, 20, 20), "copy.png", "dog")
+	if again["id"] != first["id"] || again["duplicate"] != true || again["label"] != "cat" {
+		t.Errorf("expected a duplicate labelled cat, got %v", again)
+	}
+	/// Other bytes under the same file name are another item
+	other := upload(pngImage(t, 30, 20), "photo.png", "dog")
+	if other["id"] == first["id"] || other["duplicate"] != false || other["label"] != "dog" {
+		t.Errorf("expected a new item labelled dog, got %v", other)
+	}
+
+	id := first["id"].(string)
+	entry, err := h.manifest.Find(id)
+	if err != nil || entry == nil || entry.Label != "cat" || entry.OriginalFilename != "photo.png" {
+		t.Errorf("expected the first upload's entry to be kept, got %+v %v", entry, err)
+	}
+	vectors, err := db.FetchVectors(service.VectorIDs(id))
+	if err != nil || len(vectors) != 2 {
+		t.Fatalf("expected the item's 2 vectors, got %v %v", vectors, err)
+	}
+	for _, vector := range vectors {
+		if vector.Metadata[service.METADATA_VALUE] != "cat" {
+			t.Errorf("%s is labelled %v, want cat", vector.ID, vector.Metadata[service.METADATA_VALUE])
+		}
+	}
+	if count, _ := db.VectorCount(); count != 4 {
+		t.Errorf("expected 2 items of 2 vectors, got %d vectors", count)
+	}
+}
@@ -0,0 +1,131 @@
+package webfront
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"net/http"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"strings"
+)
+
+// QueryTermRequest is a term of a composite query, set one of Text, Image and Item
+type QueryTermRequest struct {
+	Text   string   `json:"text,omitempty"`
+	Image  string   `json:"image,omitempty"`  /// base64 encoded
+	Item   string   `json:"item,omitempty"`   /// the ID of a stored item
+	Weight *float32 `json:"weight,omitempty"` /// default 1, negative to move away from the term
+}
+
+type QueryRequest struct {
+	Terms []QueryTermRequest `json:"terms"`
+}
+
+// Results of a composite query
+const (
+	QUERY_RESULTS_LABELS = "labels" /// ranked labels, as /image/detect returns
+	QUERY_RESULTS_IMAGES = "images" /// stored images, as /search returns
+)
+
+// HandleQuery searches with a weighted combination of images and texts posted as a QueryRequest.
+// results=labels takes the /image/detect parameters, results=images the /search ones.
+func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.limits.MaxBytes)
+	req := QueryRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	var maxerr *http.MaxBytesError
+	if errors.As(err, &maxerr) {
+		http.Error(w, fmt.Sprintf("Request too large, the limit is %d bytes", h.limits.MaxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse query", http.StatusBadRequest)
+		return
+	}
+	if len(req.Terms) == 0 || len(req.Terms) > service.MAX_QUERY_TERMS {
+		http.Error(w, fmt.Sprintf("A query needs 1 to %d terms", service.MAX_QUERY_TERMS), http.StatusBadRequest)
+		return
+	}
+	terms := make([]service.QueryTerm, 0, len(req.Terms))
+	for _, t := range req.Terms {
+		term := service.QueryTerm{Text: strings.TrimSpace(t.Text), ItemID: t.Item, Weight: 1}
+		if t.Weight != nil {
+			term.Weight = *t.Weight
+		}
+		if t.Image != "" {
+			obj, status, err := h.saveQueryImage(t.Image)
+			if err != nil {
+				http.Error(w, strings.ToUpper(err.Error()[:1])+err.Error()[1:], status)
+				return
+			}
+			defer obj.Release()
+			defer h.discard(h.queries, obj)
+			term.Imagefile = obj.File
+		}
+		terms = append(terms, term)
+	}
+
+	switch results := r.FormValue("results"); results {
+	case "", QUERY_RESULTS_LABELS:
+		params, err := parseDetectParams(r)
+		if err == nil && params.includeHeatmap {
+			err = fmt.Errorf("include=heatmap needs a single image, use /image/detect")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results, err := h.svc.Query(terms, service.DetectOptions{TopK: params.topK(), MinScore: params.minScore})
+		handlers.PanicOnError(err)
+		writeJSON(w, detectionResponse(results, params))
+	case QUERY_RESULTS_IMAGES:
+		page, pagesize, err := parsePage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := service.ParseFilter(r.Form["filter"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results, err := h.svc.QueryImages(terms, filter, (page-1)*pagesize, pagesize)
+		handlers.PanicOnError(err)
+		writeJSON(w, searchResponse(results, page, pagesize))
+	default:
+		http.Error(w, fmt.Sprintf("Unknown results %s, use labels or images", results), http.StatusBadRequest)
+	}
+}
+
+// saveQueryImage validates and stores a base64 encoded query image, returning the status to respond with if it isn't accepted
+func (h *Handler) saveQueryImage(encoded string) (*uploads.Object, int, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("query images must be base64 encoded")
+	}
+	info, err := uploads.Validate(bytes.NewReader(data), h.limits)
+	switch {
+	case errors.Is(err, uploads.ErrUnsupportedType):
+		return nil, http.StatusUnsupportedMediaType, err
+	case errors.Is(err, uploads.ErrTooLarge):
+		return nil, http.StatusRequestEntityTooLarge, err
+	case err != nil:
+		return nil, http.StatusBadRequest, fmt.Errorf("failed to read image")
+	}
+	obj, err := h.store.Save(uploads.CATEGORY_QUERIES, bytes.NewReader(data), info.Ext)
+	if err != nil {
+		fmt.Println("Failed to store upload ", err)
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to save file")
+	}
+	return obj, http.StatusOK, nil
+}
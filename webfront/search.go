@@ -0,0 +1,183 @@
+package webfront
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/paul-at-nangalan/errorhandler/handlers"
+	"io"
+	"net/http"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/service"
+	"object-detection-zero-shot/uploads"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search result paging
+const (
+	DEFAULT_PAGE_SIZE = 20
+	MAX_PAGE_SIZE     = 100
+)
+
+// SearchItem is a stored item found by a search
+type SearchItem struct {
+	ID        string  `json:"id"`
+	Label     string  `json:"label"`
+	Score     float32 `json:"score"`
+	Thumbnail string  `json:"thumbnail,omitempty"` /// only for uploaded items
+}
+
+type SearchResponse struct {
+	Items    []SearchItem `json:"items"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	NextPage int          `json:"next_page,omitempty"` /// 0 on the last page
+}
+
+// parsePage reads the 1 based page and page_size
+func parsePage(r *http.Request) (page, pagesize int, err error) {
+	page, pagesize = 1, DEFAULT_PAGE_SIZE
+	if p := r.FormValue("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("page must be a number from 1")
+		}
+	}
+	if size := r.FormValue("page_size"); size != "" {
+		pagesize, err = strconv.Atoi(size)
+		if err != nil || pagesize < 1 || pagesize > MAX_PAGE_SIZE {
+			return 0, 0, fmt.Errorf("page_size must be a number from 1 to %d", MAX_PAGE_SIZE)
+		}
+	}
+	if page*pagesize > service.MAX_SEARCH_RESULTS {
+		return 0, 0, fmt.Errorf("only the best %d results can be paged through", service.MAX_SEARCH_RESULTS)
+	}
+	return page, pagesize, nil
+}
+
+// ThumbnailURL is where the thumbnail of an uploaded item is served
+func ThumbnailURL(id string) string {
+	return "/images/" + id + "/thumb"
+}
+
+func searchResponse(results *service.SearchPage, page, pagesize int) SearchResponse {
+	resp := SearchResponse{
+		Items:    make([]SearchItem, 0, len(results.Results)),
+		Page:     page,
+		PageSize: pagesize,
+	}
+	for _, result := range results.Results {
+		item := SearchItem{ID: service.ItemID(result.ID), Score: result.Score}
+		item.Label, _ = result.Metadata[service.METADATA_VALUE].(string)
+		if _, ok := result.Metadata[service.METADATA_OBJECT_KEY]; ok {
+			item.Thumbnail = ThumbnailURL(item.ID)
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	if results.More {
+		resp.NextPage = page + 1
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, resp any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		fmt.Println("Error writing response ", err)
+	}
+}
+
+// HandleSimilarImages returns the stored images nearest to the image, a page at a time, see parsePage
+func (h *Handler) HandleSimilarImages(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file, _, info, ok := h.readImage(w, r)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	page, pagesize, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	obj, err := h.store.Save(uploads.CATEGORY_QUERIES, file, info.Ext)
+	if err != nil {
+		fmt.Println("Failed to store upload ", err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return
+	}
+	defer obj.Release()
+	results, err := h.svc.SimilarImages(obj.File, (page-1)*pagesize, pagesize)
+	h.discard(h.queries, obj)
+	handlers.PanicOnError(err)
+
+	writeJSON(w, searchResponse(results, page, pagesize))
+}
+
+// HandleTextSearch returns the stored images that best match the text in q, a page at a time, see parsePage.
+// Each filter=key:value restricts the results to items with that metadata value, e.g. filter=label:forklift
+func (h *Handler) HandleTextSearch(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	text := strings.TrimSpace(r.FormValue("q"))
+	if text == "" {
+		http.Error(w, "The query q is required", http.StatusBadRequest)
+		return
+	}
+	page, pagesize, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := service.ParseFilter(r.Form["filter"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := h.svc.SearchText(text, filter, (page-1)*pagesize, pagesize)
+	handlers.PanicOnError(err)
+
+	writeJSON(w, searchResponse(results, page, pagesize))
+}
+
+// HandleThumbnail serves a thumbnail of an uploaded item, the smallest unless size is set to another configured size.
+// Thumbnails only change if the format does, so they can be cached.
+func (h *Handler) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+	defer handlers.NetHandlePanic(w)
+
+	id := r.PathValue("id")
+	size := h.thumbnails.Sizes[0]
+	if s := r.FormValue("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || !h.thumbnails.HasSize(size) {
+			http.Error(w, fmt.Sprintf("Unknown size %s, thumbnails are made at %v pixels", s, h.thumbnails.Sizes), http.StatusBadRequest)
+			return
+		}
+	}
+	thumb, err := h.store.Thumbnail(id, size, h.thumbnails.Format)
+	if errors.Is(err, blob.ErrNotFound) {
+		/// e.g. deleted by the retention, or uploaded before thumbnails were made
+		http.NotFound(w, r)
+		return
+	}
+	handlers.PanicOnError(err)
+	defer thumb.Close()
+	data, err := io.ReadAll(thumb)
+	handlers.PanicOnError(err)
+
+	w.Header().Set("Content-Type", h.thumbnails.Format.ContentType())
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d-%s"`, id, size, h.thumbnails.Format))
+	/// ServeContent answers If-None-Match with 304 Not Modified
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
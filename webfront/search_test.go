@@ -0,0 +1,59 @@
+package webfront
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"object-detection-zero-shot/blob"
+	"object-detection-zero-shot/uploads"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandleThumbnail(t *testing.T) {
+	store := uploads.NewStore(blob.NewLocalStore(t.TempDir()), t.TempDir())
+	cfg := uploads.ThumbnailConfig{Sizes: []int{64, 256}, Format: uploads.THUMBNAIL_JPEG}
+	for _, size := range cfg.Sizes {
+		if err := store.Blobs().Put(uploads.ThumbnailKey("abc", size, cfg.Format), strings.NewReader(strconv.Itoa(size))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := &Handler{store: store, thumbnails: cfg}
+	tests := []struct {
+		name        string
+		id          string
+		query       string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    []byte
+	}{
+		{"smallest size by default", "abc", "", "", http.StatusOK, []byte("64")},
+		{"a configured size", "abc", "?size=256", "", http.StatusOK, []byte("256")},
+		{"an unknown size", "abc", "?size=128", "", http.StatusBadRequest, nil},
+		{"not a size", "abc", "?size=large", "", http.StatusBadRequest, nil},
+		{"no thumbnail", "def", "", "", http.StatusNotFound, nil},
+		{"not modified", "abc", "", `"abc-64-jpeg"`, http.StatusNotModified, []byte{}},
+		{"another size is modified", "abc", "?size=256", `"abc-64-jpeg"`, http.StatusOK, []byte("256")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/images/"+test.id+"/thumb"+test.query, nil)
+			r.SetPathValue("id", test.id)
+			if test.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			h.HandleThumbnail(w, r)
+			if w.Code != test.wantStatus {
+				t.Fatalf("got %d %s, want %d", w.Code, w.Body, test.wantStatus)
+			}
+			if test.wantBody != nil && !bytes.Equal(w.Body.Bytes(), test.wantBody) {
+				t.Errorf("got body %v, want %v", w.Body.Bytes(), test.wantBody)
+			}
+			if w.Code == http.StatusOK && (w.Header().Get("Content-Type") != "image/jpeg" || w.Header().Get("ETag") == "") {
+				t.Errorf("unexpected headers %v", w.Header())
+			}
+		})
+	}
+}